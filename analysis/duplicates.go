@@ -0,0 +1,169 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/camptocamp/terraboard/types"
+	"github.com/camptocamp/terraboard/util"
+)
+
+// IdentityKeys are the attributes identifying the cloud objects
+// managed by the resources
+var IdentityKeys = []string{"id", "arn", "self_link"}
+
+// ignoredProviders manage no cloud objects, their ids being random
+// or derived from their arguments
+var ignoredProviders = map[string]bool{
+	"archive":   true,
+	"cloudinit": true,
+	"external":  true,
+	"local":     true,
+	"null":      true,
+	"random":    true,
+	"template":  true,
+	"terraform": true,
+	"time":      true,
+	"tls":       true,
+}
+
+// Return the value of an identity attribute, empty when it
+// identifies nothing
+func identityValue(value string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return ""
+	}
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return fmt.Sprint(val)
+	}
+	return ""
+}
+
+// LineageLink returns the page of a lineage in the web UI
+func LineageLink(lineage string) string {
+	return util.GetFullPath("lineage/" + url.PathEscape(lineage))
+}
+
+// Duplicates returns the cloud objects managed by more than one State or
+// address, given the identity attributes of the resources of the latest
+// version of the lineages. The objects of a type sharing the same owners
+// are reported once, with all their identities and their owners sorted by
+// lineage and address, and the objects managed by the most lineages come first.
+func Duplicates(ids []types.ResourceIdentity) []types.Duplicate {
+	type object struct {
+		resourceType string
+		key, value   string
+		owners       []string
+	}
+	owners := make(map[string]types.DuplicateOwner)
+	objects := make(map[string]*object)
+	var keys []string
+	for _, id := range ids {
+		if ignoredProviders[strings.SplitN(id.ResourceType, "_", 2)[0]] {
+			continue
+		}
+		value := identityValue(id.Value)
+		if value == "" {
+			continue
+		}
+
+		// A lineage found at several paths owns its resources once
+		address := types.Resource{
+			Mode:  types.ResourceModeManaged,
+			Type:  id.ResourceType,
+			Name:  id.ResourceName,
+			Index: id.ResourceIndex,
+		}.Address(id.ModulePath)
+		owner := id.LineageValue + "\x00" + address
+		if _, ok := owners[owner]; !ok {
+			owners[owner] = types.DuplicateOwner{
+				Path:         id.Path,
+				VersionID:    id.VersionID,
+				LineageValue: id.LineageValue,
+				Address:      address,
+				Link:         LineageLink(id.LineageValue),
+			}
+		}
+
+		k := strings.Join([]string{id.ResourceType, id.Key, value}, "\x00")
+		o, ok := objects[k]
+		if !ok {
+			o = &object{resourceType: id.ResourceType, key: id.Key, value: value}
+			objects[k] = o
+			keys = append(keys, k)
+		}
+		o.owners = append(o.owners, owner)
+	}
+
+	duplicates := make(map[string]*types.Duplicate)
+	var groups []string
+	for _, k := range keys {
+		o := objects[k]
+		sort.Strings(o.owners)
+		o.owners = uniq(o.owners)
+		if len(o.owners) < 2 {
+			continue
+		}
+
+		g := o.resourceType + "\x00" + strings.Join(o.owners, "\x01")
+		d, ok := duplicates[g]
+		if !ok {
+			d = &types.Duplicate{
+				ResourceType: o.resourceType,
+				Identities:   make(map[string]string),
+			}
+			lineages := make(map[string]bool)
+			for _, owner := range o.owners {
+				d.Owners = append(d.Owners, owners[owner])
+				lineages[owners[owner].LineageValue] = true
+			}
+			d.Lineages = len(lineages)
+			duplicates[g] = d
+			groups = append(groups, g)
+		}
+		d.Identities[o.key] = o.value
+	}
+
+	result := make([]types.Duplicate, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, *duplicates[g])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Lineages != result[j].Lineages {
+			return result[i].Lineages > result[j].Lineages
+		}
+		if result[i].ResourceType != result[j].ResourceType {
+			return result[i].ResourceType < result[j].ResourceType
+		}
+		return firstIdentity(result[i]) < firstIdentity(result[j])
+	})
+	return result
+}
+
+// Return the first identity of a Duplicate, in the order of IdentityKeys
+func firstIdentity(d types.Duplicate) string {
+	for _, k := range IdentityKeys {
+		if v, ok := d.Identities[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// Remove the consecutive duplicates of a sorted slice
+func uniq(s []string) []string {
+	out := s[:0]
+	for _, v := range s {
+		if len(out) == 0 || v != out[len(out)-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,58 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/camptocamp/terraboard/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicates(t *testing.T) {
+	id := func(path, lineage, module, resourceType, name, index, key, value string) types.ResourceIdentity {
+		return types.ResourceIdentity{
+			Path: path, VersionID: "v-" + path, LineageValue: lineage, ModulePath: module,
+			ResourceType: resourceType, ResourceName: name, ResourceIndex: index, Key: key, Value: value,
+		}
+	}
+	ids := []types.ResourceIdentity{
+		// The same bucket imported in two stacks
+		id("network.tfstate", "l1", "", "aws_s3_bucket", "logs", "", "arn", `"arn:aws:s3:::logs"`),
+		id("network.tfstate", "l1", "", "aws_s3_bucket", "logs", "", "id", `"logs"`),
+		id("legacy.tfstate", "l2", "module.old", "aws_s3_bucket", "logs", `["a"]`, "arn", `"arn:aws:s3:::logs"`),
+		id("legacy.tfstate", "l2", "module.old", "aws_s3_bucket", "logs", `["a"]`, "id", `"logs"`),
+		// Twice in the same stack
+		id("app.tfstate", "l3", "", "aws_security_group", "web", "[0]", "id", `"sg-1"`),
+		id("app.tfstate", "l3", "", "aws_security_group", "web2", "", "id", `"sg-1"`),
+		// The same lineage at two paths
+		id("app.tfstate", "l3", "", "aws_instance", "web", "", "id", `"i-1"`),
+		id("copy/app.tfstate", "l3", "", "aws_instance", "web", "", "id", `"i-1"`),
+		// Same id, different types
+		id("network.tfstate", "l1", "", "aws_s3_bucket_policy", "logs", "", "id", `"logs"`),
+		// Not cloud objects
+		id("network.tfstate", "l1", "", "null_resource", "a", "", "id", `"1"`),
+		id("legacy.tfstate", "l2", "", "null_resource", "a", "", "id", `"1"`),
+		id("network.tfstate", "l1", "", "aws_instance", "a", "", "id", `""`),
+		id("legacy.tfstate", "l2", "", "aws_instance", "a", "", "id", `""`),
+	}
+
+	assert.Equal(t, []types.Duplicate{
+		{
+			ResourceType: "aws_s3_bucket",
+			Identities:   map[string]string{"arn": "arn:aws:s3:::logs", "id": "logs"},
+			Owners: []types.DuplicateOwner{
+				{Path: "network.tfstate", VersionID: "v-network.tfstate", LineageValue: "l1", Address: "aws_s3_bucket.logs", Link: "lineage/l1"},
+				{Path: "legacy.tfstate", VersionID: "v-legacy.tfstate", LineageValue: "l2", Address: `module.old.aws_s3_bucket.logs["a"]`, Link: "lineage/l2"},
+			},
+			Lineages: 2,
+		},
+		{
+			ResourceType: "aws_security_group",
+			Identities:   map[string]string{"id": "sg-1"},
+			Owners: []types.DuplicateOwner{
+				{Path: "app.tfstate", VersionID: "v-app.tfstate", LineageValue: "l3", Address: "aws_security_group.web2", Link: "lineage/l3"},
+				{Path: "app.tfstate", VersionID: "v-app.tfstate", LineageValue: "l3", Address: "aws_security_group.web[0]", Link: "lineage/l3"},
+			},
+			Lineages: 1,
+		},
+	}, Duplicates(ids))
+}
@@ -0,0 +1,107 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/types"
+	"github.com/hashicorp/go-version"
+)
+
+// StaleOptions are the thresholds of the stale state report
+type StaleOptions struct {
+	Days         int
+	MinTFVersion string
+}
+
+var defaultStaleOptions = StaleOptions{Days: 90}
+
+// Setup sets up the default thresholds of the analyses
+func Setup(c *config.Config) error {
+	opts := StaleOptions{
+		Days:         int(c.Stale.Days),
+		MinTFVersion: c.Stale.MinTFVersion,
+	}
+	if _, err := opts.minVersion(); err != nil {
+		return err
+	}
+	defaultStaleOptions = opts
+	return nil
+}
+
+// DefaultStaleOptions returns the configured thresholds of the stale state report
+func DefaultStaleOptions() StaleOptions {
+	return defaultStaleOptions
+}
+
+// Return the parsed Terraform version floor, nil when unset
+func (o StaleOptions) minVersion() (*version.Version, error) {
+	if o.MinTFVersion == "" {
+		return nil, nil
+	}
+	v, err := version.NewVersion(o.MinTFVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum Terraform version %q: %v", o.MinTFVersion, err)
+	}
+	return v, nil
+}
+
+// Stale returns the report of the lineages whose latest version is older
+// than the threshold, which manage no resources, which use a Terraform
+// version below the floor, or whose path is no longer listed by the
+// providers, given the latest version of all the lineages. The paths are
+// only checked when paths is not nil. The oldest lineages come first.
+func Stale(stats []types.StateStat, paths map[string]bool, opts StaleOptions, now time.Time) (report types.StaleReport, err error) {
+	minVersion, err := opts.minVersion()
+	if err != nil {
+		return
+	}
+
+	report = types.StaleReport{
+		Days:         opts.Days,
+		MinTFVersion: opts.MinTFVersion,
+		PathsChecked: paths != nil,
+		Lineages:     []types.StaleLineage{},
+	}
+	for _, st := range stats {
+		age := int(now.Sub(st.LastModified).Hours() / 24)
+		var flags []string
+		if age >= opts.Days {
+			flags = append(flags, types.StaleFlagStale)
+		}
+		if st.ResourceCount == 0 {
+			flags = append(flags, types.StaleFlagEmpty)
+		}
+		if minVersion != nil {
+			// States without a version predate Terraform 0.12
+			if v, err := version.NewVersion(st.TFVersion); err != nil || v.LessThan(minVersion) {
+				flags = append(flags, types.StaleFlagOutdated)
+			}
+		}
+		if paths != nil && !paths[st.Path] {
+			flags = append(flags, types.StaleFlagMissing)
+		}
+		if len(flags) == 0 {
+			continue
+		}
+
+		report.Lineages = append(report.Lineages, types.StaleLineage{
+			Path:          st.Path,
+			LineageValue:  st.LineageValue,
+			TFVersion:     st.TFVersion,
+			Serial:        st.Serial,
+			VersionID:     st.VersionID,
+			LastModified:  st.LastModified,
+			AgeDays:       age,
+			ResourceCount: st.ResourceCount,
+			Flags:         flags,
+			Link:          LineageLink(st.LineageValue),
+		})
+	}
+	sort.SliceStable(report.Lineages, func(i, j int) bool {
+		return report.Lineages[i].LastModified.Before(report.Lineages[j].LastModified)
+	})
+	return
+}
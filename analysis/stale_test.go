@@ -0,0 +1,59 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStale(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	stats := []types.StateStat{
+		{Path: "old.tfstate", LineageValue: "l1", TFVersion: "1.5.0", Serial: 12, VersionID: "v1", LastModified: now.AddDate(0, 0, -200), ResourceCount: 3},
+		{Path: "empty.tfstate", LineageValue: "l2", TFVersion: "1.5.0", Serial: 40, VersionID: "v2", LastModified: now.AddDate(0, 0, -10)},
+		{Path: "legacy.tfstate", LineageValue: "l3", TFVersion: "0.13.7", Serial: 3, VersionID: "v3", LastModified: now.AddDate(0, 0, -30), ResourceCount: 1},
+		{Path: "fine.tfstate", LineageValue: "l4", TFVersion: "1.6.2", Serial: 7, VersionID: "v4", LastModified: now.AddDate(0, 0, -1), ResourceCount: 8},
+		{Path: "moved.tfstate", LineageValue: "l5", TFVersion: "1.6.2", Serial: 9, VersionID: "v5", LastModified: now.AddDate(0, 0, -2), ResourceCount: 2},
+	}
+	paths := map[string]bool{"old.tfstate": true, "empty.tfstate": true, "legacy.tfstate": true, "fine.tfstate": true}
+
+	report, err := Stale(stats, paths, StaleOptions{Days: 90, MinTFVersion: "1.0.0"}, now)
+	assert.Nil(t, err)
+	assert.True(t, report.PathsChecked)
+
+	var flags [][]string
+	for _, l := range report.Lineages {
+		flags = append(flags, append([]string{l.Path}, l.Flags...))
+	}
+	assert.Equal(t, [][]string{
+		{"old.tfstate", types.StaleFlagStale},
+		{"legacy.tfstate", types.StaleFlagOutdated},
+		{"empty.tfstate", types.StaleFlagEmpty},
+		{"moved.tfstate", types.StaleFlagMissing},
+	}, flags)
+	assert.Equal(t, 200, report.Lineages[0].AgeDays)
+	assert.Equal(t, int64(12), report.Lineages[0].Serial)
+	assert.Equal(t, "lineage/l1", report.Lineages[0].Link)
+
+	// Without a floor nor the listed paths
+	report, err = Stale(stats, nil, StaleOptions{Days: 20}, now)
+	assert.Nil(t, err)
+	assert.False(t, report.PathsChecked)
+	assert.Len(t, report.Lineages, 3)
+
+	_, err = Stale(stats, nil, StaleOptions{Days: 20, MinTFVersion: "latest"}, now)
+	assert.NotNil(t, err)
+}
+
+func TestSetup(t *testing.T) {
+	defer func() { defaultStaleOptions = StaleOptions{Days: 90} }()
+
+	assert.Nil(t, Setup(&config.Config{Stale: config.StaleConfig{Days: 30, MinTFVersion: "1.3.0"}}))
+	assert.Equal(t, StaleOptions{Days: 30, MinTFVersion: "1.3.0"}, DefaultStaleOptions())
+
+	assert.NotNil(t, Setup(&config.Config{Stale: config.StaleConfig{MinTFVersion: "x"}}))
+	assert.Equal(t, 30, DefaultStaleOptions().Days)
+}
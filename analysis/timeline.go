@@ -0,0 +1,237 @@
+package analysis
+
+import (
+	"sort"
+
+	"github.com/camptocamp/terraboard/types"
+)
+
+// timeline is a LineageTimeline being built
+type timeline struct {
+	types.LineageTimeline
+	paths  map[string]int    // index of each path in Paths
+	firsts map[string]int    // index of the event of the first version at each path
+	order  map[string][2]int // rank of the first and last versions at each path
+	last   *types.StateStat
+}
+
+// Timelines returns the path timelines of the lineages, given the versions
+// of the States and the paths listed by the providers. The versions
+// written at the same paths by other lineages tell which lineage replaced
+// which. A lineage written at a new path is copied rather than moved when
+// the previous path was written again afterwards, or when both paths still
+// hold the lineage and are listed by the providers.
+// Some providers only know when a version was synced, not when it was
+// written, so the versions of a lineage are ordered by serial, then by
+// modification time. Serial regressions are flagged at each path, where
+// the versions are synced in the order they were written.
+func Timelines(versions []types.StateStat, paths []types.StatePath) map[string]*types.LineageTimeline {
+	// A path listed by several providers is present while any lists it
+	listed := make(map[string]types.StatePath)
+	for _, sp := range paths {
+		if l, ok := listed[sp.Path]; ok && l.RemovedAt == nil {
+			continue
+		}
+		listed[sp.Path] = sp
+	}
+
+	sorted := make([]types.StateStat, len(versions))
+	copy(sorted, versions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].LastModified.Before(sorted[j].LastModified)
+	})
+	regressions := serialRegressions(sorted)
+	orderBySerial(sorted)
+
+	holders := make(map[string]string) // lineage of the latest version at each path
+	timelines := make(map[string]*timeline)
+	var order []string
+	for i := range sorted {
+		v := &sorted[i]
+		tl, ok := timelines[v.LineageValue]
+		if !ok {
+			tl = &timeline{
+				LineageTimeline: types.LineageTimeline{
+					LineageValue: v.LineageValue,
+					Paths:        []types.LineagePath{},
+					Events:       []types.LineageEvent{},
+					Anomalies:    []types.LineageAnomaly{},
+					Link:         LineageLink(v.LineageValue),
+				},
+				paths:  make(map[string]int),
+				firsts: make(map[string]int),
+				order:  make(map[string][2]int),
+			}
+			timelines[v.LineageValue] = tl
+			order = append(order, v.LineageValue)
+		}
+
+		event := types.LineageEvent{
+			Path:         v.Path,
+			VersionID:    v.VersionID,
+			Serial:       v.Serial,
+			LastModified: v.LastModified,
+		}
+		idx, seen := tl.paths[v.Path]
+		if !seen {
+			idx = len(tl.Paths)
+			tl.paths[v.Path] = idx
+			sp, ok := listed[v.Path]
+			tl.Paths = append(tl.Paths, types.LineagePath{
+				Path:          v.Path,
+				FirstModified: v.LastModified,
+				Listed:        ok && sp.RemovedAt == nil,
+				RemovedAt:     sp.RemovedAt,
+			})
+			event.Type = types.LineageEventCreated
+			if tl.last != nil {
+				event.Type = types.LineageEventMoved
+				event.PreviousPath = tl.last.Path
+			}
+		}
+		if holder := holders[v.Path]; holder != "" && holder != v.LineageValue {
+			event.Type = types.LineageEventReplaced
+			event.PreviousLineage = holder
+			previous := timelines[holder]
+			previous.Paths[previous.paths[v.Path]].ReplacedBy = v.LineageValue
+		}
+		if !seen {
+			tl.firsts[v.Path] = len(tl.Events)
+		}
+		if event.Type != "" {
+			tl.Events = append(tl.Events, event)
+		}
+		holders[v.Path] = v.LineageValue
+
+		p := &tl.Paths[idx]
+		if v.LastModified.Before(p.FirstModified) {
+			p.FirstModified = v.LastModified
+		}
+		if !v.LastModified.Before(p.LastModified) {
+			p.LastModified = v.LastModified
+			p.Serial = v.Serial
+		}
+		p.Versions++
+		p.ReplacedBy = ""
+		rank := tl.order[v.Path]
+		if !seen {
+			rank[0] = i
+		}
+		rank[1] = i
+		tl.order[v.Path] = rank
+		tl.last = v
+	}
+
+	res := make(map[string]*types.LineageTimeline, len(timelines))
+	for _, l := range order {
+		tl := timelines[l]
+		tl.Anomalies = append(tl.Anomalies, regressions[l]...)
+		tl.detectCopies()
+		res[l] = &tl.LineageTimeline
+	}
+	return res
+}
+
+// Return the serial regressions of the versions sorted by modification
+// time, by lineage: the versions whose serial is lower than the one of the
+// previous version of their lineage at the same path
+func serialRegressions(versions []types.StateStat) map[string][]types.LineageAnomaly {
+	regressions := make(map[string][]types.LineageAnomaly)
+	serials := make(map[[2]string]int64) // by lineage and path
+	for i := range versions {
+		v := &versions[i]
+		key := [2]string{v.LineageValue, v.Path}
+		if previous, ok := serials[key]; ok && v.Serial < previous {
+			a := anomaly(types.LineageAnomalySerialRegression, v)
+			a.PreviousSerial = previous
+			regressions[v.LineageValue] = append(regressions[v.LineageValue], a)
+		}
+		serials[key] = v.Serial
+	}
+	return regressions
+}
+
+// Reorder the versions of each lineage by serial, keeping the positions of
+// the versions of each lineage, and so how lineages follow each other at
+// their paths. The sort is stable, versions with the same serial keeping
+// their order.
+func orderBySerial(versions []types.StateStat) {
+	positions := make(map[string][]int)
+	for i, v := range versions {
+		positions[v.LineageValue] = append(positions[v.LineageValue], i)
+	}
+	for _, idx := range positions {
+		lv := make([]types.StateStat, len(idx))
+		for k, i := range idx {
+			lv[k] = versions[i]
+		}
+		sort.SliceStable(lv, func(a, b int) bool {
+			return lv[a].Serial < lv[b].Serial
+		})
+		for k, i := range idx {
+			versions[i] = lv[k]
+		}
+	}
+}
+
+// Flag the paths sharing the lineage with a previous one, and turn the
+// moves to them into copies
+func (tl *timeline) detectCopies() {
+	live := func(p types.LineagePath) bool {
+		return p.ReplacedBy == "" && p.Listed
+	}
+	for j := 1; j < len(tl.Paths); j++ {
+		q := tl.Paths[j]
+		for _, p := range tl.Paths[:j] {
+			if tl.order[p.Path][1] < tl.order[q.Path][0] && !(live(p) && live(q)) {
+				continue
+			}
+			e := &tl.Events[tl.firsts[q.Path]]
+			a := anomaly(types.LineageAnomalyCopied, &types.StateStat{
+				Path:         e.Path,
+				LineageValue: tl.LineageValue,
+				Serial:       e.Serial,
+				VersionID:    e.VersionID,
+				LastModified: e.LastModified,
+			})
+			a.OtherPath = p.Path
+			tl.Anomalies = append(tl.Anomalies, a)
+			if e.Type == types.LineageEventMoved {
+				e.Type = types.LineageEventCopied
+				e.PreviousPath = p.Path
+			}
+			break
+		}
+	}
+	sort.SliceStable(tl.Anomalies, func(i, j int) bool {
+		return tl.Anomalies[i].LastModified.Before(tl.Anomalies[j].LastModified)
+	})
+}
+
+// Return an anomaly of a version of a lineage
+func anomaly(flag string, v *types.StateStat) types.LineageAnomaly {
+	return types.LineageAnomaly{
+		Flag:         flag,
+		LineageValue: v.LineageValue,
+		Path:         v.Path,
+		VersionID:    v.VersionID,
+		Serial:       v.Serial,
+		LastModified: v.LastModified,
+		Link:         LineageLink(v.LineageValue),
+	}
+}
+
+// LineageAnomalies returns the anomalies of all the lineages, the newest first
+func LineageAnomalies(timelines map[string]*types.LineageTimeline) []types.LineageAnomaly {
+	anomalies := []types.LineageAnomaly{}
+	for _, tl := range timelines {
+		anomalies = append(anomalies, tl.Anomalies...)
+	}
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		if !anomalies[i].LastModified.Equal(anomalies[j].LastModified) {
+			return anomalies[i].LastModified.After(anomalies[j].LastModified)
+		}
+		return anomalies[i].LineageValue < anomalies[j].LineageValue
+	})
+	return anomalies
+}
@@ -0,0 +1,123 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimelines(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC)
+	}
+	removed := day(20)
+	versions := []types.StateStat{
+		// Moved from old/ to new/, the old key being deleted
+		{Path: "old/app.tfstate", LineageValue: "moved", Serial: 1, VersionID: "v1", LastModified: day(1)},
+		{Path: "old/app.tfstate", LineageValue: "moved", Serial: 2, VersionID: "v2", LastModified: day(2)},
+		{Path: "new/app.tfstate", LineageValue: "moved", Serial: 3, VersionID: "v3", LastModified: day(3)},
+		// Copied to a second key, both written afterwards
+		{Path: "net.tfstate", LineageValue: "copied", Serial: 1, VersionID: "v4", LastModified: day(4)},
+		{Path: "net-copy.tfstate", LineageValue: "copied", Serial: 1, VersionID: "v5", LastModified: day(5)},
+		{Path: "net.tfstate", LineageValue: "copied", Serial: 2, VersionID: "v6", LastModified: day(6)},
+		// Replaced by another lineage, with a serial regression
+		{Path: "db.tfstate", LineageValue: "first", Serial: 8, VersionID: "v7", LastModified: day(7)},
+		{Path: "db.tfstate", LineageValue: "second", Serial: 1, VersionID: "v8", LastModified: day(8)},
+		{Path: "db.tfstate", LineageValue: "second", Serial: 4, VersionID: "v9", LastModified: day(9)},
+		{Path: "db.tfstate", LineageValue: "second", Serial: 2, VersionID: "v10", LastModified: day(10)},
+	}
+	paths := []types.StatePath{
+		{Path: "old/app.tfstate", RemovedAt: &removed},
+		{Path: "new/app.tfstate", Provider: "s3-0"},
+		{Path: "new/app.tfstate", Provider: "s3-1", RemovedAt: &removed},
+		{Path: "net.tfstate"},
+		{Path: "net-copy.tfstate"},
+		{Path: "db.tfstate"},
+	}
+
+	timelines := Timelines(versions, paths)
+	assert.Len(t, timelines, 4)
+
+	moved := timelines["moved"]
+	assert.Equal(t, []types.LineageEvent{
+		{Type: types.LineageEventCreated, Path: "old/app.tfstate", VersionID: "v1", Serial: 1, LastModified: day(1)},
+		{Type: types.LineageEventMoved, Path: "new/app.tfstate", PreviousPath: "old/app.tfstate", VersionID: "v3", Serial: 3, LastModified: day(3)},
+	}, moved.Events)
+	assert.Len(t, moved.Paths, 2)
+	assert.Equal(t, 2, moved.Paths[0].Versions)
+	assert.False(t, moved.Paths[0].Listed)
+	assert.Equal(t, &removed, moved.Paths[0].RemovedAt)
+	assert.True(t, moved.Paths[1].Listed)
+	assert.Empty(t, moved.Anomalies)
+	assert.Equal(t, "lineage/moved", moved.Link)
+
+	copied := timelines["copied"]
+	assert.Equal(t, types.LineageEventCopied, copied.Events[1].Type)
+	assert.Equal(t, []types.LineageAnomaly{{
+		Flag:         types.LineageAnomalyCopied,
+		LineageValue: "copied",
+		Path:         "net-copy.tfstate",
+		OtherPath:    "net.tfstate",
+		VersionID:    "v5",
+		Serial:       1,
+		LastModified: day(5),
+		Link:         "lineage/copied",
+	}}, copied.Anomalies)
+
+	first := timelines["first"]
+	assert.Equal(t, "second", first.Paths[0].ReplacedBy)
+	assert.Empty(t, first.Anomalies)
+
+	second := timelines["second"]
+	assert.Equal(t, []types.LineageEvent{
+		{Type: types.LineageEventReplaced, Path: "db.tfstate", PreviousLineage: "first", VersionID: "v8", Serial: 1, LastModified: day(8)},
+	}, second.Events)
+	assert.Equal(t, 3, second.Paths[0].Versions)
+	assert.Equal(t, int64(2), second.Paths[0].Serial)
+	assert.Len(t, second.Anomalies, 1)
+	assert.Equal(t, types.LineageAnomalySerialRegression, second.Anomalies[0].Flag)
+	assert.Equal(t, int64(4), second.Anomalies[0].PreviousSerial)
+
+	var flags []string
+	for _, a := range LineageAnomalies(timelines) {
+		flags = append(flags, a.LineageValue+":"+a.Flag)
+	}
+	assert.Equal(t, []string{"second:serial_regression", "copied:copied_state"}, flags)
+}
+
+func TestTimelines_copiedKeys(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	versions := []types.StateStat{
+		{Path: "a.tfstate", LineageValue: "l1", Serial: 3, VersionID: "v1", LastModified: now},
+		{Path: "b.tfstate", LineageValue: "l1", Serial: 3, VersionID: "v2", LastModified: now.Add(time.Hour)},
+	}
+
+	// Both keys still listed: copied
+	timelines := Timelines(versions, []types.StatePath{{Path: "a.tfstate"}, {Path: "b.tfstate"}})
+	assert.Equal(t, types.LineageEventCopied, timelines["l1"].Events[1].Type)
+	assert.Len(t, timelines["l1"].Anomalies, 1)
+
+	// Presence unknown: moved
+	timelines = Timelines(versions, nil)
+	assert.Equal(t, types.LineageEventMoved, timelines["l1"].Events[1].Type)
+	assert.Empty(t, timelines["l1"].Anomalies)
+}
+
+func TestTimelines_serialOrder(t *testing.T) {
+	// Versions synced from a provider without modification times:
+	// the older version at y.tfstate was synced after x.tfstate
+	synced := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	versions := []types.StateStat{
+		{Path: "x.tfstate", LineageValue: "l1", Serial: 2, VersionID: "v2", LastModified: synced},
+		{Path: "y.tfstate", LineageValue: "l1", Serial: 1, VersionID: "v1", LastModified: synced.Add(time.Second)},
+	}
+
+	timelines := Timelines(versions, nil)
+	assert.Equal(t, []types.LineageEvent{
+		{Type: types.LineageEventCreated, Path: "y.tfstate", VersionID: "v1", Serial: 1, LastModified: synced.Add(time.Second)},
+		{Type: types.LineageEventMoved, Path: "x.tfstate", PreviousPath: "y.tfstate", VersionID: "v2", Serial: 2, LastModified: synced},
+	}, timelines["l1"].Events)
+	assert.Empty(t, timelines["l1"].Anomalies)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/camptocamp/terraboard/analysis"
+	"github.com/camptocamp/terraboard/db"
+	"github.com/camptocamp/terraboard/sync"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// GetDuplicates lists the cloud objects managed by more than one State or address
+// @Summary List duplicate-managed cloud objects
+// @Description Indexes the identity attributes (id, arn and self_link) of the managed resources of the latest version of every lineage per resource type, and returns the cloud objects managed by more than one State or address, with links to their owning lineages
+// @ID get-duplicates
+// @Produce  json
+// @Success 200 {array} types.Duplicate
+// @Router /analysis/duplicates [get]
+func GetDuplicates(w http.ResponseWriter, _ *http.Request, d *db.Database) {
+	ids, err := d.ListResourceIdentities(analysis.IdentityKeys)
+	if err != nil {
+		JSONError(w, "Failed to retrieve resource identities", err)
+		return
+	}
+
+	j, err := json.Marshal(analysis.Duplicates(ids))
+	if err != nil {
+		JSONError(w, "Failed to marshal duplicates", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// GetStaleStates reports the lineages to review for cleanup
+// @Summary Report stale and abandoned states
+// @Description Reports the lineages whose latest version is older than a threshold, which manage no resources, which use a Terraform version below a floor, or whose path is no longer listed by the providers, with the age and serial of their latest version. The paths are only checked once all the providers have been synced.
+// @ID get-stale-states
+// @Produce  json
+// @Param   days      query   integer     false  "Age (in days) of the stale lineages (configured by default)"
+// @Param   min_tf_version      query   string     false  "Terraform version below which the lineages are outdated (configured by default)"
+// @Success 200 {object} types.StaleReport
+// @Router /analysis/stale [get]
+func GetStaleStates(w http.ResponseWriter, r *http.Request, d *db.Database, e *sync.Engine) {
+	query := r.URL.Query()
+	opts := analysis.DefaultStaleOptions()
+	if v := query.Get("days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			JSONError(w, "Invalid days", err)
+			return
+		}
+		opts.Days = days
+	}
+	if v := query.Get("min_tf_version"); v != "" {
+		opts.MinTFVersion = v
+	}
+
+	stats, err := d.ListLineageStats()
+	if err != nil {
+		JSONError(w, "Failed to retrieve lineages", err)
+		return
+	}
+
+	var paths map[string]bool
+	if e != nil {
+		if p, complete := e.Paths(); complete {
+			paths = p
+		}
+	}
+
+	report, err := analysis.Stale(stats, paths, opts, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		JSONError(w, "Invalid minimum Terraform version", err)
+		return
+	}
+
+	j, err := json.Marshal(report)
+	if err != nil {
+		JSONError(w, "Failed to marshal stale states", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// GetLineageTimeline provides the history of the paths of a lineage
+// @Summary Get the path timeline of a lineage
+// @Description Retrieves the paths the versions of a lineage were written at, with the events moving or copying it to another path, or replacing the lineage previously written at a path, and its anomalies: a second live path sharing the lineage, or a serial regression
+// @ID get-lineage-timeline
+// @Produce  json
+// @Param   lineage      path   string     true  "Lineage"
+// @Success 200 {object} types.LineageTimeline
+// @Router /lineages/{lineage}/timeline [get]
+func GetLineageTimeline(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	lineage := mux.Vars(r)["lineage"]
+	versions, err := d.ListPathHistory(lineage)
+	if err != nil {
+		JSONError(w, "Failed to retrieve the path history", err)
+		return
+	}
+	paths, err := d.ListAllStatePaths()
+	if err != nil {
+		JSONError(w, "Failed to retrieve state paths", err)
+		return
+	}
+
+	timeline, ok := analysis.Timelines(versions, paths)[lineage]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		JSONError(w, "Failed to retrieve lineage timeline", fmt.Errorf("unknown lineage %q", lineage))
+		return
+	}
+
+	j, err := json.Marshal(timeline)
+	if err != nil {
+		JSONError(w, "Failed to marshal lineage timeline", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// GetLineageAnomalies lists the copied states and serial regressions
+// @Summary List lineage anomalies
+// @Description Lists the versions of the lineages written at a second live path (a copied state) or whose serial is lower than the one of the previous version, which usually come from a bad terraform state push, the newest first
+// @ID get-lineage-anomalies
+// @Produce  json
+// @Success 200 {array} types.LineageAnomaly
+// @Router /analysis/lineages [get]
+func GetLineageAnomalies(w http.ResponseWriter, _ *http.Request, d *db.Database) {
+	versions, err := d.ListPathHistory("")
+	if err != nil {
+		JSONError(w, "Failed to retrieve the path history", err)
+		return
+	}
+	paths, err := d.ListAllStatePaths()
+	if err != nil {
+		JSONError(w, "Failed to retrieve state paths", err)
+		return
+	}
+
+	j, err := json.Marshal(analysis.LineageAnomalies(analysis.Timelines(versions, paths)))
+	if err != nil {
+		JSONError(w, "Failed to marshal lineage anomalies", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/camptocamp/terraboard/db"
+)
+
+func TestGetDuplicates(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(`^SELECT states.path, (.+) GROUP BY states.lineage_id(.+) WHERE resources.mode = (.+) AND attributes.key IN \((.+)\) AND NOT attributes.sensitive`).
+		WithArgs("managed", "id", "arn", "self_link").
+		WillReturnRows(sqlmock.NewRows([]string{"path", "version_id", "lineage_value", "module_path", "type", "name", "index", "key", "value"}).
+			AddRow("network.tfstate", "v1", "l1", "", "aws_vpc", "main", "", "id", `"vpc-1"`).
+			AddRow("legacy.tfstate", "v2", "l2", "", "aws_vpc", "main", "", "id", `"vpc-1"`).
+			AddRow("legacy.tfstate", "v2", "l2", "", "aws_subnet", "a", "", "id", `"subnet-1"`))
+
+	d := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/analysis/duplicates", nil)
+	GetDuplicates(buf, req, d)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, http.StatusOK, buf.Code)
+	assert.Equal(t, `[{"resource_type":"aws_vpc","identities":{"id":"vpc-1"},"owners":[`+
+		`{"path":"network.tfstate","version_id":"v1","lineage_value":"l1","address":"aws_vpc.main","link":"lineage/l1"},`+
+		`{"path":"legacy.tfstate","version_id":"v2","lineage_value":"l2","address":"aws_vpc.main","link":"lineage/l2"}],"lineages":2}]`,
+		buf.Body.String())
+}
+
+func TestGetStaleStates(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(`^SELECT t.path, (.+) LEFT JOIN resources ON resources.module_id = modules.id AND resources.mode = (.+) GROUP BY (.+)`).
+		WithArgs("managed").
+		WillReturnRows(sqlmock.NewRows([]string{"path", "lineage_value", "serial", "tf_version", "version_id", "last_modified", "resource_count"}).
+			AddRow("old.tfstate", "l1", 12, "1.5.0", "v1", time.Now().AddDate(0, 0, -100), 3).
+			AddRow("new.tfstate", "l2", 3, "1.5.0", "v2", time.Now(), 3))
+
+	d := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/analysis/stale?days=30", nil)
+	GetStaleStates(buf, req, d, nil)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, http.StatusOK, buf.Code)
+	assert.Contains(t, buf.Body.String(), `"days":30,"paths_checked":false,"lineages":[{"path":"old.tfstate"`)
+	assert.Contains(t, buf.Body.String(), `"age_days":100,"resource_count":3,"flags":["stale"],"link":"lineage/l1"}]`)
+}
+
+func TestGetStaleStatesInvalidDays(t *testing.T) {
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/analysis/stale?days=many", nil)
+	GetStaleStates(buf, req, &db.Database{}, nil)
+
+	assert.Equal(t, http.StatusBadRequest, buf.Code)
+	assert.Equal(t, `{"details":"strconv.Atoi: parsing \"many\": invalid syntax","error":"Invalid days"}`, buf.Body.String())
+}
+
+func TestGetLineageTimeline(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"path", "lineage_value", "serial", "tf_version", "version_id", "last_modified"}
+	mock.ExpectQuery(`^SELECT states.path, (.+) WHERE states.path IN \(SELECT (.+) WHERE lineages.value = \$1\) ORDER BY versions.last_modified, states.serial, states.id`).
+		WithArgs("l1").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("a.tfstate", "l1", 5, "1.5.0", "v1", now).
+			AddRow("a.tfstate", "l1", 4, "1.5.0", "v2", now.Add(time.Hour)))
+	mock.ExpectQuery(`^SELECT \* FROM "state_paths" ORDER BY path`).
+		WillReturnRows(sqlmock.NewRows([]string{"path", "provider"}).AddRow("a.tfstate", "s3"))
+	mock.ExpectQuery(`^SELECT states.path, (.+) WHERE states.path IN`).
+		WithArgs("unknown").
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery(`^SELECT \* FROM "state_paths"`).
+		WillReturnRows(sqlmock.NewRows([]string{"path", "provider"}))
+
+	d := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/lineages/l1/timeline", nil)
+	req = mux.SetURLVars(req, map[string]string{"lineage": "l1"})
+	GetLineageTimeline(buf, req, d)
+
+	assert.Equal(t, http.StatusOK, buf.Code)
+	assert.Contains(t, buf.Body.String(), `"events":[{"type":"created","path":"a.tfstate","version_id":"v2","serial":4,`)
+	assert.Contains(t, buf.Body.String(), `"anomalies":[{"flag":"serial_regression","lineage_value":"l1","path":"a.tfstate","version_id":"v2","serial":4,"previous_serial":5,`)
+
+	buf = httptest.NewRecorder()
+	req = mux.SetURLVars(req, map[string]string{"lineage": "unknown"})
+	GetLineageTimeline(buf, req, d)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, http.StatusNotFound, buf.Code)
+	assert.Equal(t, `{"details":"unknown lineage \"unknown\"","error":"Failed to retrieve lineage timeline"}`, buf.Body.String())
+}
@@ -6,11 +6,17 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/camptocamp/terraboard/auth"
 	"github.com/camptocamp/terraboard/compare"
 	"github.com/camptocamp/terraboard/db"
+	"github.com/camptocamp/terraboard/graph"
+	"github.com/camptocamp/terraboard/search"
 	"github.com/camptocamp/terraboard/state"
+	"github.com/camptocamp/terraboard/types"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/datatypes"
@@ -49,6 +55,7 @@ func JSONError(w http.ResponseWriter, message string, err error) {
 // @ID list-terraform-versions-with-count
 // @Produce  json
 // @Param   orderBy      query   string     false  "Order by constraint"
+// @Param   include_archived      query   boolean     false  "Include the archived lineages"
 // @Success 200 {string} string	"ok"
 // @Router /lineages/tfversion/count [get]
 func ListTerraformVersionsWithCount(w http.ResponseWriter, r *http.Request, d *db.Database) {
@@ -71,6 +78,7 @@ func ListTerraformVersionsWithCount(w http.ResponseWriter, r *http.Request, d *d
 // @ID list-state-stats
 // @Produce  json
 // @Param   page      query   integer     false  "Current page for pagination"
+// @Param   include_archived      query   boolean     false  "Include the archived lineages"
 // @Success 200 {string} string	"ok"
 // @Router /lineages/stats [get]
 func ListStateStats(w http.ResponseWriter, r *http.Request, d *db.Database) {
@@ -124,6 +132,155 @@ func GetState(w http.ResponseWriter, r *http.Request, d *db.Database) {
 	}
 }
 
+// GetLineageGraph provides the resource dependency graph of a State
+// @Summary Provides the resource dependency graph of a State
+// @Description Retrieves the dependency graph of the resources of a State, as JSON nodes and edges or as Graphviz DOT. The graph can be restricted to a module and its children, or to the blast radius of a resource: all the resources transitively depending on it, up to a depth.
+// @ID get-lineage-graph
+// @Produce  json
+// @Produce  text/vnd.graphviz
+// @Param   lineage      path   string     true  "Lineage"
+// @Param   versionid      query   string     false  "Version ID"
+// @Param   module      query   string     false  "Module path (e.g. module.vpc)"
+// @Param   resource      query   string     false  "Address of the resource whose blast radius is returned"
+// @Param   depth      query   integer     false  "Maximum depth of the blast radius (unlimited by default)"
+// @Param   format      query   string     false  "Output format (json or dot)"
+// @Success 200 {object} types.Graph
+// @Router /lineages/{lineage}/graph [get]
+func GetLineageGraph(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	params := mux.Vars(r)
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		w.WriteHeader(http.StatusBadRequest)
+		JSONError(w, "Invalid format", fmt.Errorf("unknown format %s, expected json or dot", format))
+		return
+	}
+	depth := 0
+	if v := query.Get("depth"); v != "" {
+		var err error
+		if depth, err = strconv.Atoi(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			JSONError(w, "Invalid depth", err)
+			return
+		}
+	}
+
+	versionID := query.Get("versionid")
+	var err error
+	if versionID == "" {
+		versionID, err = d.DefaultVersion(params["lineage"])
+		if err != nil {
+			JSONError(w, "Failed to retrieve default version", err)
+			return
+		}
+	}
+
+	g := graph.Build(d.GetStateResources(params["lineage"], versionID))
+	if module := query.Get("module"); module != "" {
+		g = graph.FilterModule(g, module)
+	}
+	if resource := query.Get("resource"); resource != "" {
+		g, err = graph.BlastRadius(g, resource, depth)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			JSONError(w, "Failed to compute blast radius", err)
+			return
+		}
+	}
+
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		if _, err := io.WriteString(w, graph.DOT(g, params["lineage"])); err != nil {
+			log.Error(err.Error())
+		}
+		return
+	}
+
+	j, err := json.Marshal(g)
+	if err != nil {
+		JSONError(w, "Failed to marshal graph", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// stackGraph returns the graph of the dependencies between States
+func stackGraph(d *db.Database) (g types.StackGraph, err error) {
+	nodes, err := d.ListStackNodes()
+	if err != nil {
+		return
+	}
+	refs, err := d.ListStackReferences()
+	if err != nil {
+		return
+	}
+	return graph.Stacks(nodes, refs), nil
+}
+
+// GetStacks provides the graph of the dependencies between States
+// @Summary Provides the graph of the dependencies between States
+// @Description Resolves the terraform_remote_state data sources of the latest version of all States against the known State paths, and returns the States as nodes and the data sources reading other States as edges. The data sources whose upstream State is unknown are listed as unresolved.
+// @ID get-stacks
+// @Produce  json
+// @Success 200 {object} types.StackGraph
+// @Router /stacks [get]
+func GetStacks(w http.ResponseWriter, _ *http.Request, d *db.Database) {
+	g, err := stackGraph(d)
+	if err != nil {
+		JSONError(w, "Failed to retrieve stack dependencies", err)
+		return
+	}
+
+	j, err := json.Marshal(g)
+	if err != nil {
+		JSONError(w, "Failed to marshal stack dependencies", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// GetLineageConsumers provides the States reading the outputs of a lineage
+// @Summary Provides the States reading the outputs of a lineage
+// @Description Lists the States reading the outputs of the States of a lineage through terraform_remote_state data sources, directly or transitively, to know what is impacted before changing or destroying it.
+// @ID get-lineage-consumers
+// @Produce  json
+// @Param   lineage      path   string     true  "Lineage"
+// @Param   depth      query   integer     false  "Maximum depth of the transitive consumers (unlimited by default)"
+// @Success 200 {array} types.StackConsumer
+// @Router /lineages/{lineage}/consumers [get]
+func GetLineageConsumers(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	params := mux.Vars(r)
+	depth := 0
+	if v := r.URL.Query().Get("depth"); v != "" {
+		var err error
+		if depth, err = strconv.Atoi(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			JSONError(w, "Invalid depth", err)
+			return
+		}
+	}
+
+	g, err := stackGraph(d)
+	if err != nil {
+		JSONError(w, "Failed to retrieve stack dependencies", err)
+		return
+	}
+
+	j, err := json.Marshal(graph.Consumers(g, params["lineage"], depth))
+	if err != nil {
+		JSONError(w, "Failed to marshal consumers", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
 // GetLineageActivity returns the activity (version history) of a Lineage
 // @Summary Get Lineage activity
 // @Description Retrieves the activity (version history) of a Lineage
@@ -180,6 +337,96 @@ func StateCompare(w http.ResponseWriter, r *http.Request, d *db.Database) {
 	}
 }
 
+// CompareLineages compares a version of a lineage with a version of another lineage
+// @Summary Compares two lineages
+// @Description Compares a version ('from', latest by default) of a lineage with a version ('to', latest by default) of another lineage, once address mappings and ignored attributes are applied to both
+// @ID compare-lineages
+// @Produce  json
+// @Param   from_lineage      query   string     true  "Lineage from"
+// @Param   from      query   string     false  "Version from"
+// @Param   to_lineage      query   string     true  "Lineage to"
+// @Param   to      query   string     false  "Version to"
+// @Param   map      query   []string     false  "Address mapping, as regexp=replacement (e.g. ^module\.staging\.=module.env.)" collectionFormat(multi)
+// @Param   ignore      query   []string     false  "Ignored attribute path (e.g. id, tags.Environment)" collectionFormat(multi)
+// @Success 200 {string} string	"ok"
+// @Router /lineages/compare [get]
+func CompareLineages(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	query := r.URL.Query()
+
+	var opts compare.Options
+	for _, m := range query["map"] {
+		mapping, err := compare.ParseAddressMapping(m)
+		if err != nil {
+			JSONError(w, "Failed to parse address mapping", err)
+			return
+		}
+		opts.Mappings = append(opts.Mappings, mapping)
+	}
+	for _, i := range query["ignore"] {
+		opts.Ignore = append(opts.Ignore, strings.Split(i, ",")...)
+	}
+
+	var states [2]types.State
+	for i, side := range []string{"from", "to"} {
+		lineage := query.Get(side + "_lineage")
+		versionID := query.Get(side)
+		if versionID == "" {
+			var err error
+			versionID, err = d.DefaultVersion(lineage)
+			if err != nil {
+				JSONError(w, "Failed to retrieve default version", err)
+				return
+			}
+		}
+		states[i] = d.GetState(lineage, versionID)
+	}
+
+	compare, err := compare.CompareWith(states[0], states[1], opts)
+	if err != nil {
+		JSONError(w, "Failed to compare lineages", err)
+		return
+	}
+
+	j, err := json.Marshal(compare)
+	if err != nil {
+		JSONError(w, "Failed to marshal lineages compare", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// GetResourceHistory returns the changes of a resource instance
+// across the versions of a lineage
+// @Summary Get the history of a resource
+// @Description Returns the versions of a lineage in which a resource instance was created, changed or removed, with per-attribute diffs
+// @ID get-resource-history
+// @Produce  json
+// @Param   lineage      path   string     true  "Lineage"
+// @Param   address      path   string     true  "Resource instance address (e.g. module.vpc.aws_subnet.private[2])"
+// @Success 200 {string} string	"ok"
+// @Router /lineages/{lineage}/resources/{address}/history [get]
+func GetResourceHistory(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	params := mux.Vars(r)
+
+	states, err := d.GetResourceHistory(params["lineage"], params["address"])
+	if err != nil {
+		JSONError(w, "Failed to get resource history", err)
+		return
+	}
+	history := compare.ResourceHistory(params["address"], states)
+
+	j, err := json.Marshal(history)
+	if err != nil {
+		JSONError(w, "Failed to marshal resource history", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
 // GetLocks returns information on locked States
 // @Summary Get locked states information
 // @Description Returns information on locked States
@@ -223,10 +470,29 @@ func GetLocks(w http.ResponseWriter, _ *http.Request, sps []state.Provider) {
 // @Param   value      query   string     false  "Attribute Value"
 // @Param   tf_version      query   string     false  "Terraform Version"
 // @Param   lineage_value      query   string     false  "Lineage"
+// @Param   mode      query   string     false  "Resource mode (managed or data)"
+// @Param   provider      query   string     false  "Provider configuration"
+// @Param   status      query   string     false  "Resource status (ready, tainted or planned)"
+// @Param   deposed      query   boolean     false  "Only resources with deposed objects"
+// @Param   path      query   string     false  "JSON path of the nested values, e.g. tags.Team or ingress[*].cidr_blocks"
+// @Param   op      query   string     false  "Comparison of the nested values (eq, ne, contains, prefix, regex, lt, le, gt or ge), regexes being POSIX ones"
+// @Param   include_archived      query   boolean     false  "Include the archived lineages"
 // @Success 200 {string} string	"ok"
 // @Router /search/attribute [get]
 func SearchAttribute(w http.ResponseWriter, r *http.Request, d *db.Database) {
 	query := r.URL.Query()
+	if err := db.ValidateSearchAttribute(query); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		JSONError(w, "Invalid attribute search", err)
+		return
+	}
+	if query.Get("op") == db.PathOpRegex {
+		if err := d.ValidateRegex(query.Get("value")); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			JSONError(w, "Invalid attribute search", err)
+			return
+		}
+	}
 	result, page, total := d.SearchAttribute(query)
 
 	// Build response object
@@ -245,6 +511,50 @@ func SearchAttribute(w http.ResponseWriter, r *http.Request, d *db.Database) {
 	}
 }
 
+// Search performs a search on Resource Attributes with a structured query
+// @Summary Search with a structured query
+// @Description Performs a search on Resource Attributes with a structured query, e.g. type:aws_security_group AND attr:ingress.*.cidr_blocks="0.0.0.0/0" AND NOT module:module.legacy*, /.../ values being POSIX regexes
+// @ID search
+// @Produce  json
+// @Param   q      query   string     true  "Query"
+// @Param   versionid      query   string     false  "Version ID, or * for all versions"
+// @Param   page      query   integer     false  "Page"
+// @Param   include_archived      query   boolean     false  "Include the archived lineages"
+// @Success 200 {string} string	"ok"
+// @Router /search [get]
+func Search(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	query := r.URL.Query()
+	q, err := search.Parse(query.Get("q"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		JSONError(w, "Invalid search query", err)
+		return
+	}
+	for _, re := range q.Regexes {
+		if err := d.ValidateRegex(re); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			JSONError(w, "Invalid search query", err)
+			return
+		}
+	}
+	result, page, total := d.Search(q, query.Get("versionid"), query.Get("page"), query.Get("include_archived") == "true")
+
+	// Build response object
+	response := make(map[string]interface{})
+	response["results"] = result
+	response["page"] = page
+	response["total"] = total
+
+	j, err := json.Marshal(response)
+	if err != nil {
+		JSONError(w, "Failed to marshal json", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
 // ListResourceTypes lists all Resource types
 // @Summary Get Resource types
 // @Description Lists all Resource types
@@ -283,6 +593,75 @@ func ListResourceTypesWithCount(w http.ResponseWriter, _ *http.Request, d *db.Da
 	}
 }
 
+// GetTimeSeries provides the daily series of an inventory metric
+// @Summary Get inventory time series
+// @Description Retrieves the daily snapshots, taken after the syncs, of the managed resources (metric resources) grouped by type, lineage, module or provider, or of the States (metric states) grouped by Terraform version, over the latest version of the live States, one series per key. Without group_by, a single series of totals is returned.
+// @ID get-time-series
+// @Produce  json
+// @Param   metric      query   string     true  "Metric (resources or states)"
+// @Param   group_by      query   string     false  "Dimension of the metric (type, lineage, module or provider for resources, terraform_version for states)"
+// @Param   from      query   string     false  "First day (YYYY-MM-DD), 90 days before the last one by default"
+// @Param   to      query   string     false  "Last day (YYYY-MM-DD), today by default"
+// @Success 200 {object} types.TimeSeriesResult
+// @Router /stats/timeseries [get]
+func GetTimeSeries(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	groupBy := query.Get("group_by")
+	if err := db.ValidateTimeSeries(metric, groupBy); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		JSONError(w, "Invalid time series", err)
+		return
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := query.Get("to"); v != "" {
+		t, err := time.Parse(types.DayFormat, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			JSONError(w, "Invalid to", err)
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -90)
+	if v := query.Get("from"); v != "" {
+		t, err := time.Parse(types.DayFormat, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			JSONError(w, "Invalid from", err)
+			return
+		}
+		from = t
+	}
+	if from.After(to) {
+		w.WriteHeader(http.StatusBadRequest)
+		JSONError(w, "Invalid from", fmt.Errorf("%s is after %s", from.Format(types.DayFormat), to.Format(types.DayFormat)))
+		return
+	}
+
+	series, err := d.ListTimeSeries(metric, groupBy, from, to)
+	if err != nil {
+		JSONError(w, "Failed to retrieve time series", err)
+		return
+	}
+
+	j, err := json.Marshal(types.TimeSeriesResult{
+		Metric:  metric,
+		GroupBy: groupBy,
+		From:    from.Format(types.DayFormat),
+		To:      to.Format(types.DayFormat),
+		Series:  series,
+	})
+	if err != nil {
+		JSONError(w, "Failed to marshal time series", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
 // ListResourceNames lists all Resource names
 // @Summary Get resource names
 // @Description Lists all resource names
@@ -305,15 +684,29 @@ func ListResourceNames(w http.ResponseWriter, _ *http.Request, d *db.Database) {
 // ListAttributeKeys lists all Resource Attribute Keys,
 // optionally filtered by resource_type
 // @Summary Get resource attribute keys
-// @Description Lists all resource attribute keys, optionally filtered by resource_type
+// @Description Lists all resource attribute keys, optionally filtered by resource_type. With nested=true or a JSON path, lists the keys of the nested values (e.g. tags.Team or ingress[*].cidr_blocks[*]) at or under the path.
 // @ID list-attribute-keys
 // @Produce  json
 // @Param   resource_type      query   string     false  "Resource Type"
+// @Param   path      query   string     false  "JSON path, e.g. tags or ingress[*]"
+// @Param   nested      query   boolean     false  "List the keys of the nested values"
 // @Success 200 {string} string	"ok"
 // @Router /attribute/keys [get]
 func ListAttributeKeys(w http.ResponseWriter, r *http.Request, d *db.Database) {
-	resourceType := r.URL.Query().Get("resource_type")
-	result, _ := d.ListAttributeKeys(resourceType)
+	query := r.URL.Query()
+	resourceType := query.Get("resource_type")
+	var result []string
+	if path := query.Get("path"); path != "" || query.Get("nested") == "true" {
+		var err error
+		result, err = d.ListAttributePaths(resourceType, path)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			JSONError(w, "Failed to list attribute keys", err)
+			return
+		}
+	} else {
+		result, _ = d.ListAttributeKeys(resourceType)
+	}
 	j, err := json.Marshal(result)
 	if err != nil {
 		JSONError(w, "Failed to marshal json", err)
@@ -428,7 +821,7 @@ func GetPlansSummary(w http.ResponseWriter, r *http.Request, db *db.Database) {
 // GetPlan provides a specific Plan of a lineage using ID.
 // /api/plans GET endpoint callback on request with ?plan_id=X parameter
 // @Summary Get plans
-// @Description Provides a specific Plan of a lineage using ID or all plans if no ID is provided
+// @Description Provides a specific Plan of a lineage using ID or all plans if no ID is provided. A specific Plan is verified against the first following state version of its lineage: unapplied, partially applied or diverged from what was planned.
 // @ID get-plans
 // @Produce  json
 // @Param   planid      query   string     false  "Plan's ID"
@@ -440,6 +833,22 @@ func GetPlan(w http.ResponseWriter, r *http.Request, db *db.Database) {
 	id := r.URL.Query().Get("planid")
 	plan := db.GetPlan(id)
 
+	if plan.ID != 0 {
+		state, err := db.GetPlanAppliedState(plan)
+		if err != nil {
+			log.Errorf("Failed to retrieve the state following plan %s: %v", id, err)
+			JSONError(w, "Failed to retrieve the state following the plan", err)
+			return
+		}
+		verif, err := compare.VerifyPlan(plan, state)
+		if err != nil {
+			log.Errorf("Failed to verify plan %s: %v", id, err)
+			JSONError(w, "Failed to verify plan", err)
+			return
+		}
+		plan.Verification = &verif
+	}
+
 	j, err := json.Marshal(plan)
 	if err != nil {
 		log.Errorf("Failed to marshal plan: %v", err)
@@ -502,11 +911,12 @@ func ManagePlans(w http.ResponseWriter, r *http.Request, db *db.Database) {
 // @ID get-lineages
 // @Produce  json
 // @Param   limit      query   integer     false  "Limit"
+// @Param   include_archived      query   boolean     false  "Include the archived lineages"
 // @Success 200 {string} string	"ok"
 // @Router /lineages [get]
 func GetLineages(w http.ResponseWriter, r *http.Request, db *db.Database) {
 	limit := r.URL.Query().Get("limit")
-	lineages := db.GetLineages(limit)
+	lineages := db.GetLineages(limit, r.URL.Query().Get("include_archived") == "true")
 
 	j, err := json.Marshal(lineages)
 	if err != nil {
@@ -6,9 +6,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -128,6 +130,64 @@ func TestGetState(t *testing.T) {
 	}
 }
 
+func TestGetLineageGraph(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(`^SELECT (.+) FROM "states" (.+)`).
+		WithArgs("123456789", "foo").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "path"}).AddRow(1, `path`))
+	mock.ExpectQuery(`^SELECT (.+) FROM "modules" (.+)`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "state_id", "path"}).AddRow(10, 1, ""))
+	mock.ExpectQuery(`^SELECT (.+) FROM "resources" (.+)`).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "module_id", "mode", "type", "name", "dependencies"}).
+			AddRow(100, 10, "managed", "aws_vpc", "main", `[]`).
+			AddRow(101, 10, "managed", "aws_subnet", "private", `["aws_vpc.main"]`))
+
+	db := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/lineages/123456789/graph?versionid=foo&format=dot&resource=aws_vpc.main", nil)
+	// Hack to fake gorilla/mux vars
+	vars := map[string]string{
+		"lineage": "123456789",
+	}
+	req = mux.SetURLVars(req, vars)
+	GetLineageGraph(buf, req, db)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, "text/vnd.graphviz", buf.Header().Get("Content-Type"))
+	assert.Equal(t, `digraph "123456789" {
+  rankdir = "RL";
+  node [shape = "box"];
+  "aws_subnet.private";
+  "aws_vpc.main";
+  "aws_subnet.private" -> "aws_vpc.main";
+}
+`, buf.Body.String())
+}
+
+func TestGetLineageGraphInvalidFormat(t *testing.T) {
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/lineages/123456789/graph?format=svg", nil)
+	req = mux.SetURLVars(req, map[string]string{"lineage": "123456789"})
+	GetLineageGraph(buf, req, &db.Database{})
+
+	assert.Equal(t, http.StatusBadRequest, buf.Code)
+}
+
 func TestGetLineageActivity(t *testing.T) {
 	fakeDB, mock, err := sqlmock.New()
 	if err != nil {
@@ -206,6 +266,75 @@ func TestStateCompare(t *testing.T) {
 	}
 }
 
+func TestGetResourceHistory(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery(`^SELECT (.+) FROM "states" (.+) ORDER BY versions.last_modified ASC`).
+		WithArgs("123456789").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "path", "serial", "version_id"}).
+			AddRow(1, "path", 1, 1).
+			AddRow(2, "path", 2, 2))
+	mock.ExpectQuery(`^SELECT (.+) FROM "modules" WHERE (.+) AND path = (.+)`).
+		WithArgs(1, 2, "module.vpc").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "state_id", "path"}).
+			AddRow(10, 1, "module.vpc").
+			AddRow(20, 2, "module.vpc"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "resources" WHERE (.+) AND \(type = (.+)\)`).
+		WithArgs(10, 20, "aws_subnet", "private", "[2]", "managed").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "module_id", "type", "name", "index"}).
+			AddRow(100, 20, "aws_subnet", "private", "[2]"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "attributes" (.+)`).
+		WithArgs(100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "resource_id", "key", "value"}).
+			AddRow(1000, 100, "cidr_block", `"10.0.2.0/24"`))
+	mock.ExpectQuery(`^SELECT (.+) FROM "versions" (.+)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version_id"}).
+			AddRow(1, "v1").
+			AddRow(2, "v2"))
+
+	db := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/lineages/123456789/resources/module.vpc.aws_subnet.private[2]/history", nil)
+	// Hack to fake gorilla/mux vars
+	vars := map[string]string{
+		"lineage": "123456789",
+		"address": "module.vpc.aws_subnet.private[2]",
+	}
+	req = mux.SetURLVars(req, vars)
+	GetResourceHistory(buf, req, db)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	if buf.Body.String() != `{"address":"module.vpc.aws_subnet.private[2]","changes":[{"action":"created","path":"path","version_id":"v2","serial":2,"terraform_version":"","last_modified":"0001-01-01T00:00:00Z","attributes":[{"path":"cidr_block","new":"10.0.2.0/24","sensitive":false}]}]}` {
+		t.Errorf("TestGetResourceHistory returned unexpected body: %s", buf.Body.String())
+	}
+}
+
+func TestGetResourceHistoryInvalidAddress(t *testing.T) {
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/lineages/123456789/resources/aws_subnet/history", nil)
+	vars := map[string]string{
+		"lineage": "123456789",
+		"address": "aws_subnet",
+	}
+	req = mux.SetURLVars(req, vars)
+	GetResourceHistory(buf, req, &db.Database{})
+
+	assert.Contains(t, buf.Body.String(), `"error":"Failed to get resource history"`)
+}
+
 func TestGetLocks(t *testing.T) {
 	// TODO: Test with state provider
 }
@@ -360,6 +489,70 @@ func TestListAttributeKeys(t *testing.T) {
 	}
 }
 
+func TestListAttributeKeysNested(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(`^SELECT DISTINCT attribute_paths.key FROM "attribute_paths" (.+) WHERE resources.type = (.+) AND attribute_paths.path ~ (.+) ORDER BY attribute_paths.key`).
+		WithArgs("aws_instance", `^tags($|[.[])`).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).
+			AddRow("tags.Name").
+			AddRow("tags.Team"))
+
+	db := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, `/attribute/keys?resource_type=aws_instance&path=tags`, nil)
+	ListAttributeKeys(buf, req, db)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, `["tags.Name","tags.Team"]`, buf.Body.String())
+}
+
+func TestSearchAttributeInvalidPath(t *testing.T) {
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, `/search/attribute?path=ingress[*].from_port&op=gt&value=many`, nil)
+	SearchAttribute(buf, req, &db.Database{})
+
+	assert.Equal(t, http.StatusBadRequest, buf.Code)
+	assert.Equal(t, `{"details":"invalid number \"many\" for operator gt","error":"Invalid attribute search"}`, buf.Body.String())
+}
+
+func TestSearchAttributeInvalidRegex(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(`^SELECT '' ~ (.+)`).
+		WithArgs("[a").
+		WillReturnError(&pgconn.PgError{Code: "2201B", Message: "invalid regular expression: brackets [] not balanced"})
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, `/search/attribute?path=tags.Team&op=regex&value=[a`, nil)
+	SearchAttribute(buf, req, &db.Database{DB: gormDB})
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, http.StatusBadRequest, buf.Code)
+	assert.Equal(t, `{"details":"invalid regex \"[a\": invalid regular expression: brackets [] not balanced","error":"Invalid attribute search"}`, buf.Body.String())
+}
+
 func TestListTfVersions(t *testing.T) {
 	fakeDB, mock, err := sqlmock.New()
 	if err != nil {
@@ -452,6 +645,8 @@ func TestGetPlan(t *testing.T) {
 		WithArgs("1").
 		WillReturnRows(sqlmock.NewRows([]string{"id", "tf_version"}).
 			AddRow(1, "1.0.0"))
+	mock.ExpectQuery(`^SELECT (.+) FROM "states" JOIN versions (.+) ORDER BY versions.last_modified ASC LIMIT 1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 
 	db := &db.Database{
 		DB: gormDB,
@@ -461,7 +656,7 @@ func TestGetPlan(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, `/plans?planid=1`, nil)
 	ManagePlans(buf, req, db)
 
-	if buf.Body.String() != `{"ID":1,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"lineage_data":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"lineage":"","states":null,"plans":null},"terraform_version":"1.0.0","git_remote":"","git_commit":"","ci_url":"","source":"","exit_code":0,"parsed_plan":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"planned_values":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"root_module":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null}},"prior_state":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"values":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"root_module":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null}}}},"plan_json":null}` {
+	if buf.Body.String() != `{"ID":1,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"lineage_data":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"lineage":"","states":null,"plans":null},"terraform_version":"1.0.0","git_remote":"","git_commit":"","ci_url":"","source":"","exit_code":0,"parsed_plan":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"planned_values":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"root_module":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null}},"prior_state":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"values":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null,"root_module":{"ID":0,"CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z","DeletedAt":null}}}},"plan_json":null,"verification":{"status":"no_changes","resources":[]}}` {
 		t.Errorf("TestGetPlan returned unexpected body: %s", buf.Body.String())
 	}
 }
@@ -586,3 +781,128 @@ func TestGetLineages(t *testing.T) {
 		t.Errorf("TestGetLineages returned unexpected body: %s", buf.Body.String())
 	}
 }
+
+func TestSearch(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(`^SELECT count(.+) WHERE states.version_id = (.+) AND \(resources.type = (.+) AND NOT modules.path LIKE (.+)\)`).
+		WithArgs("v1", "aws_instance", "module.legacy%").
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(1))
+	mock.ExpectQuery("^SELECT (.+)").
+		WithArgs("v1", "aws_instance", "module.legacy%", 20, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"path", "version_id", "tf_version"}).AddRow("path", "v1", "1.0.0"))
+
+	db := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, `/search?q=type:aws_instance+AND+NOT+module:module.legacy*&versionid=v1&page=2`, nil)
+	Search(buf, req, db)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, `{"page":2,"results":[{"path":"path","version_id":"v1","tf_version":"1.0.0","serial":0,"lineage_value":"","module_path":"","resource_mode":"","resource_type":"","resource_name":"","resource_index":"","provider":"","resource_status":"","deposed_count":0,"attribute_key":"","attribute_value":"","sensitive":false}],"total":1}`, buf.Body.String())
+}
+
+func TestSearchInvalidQuery(t *testing.T) {
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, `/search?q=type:aws_instance+AND`, nil)
+	Search(buf, req, &db.Database{})
+
+	assert.Equal(t, http.StatusBadRequest, buf.Code)
+	assert.Equal(t, `{"details":"at position 21: unexpected end of query","error":"Invalid search query"}`, buf.Body.String())
+}
+
+func TestSearchInvalidRegex(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(`^SELECT '' ~ (.+)`).
+		WithArgs("^web").
+		WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(false))
+	mock.ExpectQuery(`^SELECT '' ~ (.+)`).
+		WithArgs("[a").
+		WillReturnError(&pgconn.PgError{Code: "2201B", Message: "invalid regular expression: brackets [] not balanced"})
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, `/search?q=name:/^web/+OR+lineage:/[a/`, nil)
+	Search(buf, req, &db.Database{DB: gormDB})
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, http.StatusBadRequest, buf.Code)
+	assert.Equal(t, `{"details":"invalid regex \"[a\": invalid regular expression: brackets [] not balanced","error":"Invalid search query"}`, buf.Body.String())
+}
+
+func TestGetTimeSeries(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`^SELECT \* FROM "stat_rollups" WHERE metric = (.+) AND dimension = (.+) AND day BETWEEN (.+) AND (.+) ORDER BY day, key`).
+		WithArgs("resources", "provider", from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "key", "count"}).
+			AddRow(to, "registry.terraform.io/hashicorp/aws", 12))
+
+	d := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/stats/timeseries?metric=resources&group_by=provider&from=2024-05-01&to=2024-06-01", nil)
+	GetTimeSeries(buf, req, d)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, http.StatusOK, buf.Code)
+	assert.Equal(t, `{"metric":"resources","group_by":"provider","from":"2024-05-01","to":"2024-06-01","series":[`+
+		`{"key":"registry.terraform.io/hashicorp/aws","points":[{"day":"2024-06-01","count":12}]}]}`,
+		buf.Body.String())
+}
+
+func TestGetTimeSeriesInvalid(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"metric=plans", `{"details":"unknown metric \"plans\"","error":"Invalid time series"}`},
+		{"metric=states&group_by=type", `{"details":"metric \"states\" cannot be grouped by \"type\", only by terraform_version","error":"Invalid time series"}`},
+		{"metric=states&from=yesterday", `{"details":"parsing time \"yesterday\" as \"2006-01-02\": cannot parse \"yesterday\" as \"2006\"","error":"Invalid from"}`},
+		{"metric=states&from=2024-06-02&to=2024-06-01", `{"details":"2024-06-02 is after 2024-06-01","error":"Invalid from"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			buf := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/stats/timeseries?"+tt.query, nil)
+			GetTimeSeries(buf, req, &db.Database{})
+
+			assert.Equal(t, http.StatusBadRequest, buf.Code)
+			assert.Equal(t, tt.want, buf.Body.String())
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/camptocamp/terraboard/db"
+	"github.com/camptocamp/terraboard/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// ListFindings returns the secrets leaked in the states, most severe first
+// @Summary List findings
+// @Description Returns the secrets leaked in the non-sensitive attributes and outputs of the latest version of the states, most severe first, along with paging information
+// @ID list-findings
+// @Produce  json
+// @Param   lineage      query   string     false  "Lineage"
+// @Param   severity      query   string     false  "Severity (critical, high, medium or low)"
+// @Param   versionid      query   string     false  "Version ID, or * for all versions"
+// @Param   page      query   integer     false  "Page"
+// @Param   limit      query   integer     false  "Limit"
+// @Success 200 {string} string	"ok"
+// @Router /findings [get]
+func ListFindings(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	query := r.URL.Query()
+	severity := query.Get("severity")
+	switch severity {
+	case "", types.SeverityCritical, types.SeverityHigh, types.SeverityMedium, types.SeverityLow:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		JSONError(w, "Invalid severity", fmt.Errorf("unknown severity %q", severity))
+		return
+	}
+
+	findings, page, total := d.ListFindings(query.Get("lineage"), severity, query.Get("versionid"),
+		query.Get("limit"), query.Get("page"))
+
+	response := make(map[string]interface{})
+	response["findings"] = findings
+	response["page"] = page
+	response["total"] = total
+	j, err := json.Marshal(response)
+	if err != nil {
+		JSONError(w, "Failed to marshal findings", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// ScanFindings scans the versions of a lineage again with the current rules
+// @Summary Scan states for leaked secrets
+// @Description Scans all the versions of the states of a lineage, or a single version, with the current rules, replacing their findings
+// @ID scan-findings
+// @Produce  json
+// @Param   lineage      query   string     true  "Lineage"
+// @Param   versionid      query   string     false  "Version ID"
+// @Success 200 {string} string	"ok"
+// @Router /findings/scan [post]
+func ScanFindings(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method.", http.StatusMethodNotAllowed)
+		return
+	}
+	lineage := r.URL.Query().Get("lineage")
+	if lineage == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		JSONError(w, "Failed to scan states", fmt.Errorf("missing lineage"))
+		return
+	}
+
+	findings, scanned, err := d.ScanStates(lineage, r.URL.Query().Get("versionid"))
+	if err != nil {
+		if err == db.ErrScanDisabled {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		JSONError(w, "Failed to scan states", err)
+		return
+	}
+
+	response := make(map[string]interface{})
+	response["scanned"] = scanned
+	response["findings"] = findings
+	j, err := json.Marshal(response)
+	if err != nil {
+		JSONError(w, "Failed to marshal findings", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/camptocamp/terraboard/db"
+)
+
+func TestListFindings(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "findings" JOIN states (.+) WHERE states.serial = \(SELECT max(.+)\) AND lineages.value = (.+) AND findings.severity = (.+)`).
+		WithArgs("lineage", "critical").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`^SELECT findings.\*, states.path, versions.version_id, lineages.value as lineage_value FROM "findings" (.+) LIMIT 20`).
+		WithArgs("lineage", "critical").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "rule_id", "severity", "address", "attribute_path", "match", "path", "version_id", "lineage_value"}).
+			AddRow(1, "private-key", "critical", "tls_private_key.ca", "tags.pem", "----***(31)", "pki.tfstate", "v1", "lineage"))
+
+	d := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/findings?lineage=lineage&severity=critical", nil)
+	ListFindings(buf, req, d)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, http.StatusOK, buf.Code)
+	assert.Contains(t, buf.Body.String(), `"total":1`)
+	assert.Contains(t, buf.Body.String(), `"rule_id":"private-key","severity":"critical"`)
+	assert.Contains(t, buf.Body.String(), `"path":"pki.tfstate","version_id":"v1","lineage_value":"lineage"`)
+}
+
+func TestListFindingsInvalidSeverity(t *testing.T) {
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/findings?severity=urgent", nil)
+	ListFindings(buf, req, &db.Database{})
+
+	assert.Equal(t, http.StatusBadRequest, buf.Code)
+	assert.Equal(t, `{"details":"unknown severity \"urgent\"","error":"Invalid severity"}`, buf.Body.String())
+}
+
+func TestScanFindingsDisabled(t *testing.T) {
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/findings/scan?lineage=lineage", nil)
+	ScanFindings(buf, req, &db.Database{})
+
+	assert.Equal(t, http.StatusServiceUnavailable, buf.Code)
+	assert.Equal(t, `{"details":"secret scanning is disabled","error":"Failed to scan states"}`, buf.Body.String())
+}
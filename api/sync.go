@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/camptocamp/terraboard/db"
+	"github.com/camptocamp/terraboard/sync"
+	log "github.com/sirupsen/logrus"
+)
+
+// errSyncDisabled is returned by the sync endpoints when the sync is disabled
+var errSyncDisabled = errors.New("database sync is disabled")
+
+// syncTriggerPayload is the optional JSON body of a sync trigger
+type syncTriggerPayload struct {
+	Provider string `json:"provider"`
+	Path     string `json:"path"`
+}
+
+// GetSyncStatus returns the sync status of each provider
+// @Summary Get sync status
+// @Description Returns the sync status of each provider: last run, next run, states backing off and staleness
+// @ID get-sync-status
+// @Produce  json
+// @Success 200 {string} string	"ok"
+// @Router /sync/status [get]
+func GetSyncStatus(w http.ResponseWriter, _ *http.Request, e *sync.Engine) {
+	if e == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		JSONError(w, "Failed to get sync status", errSyncDisabled)
+		return
+	}
+
+	j, err := json.Marshal(e.Status())
+	if err != nil {
+		JSONError(w, "Failed to marshal sync status", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// ListSyncRuns returns the history of sync runs, most recent first
+// @Summary List sync runs
+// @Description Returns the history of sync runs with their errors, most recent first, along with paging information
+// @ID list-sync-runs
+// @Produce  json
+// @Param   provider      query   string     false  "Provider"
+// @Param   page      query   integer     false  "Page"
+// @Param   limit      query   integer     false  "Limit"
+// @Success 200 {string} string	"ok"
+// @Router /sync/runs [get]
+func ListSyncRuns(w http.ResponseWriter, r *http.Request, d *db.Database) {
+	query := r.URL.Query()
+	runs, page, total := d.ListSyncRuns(query.Get("provider"), query.Get("limit"), query.Get("page"))
+
+	response := make(map[string]interface{})
+	response["runs"] = runs
+	response["page"] = page
+	response["total"] = total
+	j, err := json.Marshal(response)
+	if err != nil {
+		JSONError(w, "Failed to marshal sync runs", err)
+		return
+	}
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// TriggerSync forces an immediate sync of a provider, of a single state path,
+// or of all providers when none is given
+// @Summary Trigger a sync
+// @Description Forces an immediate sync of a provider, of a single state path, or of all providers when none is given
+// @ID trigger-sync
+// @Accept  json
+// @Produce  json
+// @Param   provider      query   string     false  "Provider"
+// @Param   path      query   string     false  "State path, as listed by the provider during its last sync"
+// @Success 202 {string} string	"accepted"
+// @Router /sync/trigger [post]
+func TriggerSync(w http.ResponseWriter, r *http.Request, e *sync.Engine) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method.", http.StatusMethodNotAllowed)
+		return
+	}
+	if e == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		JSONError(w, "Failed to trigger sync", errSyncDisabled)
+		return
+	}
+
+	payload := syncTriggerPayload{
+		Provider: r.URL.Query().Get("provider"),
+		Path:     r.URL.Query().Get("path"),
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && err != io.EOF {
+			w.WriteHeader(http.StatusBadRequest)
+			JSONError(w, "Failed to decode sync trigger", err)
+			return
+		}
+	}
+
+	if err := e.Trigger(payload.Provider, payload.Path); err != nil {
+		switch err {
+		case sync.ErrUnknownProvider, sync.ErrUnknownState:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		JSONError(w, "Failed to trigger sync", err)
+		return
+	}
+
+	j, err := json.Marshal(payload)
+	if err != nil {
+		JSONError(w, "Failed to marshal sync trigger", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	if _, err := io.WriteString(w, string(j)); err != nil {
+		log.Error(err.Error())
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/db"
+	"github.com/camptocamp/terraboard/state"
+	"github.com/camptocamp/terraboard/sync"
+)
+
+func newTestSyncEngine(t *testing.T) *sync.Engine {
+	sp := state.NewLocal(config.LocalConfig{Paths: []string{t.TempDir()}}, false, false)
+	return sync.NewEngine(nil, []state.Provider{sp}, time.Minute, 1)
+}
+
+func TestGetSyncStatus(t *testing.T) {
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/sync/status", nil)
+	e := newTestSyncEngine(t)
+	GetSyncStatus(buf, req, e)
+
+	assert.Equal(t, http.StatusOK, buf.Code)
+	assert.Contains(t, buf.Body.String(), `"provider":"`+e.Status()[0].Provider+`"`)
+	assert.Contains(t, buf.Body.String(), `"provider":"local-`)
+	assert.Contains(t, buf.Body.String(), `"stale":true`)
+}
+
+func TestGetSyncStatusDisabled(t *testing.T) {
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/sync/status", nil)
+	GetSyncStatus(buf, req, nil)
+
+	assert.Equal(t, http.StatusServiceUnavailable, buf.Code)
+	assert.Equal(t, `{"details":"database sync is disabled","error":"Failed to get sync status"}`, buf.Body.String())
+}
+
+func TestListSyncRuns(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer fakeDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: fakeDB,
+	}))
+	assert.Nil(t, err)
+
+	mock.ExpectQuery(`^SELECT count\(\*\) FROM "sync_runs" WHERE provider = (.+)`).
+		WithArgs("local-0").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`^SELECT \* FROM "sync_runs" WHERE provider = (.+) ORDER BY started_at desc LIMIT 20`).
+		WithArgs("local-0").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "provider", "trigger", "states", "failed"}).
+			AddRow(1, "local-0", "schedule", 2, 1))
+	mock.ExpectQuery(`^SELECT \* FROM "sync_errors" WHERE "sync_errors"."sync_run_id" = (.+)`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sync_run_id", "path", "stage", "message"}).
+			AddRow(1, 1, "a.tfstate", "fetch", "not found"))
+
+	d := &db.Database{
+		DB: gormDB,
+	}
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/sync/runs?provider=local-0", nil)
+	ListSyncRuns(buf, req, d)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Contains(t, buf.Body.String(), `"page":1`)
+	assert.Contains(t, buf.Body.String(), `"total":1`)
+	assert.Contains(t, buf.Body.String(), `"errors":[{"path":"a.tfstate","stage":"fetch","message":"not found"`)
+}
+
+func TestTriggerSync(t *testing.T) {
+	e := newTestSyncEngine(t)
+	provider := e.Status()[0].Provider
+
+	buf := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/sync/trigger", strings.NewReader(`{"provider":"`+provider+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	TriggerSync(buf, req, e)
+	assert.Equal(t, http.StatusAccepted, buf.Code)
+	assert.Equal(t, `{"provider":"`+provider+`","path":""}`, buf.Body.String())
+
+	buf = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/sync/trigger?provider=unknown", nil)
+	TriggerSync(buf, req, e)
+	assert.Equal(t, http.StatusNotFound, buf.Code)
+
+	buf = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/sync/trigger?path=unknown.tfstate", nil)
+	TriggerSync(buf, req, e)
+	assert.Equal(t, http.StatusNotFound, buf.Code)
+
+	buf = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/sync/trigger", nil)
+	TriggerSync(buf, req, e)
+	assert.Equal(t, http.StatusMethodNotAllowed, buf.Code)
+}
@@ -3,18 +3,18 @@ package compare
 import (
 	"fmt"
 	"sort"
-	"strings"
 
+	"github.com/camptocamp/terraboard/sensitive"
 	"github.com/camptocamp/terraboard/types"
 	"github.com/pmezard/go-difflib/difflib"
 	log "github.com/sirupsen/logrus"
 )
 
-// Return all resources of a state
+// Return the addresses of all resource instances of a state
 func stateResources(state types.State) (res []string) {
 	for _, m := range state.Modules {
 		for _, r := range m.Resources {
-			res = append(res, fmt.Sprintf("%s.%s.%s", m.Path, r.Type, r.Name))
+			res = append(res, r.Address(m.Path))
 		}
 	}
 	return
@@ -53,14 +53,10 @@ func sliceInter(s1, s2 []string) (inter []string) {
 
 func getResource(state types.State, key string) (res types.Resource, err error) {
 	for _, m := range state.Modules {
-		if strings.HasPrefix(key, m.Path) {
-			for _, r := range m.Resources {
-				if key == fmt.Sprintf("%s.%s.%s", m.Path, r.Type, r.Name) {
-					return r, nil
-				}
+		for _, r := range m.Resources {
+			if key == r.Address(m.Path) {
+				return r, nil
 			}
-		} else {
-			continue
 		}
 	}
 	return res, fmt.Errorf("Could not find resource with key %s in state %s", key, state.Path)
@@ -89,21 +85,25 @@ func formatResource(res types.Resource) (out string) {
 	out = fmt.Sprintf("resource \"%s\" \"%s\" {\n", res.Type, res.Name)
 	for _, attrKey := range resourceAttributes(res) {
 		attr, _ := getResourceAttribute(res, attrKey) // TODO: err
-		if attr.Sensitive {
-			if attr.Value == "null" {
-				out += fmt.Sprintf("  %s = (null)\n", attr.Key)
-			} else {
-				out += fmt.Sprintf("  %s = (%d)\n", attr.Key, len(attr.Value))
-			}
-		} else {
-			out += fmt.Sprintf("  %s = %s\n", attr.Key, attr.Value)
-		}
+		out += fmt.Sprintf("  %s = %s\n", attr.Key, attributeValue(attr))
 	}
 	out += "}\n"
 
 	return
 }
 
+// Return the value of an attribute as displayed in diffs,
+// only showing the length of sensitive values
+func attributeValue(attr types.Attribute) string {
+	if !attr.Sensitive {
+		return attr.Value
+	}
+	if attr.Value == "null" {
+		return "(null)"
+	}
+	return fmt.Sprintf("(%d)", sensitive.Length(attr.Value))
+}
+
 func stateInfo(state types.State) (info string) {
 	return fmt.Sprintf("%s (%s)", state.Path, state.Version.LastModified)
 }
@@ -119,30 +119,14 @@ func compareResource(st1, st2 types.State, key string) (comp types.ResourceDiff)
 	comp.OnlyInOld = make(map[string]string)
 	for _, attrKey := range sliceDiff(attrs1, attrs2) {
 		attr, _ := getResourceAttribute(res1, attrKey) // TODO: err
-		if attr.Sensitive {
-			if attr.Value == "null" {
-				comp.OnlyInOld[attr.Key] = "(null)"
-			} else {
-				comp.OnlyInOld[attr.Key] = fmt.Sprintf("(%d)", len(attr.Value))
-			}
-		} else {
-			comp.OnlyInOld[attr.Key] = attr.Value
-		}
+		comp.OnlyInOld[attr.Key] = attributeValue(attr)
 	}
 
 	// Only in new
 	comp.OnlyInNew = make(map[string]string)
 	for _, attrKey := range sliceDiff(attrs2, attrs1) {
 		attr, _ := getResourceAttribute(res2, attrKey) // TODO: err
-		if attr.Sensitive {
-			if attr.Value == "null" {
-				comp.OnlyInNew[attr.Key] = "(null)"
-			} else {
-				comp.OnlyInNew[attr.Key] = fmt.Sprintf("(%d)", len(attr.Value))
-			}
-		} else {
-			comp.OnlyInNew[attr.Key] = attr.Value
-		}
+		comp.OnlyInNew[attr.Key] = attributeValue(attr)
 	}
 
 	// Compute unified diff
@@ -156,6 +140,7 @@ func compareResource(st1, st2 types.State, key string) (comp types.ResourceDiff)
 	}
 	result, _ := difflib.GetUnifiedDiffString(diff)
 	comp.UnifiedDiff = result
+	comp.Changes = diffResource(res1, res2)
 
 	return
 }
@@ -208,7 +193,7 @@ func Compare(from, to types.State) (comp types.StateCompare, err error) {
 	comp.Differences.ResourceDiff = make(map[string]types.ResourceDiff)
 
 	for _, r := range comp.Differences.InBoth {
-		if c := compareResource(to, from, r); c.UnifiedDiff != "" {
+		if c := compareResource(from, to, r); c.UnifiedDiff != "" {
 			comp.Differences.ResourceDiff[r] = c
 		}
 	}
@@ -1,6 +1,7 @@
 package compare
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 	"time"
@@ -10,12 +11,12 @@ import (
 
 var fakeAttribute1 = types.Attribute{
 	Key:   "fakeKey",
-	Value: "fakeValue",
+	Value: `"fakeValue"`,
 }
 
 var fakeAttribute2 = types.Attribute{
 	Key:   "fakeKey2",
-	Value: "fakeValue2",
+	Value: `"fakeValue2"`,
 }
 
 var fakeResource1 = types.Resource{
@@ -181,8 +182,8 @@ func TestStateInfo(t *testing.T) {
 
 func TestCompareResource(t *testing.T) {
 	expectedResult := types.ResourceDiff{
-		OnlyInOld: map[string]string{"fakeKey": "fakeValue", "fakeKey2": "fakeValue2"},
-		OnlyInNew: map[string]string{"fakeNewKey": "fakeNewValue", "fakeNewKey2": "fakeNewValue2"},
+		OnlyInOld: map[string]string{"fakeKey": `"fakeValue"`, "fakeKey2": `"fakeValue2"`},
+		OnlyInNew: map[string]string{"fakeNewKey": `"fakeNewValue"`, "fakeNewKey2": `"fakeNewValue2"`},
 		UnifiedDiff: `--- myfakepath/terraform.tfstate (2017-08-03 17:47:23 +0000 UTC)
 +++ myfakepath/terraform.tfstate (2017-08-03 17:47:23 +0000 UTC)
 @@ -1,5 +1,5 @@
@@ -194,16 +195,22 @@ func TestCompareResource(t *testing.T) {
  }
  
 `,
+		Changes: []types.AttributeChange{
+			{Path: "fakeKey", Old: json.RawMessage(`"fakeValue"`)},
+			{Path: "fakeKey2", Old: json.RawMessage(`"fakeValue2"`)},
+			{Path: "fakeNewKey", New: json.RawMessage(`"fakeNewValue"`)},
+			{Path: "fakeNewKey2", New: json.RawMessage(`"fakeNewValue2"`)},
+		},
 	}
 
 	fakeNewAttribute := types.Attribute{
 		Key:   "fakeNewKey",
-		Value: "fakeNewValue",
+		Value: `"fakeNewValue"`,
 	}
 
 	fakeNewAttribute2 := types.Attribute{
 		Key:   "fakeNewKey2",
-		Value: "fakeNewValue2",
+		Value: `"fakeNewValue2"`,
 	}
 
 	fakeNewResource := types.Resource{
@@ -231,7 +238,7 @@ func TestCompareResource(t *testing.T) {
 	result := compareResource(fakeState, fakeNewState, "root.fakeType.fakeName")
 
 	if !reflect.DeepEqual(result, expectedResult) {
-		t.Fatalf("Expected %s, got %s", expectedResult, result)
+		t.Fatalf("Expected %v, got %v", expectedResult, result)
 	}
 }
 
@@ -274,18 +281,23 @@ func TestCompare_Result(t *testing.T) {
 			InBoth: []string{"root.fakeType.fakeName"},
 			ResourceDiff: map[string]types.ResourceDiff{
 				"root.fakeType.fakeName": types.ResourceDiff{
-					OnlyInOld: map[string]string{"fakeKey": "fakeValue", "fakeKey2": "fakeValue2"},
-					OnlyInNew: map[string]string{"fakeNewKey": "fakeNewValue"},
+					OnlyInOld: map[string]string{"fakeNewKey": `"fakeNewValue"`},
+					OnlyInNew: map[string]string{"fakeKey": `"fakeValue"`, "fakeKey2": `"fakeValue2"`},
 					UnifiedDiff: `--- myfakepath/terraform.tfstate (2017-08-03 17:47:23 +0000 UTC)
 +++ myfakepath/terraform.tfstate (2017-08-03 17:47:23 +0000 UTC)
-@@ -1,5 +1,4 @@
+@@ -1,4 +1,5 @@
  resource "fakeType" "fakeName" {
--  fakeKey = "fakeValue"
--  fakeKey2 = "fakeValue2"
-+  fakeNewKey = "fakeNewValue"
+-  fakeNewKey = "fakeNewValue"
++  fakeKey = "fakeValue"
++  fakeKey2 = "fakeValue2"
  }
  
 `,
+					Changes: []types.AttributeChange{
+						{Path: "fakeKey", New: json.RawMessage(`"fakeValue"`)},
+						{Path: "fakeKey2", New: json.RawMessage(`"fakeValue2"`)},
+						{Path: "fakeNewKey", Old: json.RawMessage(`"fakeNewValue"`)},
+					},
 				},
 			},
 		},
@@ -293,12 +305,12 @@ func TestCompare_Result(t *testing.T) {
 
 	fakeNewAttribute1 := types.Attribute{
 		Key:   "fakeNewKey",
-		Value: "fakeNewValue",
+		Value: `"fakeNewValue"`,
 	}
 
 	fakeNewAttribute2 := types.Attribute{
 		Key:   "fakeTotoKey",
-		Value: "fakeTotoValue",
+		Value: `"fakeTotoValue"`,
 	}
 
 	fakeNewResource1 := types.Resource{
@@ -0,0 +1,151 @@
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/camptocamp/terraboard/search"
+	"github.com/camptocamp/terraboard/sensitive"
+	"github.com/camptocamp/terraboard/types"
+)
+
+// absent stands for a missing value in a diff
+var absent = &struct{}{}
+
+// Return the JSON path of a list element
+func indexPath(path string, index int) string {
+	return fmt.Sprintf("%s[%d]", path, index)
+}
+
+// Return the JSON encoding of a value, or nil when it is absent
+func encodeValue(v interface{}) json.RawMessage {
+	if v == absent {
+		return nil
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage(strconv.Quote(fmt.Sprint(v)))
+	}
+	return j
+}
+
+// Return the decoded value of an attribute, or its raw value
+// when it isn't valid JSON
+func decodeAttribute(attr types.Attribute) (v interface{}) {
+	if err := json.Unmarshal([]byte(attr.Value), &v); err != nil {
+		return attr.Value
+	}
+	return
+}
+
+// diffValues returns the changes between two decoded JSON values,
+// diffing objects key by key and lists element by element
+func diffValues(path string, from, to interface{}) (changes []types.AttributeChange) {
+	switch f := from.(type) {
+	case map[string]interface{}:
+		if t, ok := to.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(f)+len(t))
+			for k := range f {
+				keys = append(keys, k)
+			}
+			for k := range t {
+				if _, ok := f[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				fv, ok := f[k]
+				if !ok {
+					fv = absent
+				}
+				tv, ok := t[k]
+				if !ok {
+					tv = absent
+				}
+				changes = append(changes, diffValues(search.KeyPath(path, k), fv, tv)...)
+			}
+			return
+		}
+	case []interface{}:
+		if t, ok := to.([]interface{}); ok {
+			for i := 0; i < len(f) || i < len(t); i++ {
+				var fv, tv interface{} = absent, absent
+				if i < len(f) {
+					fv = f[i]
+				}
+				if i < len(t) {
+					tv = t[i]
+				}
+				changes = append(changes, diffValues(indexPath(path, i), fv, tv)...)
+			}
+			return
+		}
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return nil
+	}
+	return []types.AttributeChange{{
+		Path: path,
+		Old:  encodeValue(from),
+		New:  encodeValue(to),
+	}}
+}
+
+// diffAttribute returns the changes of a single attribute between two
+// versions of a resource. Sensitive values are never walked nor shown,
+// and protected ones are compared through their hash.
+func diffAttribute(key string, from, to *types.Attribute) []types.AttributeChange {
+	var fv, tv interface{} = absent, absent
+	if (from != nil && from.Sensitive) || (to != nil && to.Sensitive) {
+		if from != nil && to != nil && sensitive.Fingerprint(from.Value) == sensitive.Fingerprint(to.Value) {
+			return nil
+		}
+		if from != nil {
+			fv = attributeValue(*from)
+		}
+		if to != nil {
+			tv = attributeValue(*to)
+		}
+		return []types.AttributeChange{{
+			Path:      search.KeyPath("", key),
+			Old:       encodeValue(fv),
+			New:       encodeValue(tv),
+			Sensitive: true,
+		}}
+	}
+
+	if from != nil {
+		fv = decodeAttribute(*from)
+	}
+	if to != nil {
+		tv = decodeAttribute(*to)
+	}
+	return diffValues(search.KeyPath("", key), fv, tv)
+}
+
+// diffResource returns the structured diff between two versions
+// of a resource, sorted by attribute
+func diffResource(from, to types.Resource) (changes []types.AttributeChange) {
+	changes = []types.AttributeChange{}
+	keys := resourceAttributes(from)
+	keys = append(keys, sliceDiff(resourceAttributes(to), keys)...)
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var fromAttr, toAttr *types.Attribute
+		if attr, err := getResourceAttribute(from, key); err == nil {
+			fromAttr = &attr
+		}
+		if attr, err := getResourceAttribute(to, key); err == nil {
+			toAttr = &attr
+		}
+		changes = append(changes, diffAttribute(key, fromAttr, toAttr)...)
+	}
+	return
+}
@@ -0,0 +1,113 @@
+package compare
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/camptocamp/terraboard/types"
+)
+
+func TestStateResources_instances(t *testing.T) {
+	state := types.State{
+		Modules: []types.Module{
+			{
+				Path: "",
+				Resources: []types.Resource{
+					{Type: "aws_instance", Name: "web"},
+					{Mode: types.ResourceModeData, Type: "aws_ami", Name: "ubuntu"},
+				},
+			},
+			{
+				Path: `module.vpc["prod"]`,
+				Resources: []types.Resource{
+					{Type: "aws_subnet", Name: "private", Index: "[0]"},
+					{Type: "aws_subnet", Name: "private", Index: "[1]"},
+					{Type: "aws_route", Name: "nat", Index: `["eu-west-1a"]`},
+				},
+			},
+		},
+	}
+
+	expectedResult := []string{
+		"aws_instance.web",
+		"data.aws_ami.ubuntu",
+		`module.vpc["prod"].aws_subnet.private[0]`,
+		`module.vpc["prod"].aws_subnet.private[1]`,
+		`module.vpc["prod"].aws_route.nat["eu-west-1a"]`,
+	}
+	result := stateResources(state)
+	if !reflect.DeepEqual(result, expectedResult) {
+		t.Fatalf("Expected %s, got %s", expectedResult, result)
+	}
+
+	res, err := getResource(state, `module.vpc["prod"].aws_subnet.private[1]`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if res.Index != "[1]" {
+		t.Fatalf("Expected instance [1], got %s", res.Index)
+	}
+}
+
+func TestDiffResource(t *testing.T) {
+	from := types.Resource{
+		Attributes: []types.Attribute{
+			{Key: "tags", Value: `{"Name":"web","Team":"platform","kubernetes.io/role":"node"}`},
+			{Key: "ingress", Value: `[{"cidr_blocks":["10.0.0.0/8"],"from_port":22}]`},
+			{Key: "ami", Value: `"ami-1"`},
+			{Key: "password", Value: `"hunter2"`, Sensitive: true},
+			{Key: "user_data", Value: `null`},
+		},
+	}
+	to := types.Resource{
+		Attributes: []types.Attribute{
+			{Key: "tags", Value: `{"Name":"web-1","Team":"platform"}`},
+			{Key: "ingress", Value: `[{"cidr_blocks":["10.0.0.0/8","192.168.0.0/16"],"from_port":22}]`},
+			{Key: "ami", Value: `"ami-1"`},
+			{Key: "password", Value: `"correct horse"`, Sensitive: true},
+			{Key: "monitoring", Value: `true`},
+		},
+	}
+
+	expectedResult := []types.AttributeChange{
+		{Path: "ingress[0].cidr_blocks[1]", New: json.RawMessage(`"192.168.0.0/16"`)},
+		{Path: "monitoring", New: json.RawMessage(`true`)},
+		{Path: "password", Old: json.RawMessage(`"(9)"`), New: json.RawMessage(`"(15)"`), Sensitive: true},
+		{Path: "tags.Name", Old: json.RawMessage(`"web"`), New: json.RawMessage(`"web-1"`)},
+		{Path: `tags["kubernetes.io/role"]`, Old: json.RawMessage(`"node"`)},
+		{Path: "user_data", Old: json.RawMessage(`null`)},
+	}
+
+	result := diffResource(from, to)
+	if !reflect.DeepEqual(result, expectedResult) {
+		t.Fatalf("Expected %v, got %v", expectedResult, result)
+	}
+
+	if result := diffResource(from, from); len(result) != 0 {
+		t.Fatalf("Expected no changes, got %v", result)
+	}
+}
+
+func TestDiffResource_protected(t *testing.T) {
+	from := types.Resource{
+		Attributes: []types.Attribute{
+			{Key: "password", Value: `"encrypted:sha256=abc,length=9,key=k1,data=d1"`, Sensitive: true},
+			{Key: "token", Value: `"redacted:sha256=def,length=12"`, Sensitive: true},
+		},
+	}
+	to := types.Resource{
+		Attributes: []types.Attribute{
+			{Key: "password", Value: `"encrypted:sha256=abc,length=9,key=k2,data=d2"`, Sensitive: true},
+			{Key: "token", Value: `"redacted:sha256=123,length=15"`, Sensitive: true},
+		},
+	}
+
+	expectedResult := []types.AttributeChange{
+		{Path: "token", Old: json.RawMessage(`"(12)"`), New: json.RawMessage(`"(15)"`), Sensitive: true},
+	}
+	result := diffResource(from, to)
+	if !reflect.DeepEqual(result, expectedResult) {
+		t.Fatalf("Expected %v, got %v", expectedResult, result)
+	}
+}
@@ -0,0 +1,56 @@
+package compare
+
+import "github.com/camptocamp/terraboard/types"
+
+// Return the only resource of a state, if any
+func singleResource(state types.State) *types.Resource {
+	for _, m := range state.Modules {
+		for i := range m.Resources {
+			return &m.Resources[i]
+		}
+	}
+	return nil
+}
+
+// ResourceHistory walks the versions of a lineage, sorted from the oldest
+// to the newest, and returns the versions in which a resource instance was
+// created, changed or removed.
+// The states must only hold the modules and resources of that instance.
+func ResourceHistory(address string, states []types.State) (history types.ResourceHistory) {
+	history.Address = address
+	history.Changes = []types.ResourceChange{}
+
+	var previous *types.Resource
+	for _, st := range states {
+		current := singleResource(st)
+		change := types.ResourceChange{
+			Path:         st.Path,
+			VersionID:    st.Version.VersionID,
+			Serial:       st.Serial,
+			TFVersion:    st.TFVersion,
+			LastModified: st.Version.LastModified,
+		}
+
+		switch {
+		case previous == nil && current == nil:
+			continue
+		case previous == nil:
+			change.Action = types.ResourceCreated
+			change.Attributes = diffResource(types.Resource{}, *current)
+		case current == nil:
+			change.Action = types.ResourceRemoved
+			change.Attributes = diffResource(*previous, types.Resource{})
+		default:
+			change.Action = types.ResourceChanged
+			change.Attributes = diffResource(*previous, *current)
+			if len(change.Attributes) == 0 {
+				previous = current
+				continue
+			}
+		}
+
+		history.Changes = append(history.Changes, change)
+		previous = current
+	}
+	return
+}
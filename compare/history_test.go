@@ -0,0 +1,79 @@
+package compare
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/types"
+)
+
+func historyState(serial int64, attrs ...types.Attribute) types.State {
+	st := types.State{
+		Path:    "network.tfstate",
+		Serial:  serial,
+		Version: types.Version{VersionID: string(rune('a' + serial)), LastModified: time.Unix(serial, 0)},
+		Modules: []types.Module{{Path: "module.vpc"}},
+	}
+	if attrs != nil {
+		st.Modules[0].Resources = []types.Resource{{
+			Type:       "aws_subnet",
+			Name:       "private",
+			Index:      "[2]",
+			Attributes: attrs,
+		}}
+	}
+	return st
+}
+
+func TestResourceHistory(t *testing.T) {
+	cidr := types.Attribute{Key: "cidr_block", Value: `"10.0.2.0/24"`}
+	newCidr := types.Attribute{Key: "cidr_block", Value: `"10.0.3.0/24"`}
+	secret := types.Attribute{Key: "secret", Value: `"hunter2"`, Sensitive: true}
+
+	states := []types.State{
+		historyState(1),
+		historyState(2, cidr),
+		historyState(3, cidr),
+		historyState(4, newCidr, secret),
+		historyState(5),
+		historyState(6),
+	}
+
+	history := ResourceHistory("module.vpc.aws_subnet.private[2]", states)
+	if history.Address != "module.vpc.aws_subnet.private[2]" {
+		t.Errorf("Expected address module.vpc.aws_subnet.private[2], got %s", history.Address)
+	}
+
+	var actions []string
+	var serials []int64
+	for _, c := range history.Changes {
+		actions = append(actions, c.Action)
+		serials = append(serials, c.Serial)
+	}
+	if !reflect.DeepEqual(actions, []string{types.ResourceCreated, types.ResourceChanged, types.ResourceRemoved}) {
+		t.Errorf("Unexpected actions %v", actions)
+	}
+	if !reflect.DeepEqual(serials, []int64{2, 4, 5}) {
+		t.Errorf("Unexpected serials %v", serials)
+	}
+
+	expected := []types.AttributeChange{
+		{Path: "cidr_block", Old: json.RawMessage(`"10.0.2.0/24"`), New: json.RawMessage(`"10.0.3.0/24"`)},
+		{Path: "secret", New: json.RawMessage(`"(9)"`), Sensitive: true},
+	}
+	if !reflect.DeepEqual(history.Changes[1].Attributes, expected) {
+		t.Errorf("Unexpected change attributes %v", history.Changes[1].Attributes)
+	}
+	if history.Changes[1].VersionID != states[3].Version.VersionID {
+		t.Errorf("Expected version %s, got %s", states[3].Version.VersionID, history.Changes[1].VersionID)
+	}
+}
+
+func TestResourceHistory_unknown(t *testing.T) {
+	history := ResourceHistory("aws_instance.web", []types.State{historyState(1)})
+	if len(history.Changes) != 0 {
+		t.Errorf("Expected no changes, got %v", history.Changes)
+	}
+}
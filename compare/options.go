@@ -0,0 +1,228 @@
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/camptocamp/terraboard/internal/terraform/addrs"
+	"github.com/camptocamp/terraboard/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// AddressMapping rewrites the resource addresses matching a pattern,
+// e.g. to strip a module prefix or an environment suffix
+type AddressMapping struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseAddressMapping parses a mapping given as pattern=replacement,
+// or as a single pattern to remove from the addresses
+func ParseAddressMapping(s string) (m AddressMapping, err error) {
+	pattern, replacement, _ := strings.Cut(s, "=")
+	m.Pattern, err = regexp.Compile(pattern)
+	m.Replacement = replacement
+	return
+}
+
+// Options customize the comparison of two states.
+// Mappings are applied in order to the addresses of both states, and
+// the Ignore attribute paths (e.g. id, tags.Environment) are removed
+// from all their resources.
+type Options struct {
+	Mappings []AddressMapping
+	Ignore   []string
+}
+
+// CompareWith returns the difference between two States, which may
+// belong to different lineages, once the options are applied to both
+func CompareWith(from, to types.State, opts Options) (comp types.StateCompare, err error) {
+	var ignore [][]interface{}
+	for _, p := range opts.Ignore {
+		steps, err := parsePath(p)
+		if err != nil {
+			return comp, err
+		}
+		ignore = append(ignore, steps)
+	}
+
+	return Compare(opts.apply(from, ignore), opts.apply(to, ignore))
+}
+
+// apply returns a copy of a state with its resources moved to their
+// mapped addresses and stripped of the ignored attributes.
+// Outputs are not kept.
+func (o Options) apply(state types.State, ignore [][]interface{}) types.State {
+	if len(o.Mappings) == 0 && len(ignore) == 0 {
+		return state
+	}
+
+	var modules []types.Module
+	index := make(map[string]int)
+	for _, m := range state.Modules {
+		for _, r := range m.Resources {
+			path, res := o.mapResource(m.Path, r)
+			res.Attributes = stripAttributes(res.Attributes, ignore)
+
+			i, ok := index[path]
+			if !ok {
+				i = len(modules)
+				index[path] = i
+				modules = append(modules, types.Module{Path: path})
+			}
+			modules[i].Resources = append(modules[i].Resources, res)
+		}
+	}
+	state.Modules = modules
+	return state
+}
+
+// mapResource returns the module path and resource matching
+// the mapped address of a resource instance
+func (o Options) mapResource(modulePath string, r types.Resource) (string, types.Resource) {
+	addr := r.Address(modulePath)
+	mapped := addr
+	for _, m := range o.Mappings {
+		mapped = m.Pattern.ReplaceAllString(mapped, m.Replacement)
+	}
+	if mapped == addr {
+		return modulePath, r
+	}
+
+	abs, diags := addrs.ParseAbsResourceInstanceStr(mapped)
+	if diags.HasErrors() {
+		log.WithFields(log.Fields{
+			"address": addr,
+			"mapped":  mapped,
+		}).Warn("Ignoring invalid mapped resource address")
+		return modulePath, r
+	}
+	r.Mode = types.ResourceModeManaged
+	if abs.Resource.Resource.Mode == addrs.DataResourceMode {
+		r.Mode = types.ResourceModeData
+	}
+	r.Type = abs.Resource.Resource.Type
+	r.Name = abs.Resource.Resource.Name
+	r.Index = ""
+	if abs.Resource.Key != addrs.NoKey {
+		r.Index = abs.Resource.Key.String()
+	}
+	return abs.Module.String(), r
+}
+
+// parsePath splits an attribute path, as found in the structured diffs
+// (e.g. tags.Name, ingress[0].cidr_blocks or tags["kubernetes.io/role"]),
+// into map keys and list indexes
+func parsePath(path string) (steps []interface{}, err error) {
+	s := path
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, "["):
+			end := strings.Index(s, "]")
+			if strings.HasPrefix(s, `["`) {
+				end = strings.Index(s, `"]`) + 1
+			}
+			if end < 1 {
+				return nil, fmt.Errorf("invalid attribute path %s", path)
+			}
+			inner := s[1:end]
+			if i, err := strconv.Atoi(inner); err == nil {
+				steps = append(steps, i)
+			} else if key, err := strconv.Unquote(inner); err == nil {
+				steps = append(steps, key)
+			} else {
+				return nil, fmt.Errorf("invalid attribute path %s", path)
+			}
+			s = s[end+1:]
+		case strings.HasPrefix(s, "."):
+			if len(steps) == 0 {
+				return nil, fmt.Errorf("invalid attribute path %s", path)
+			}
+			s = s[1:]
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			steps = append(steps, s[:end])
+			s = s[end:]
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("invalid attribute path %s", path)
+	}
+	if _, ok := steps[0].(string); !ok {
+		return nil, fmt.Errorf("invalid attribute path %s", path)
+	}
+	return
+}
+
+// stripAttributes returns the attributes without the ignored paths
+func stripAttributes(attrs []types.Attribute, ignore [][]interface{}) []types.Attribute {
+	if len(ignore) == 0 {
+		return attrs
+	}
+
+	var stripped []types.Attribute
+	for _, attr := range attrs {
+		keep := true
+		for _, steps := range ignore {
+			if steps[0] != attr.Key {
+				continue
+			}
+			if len(steps) == 1 {
+				keep = false
+				break
+			}
+			// Keep the large integers as they are
+			d := json.NewDecoder(strings.NewReader(attr.Value))
+			d.UseNumber()
+			var v interface{}
+			if err := d.Decode(&v); err != nil {
+				continue
+			}
+			if removePath(v, steps[1:]) {
+				value, _ := json.Marshal(v)
+				attr.Value = string(value)
+			}
+		}
+		if keep {
+			stripped = append(stripped, attr)
+		}
+	}
+	return stripped
+}
+
+// removePath removes a path from a decoded JSON value,
+// returning whether it was found
+func removePath(v interface{}, steps []interface{}) bool {
+	switch step := steps[0].(type) {
+	case string:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if len(steps) == 1 {
+			_, found := obj[step]
+			delete(obj, step)
+			return found
+		}
+		child, ok := obj[step]
+		return ok && removePath(child, steps[1:])
+	case int:
+		list, ok := v.([]interface{})
+		if !ok || step < 0 || step >= len(list) {
+			return false
+		}
+		if len(steps) == 1 {
+			// Keep the following elements at their index
+			list[step] = nil
+			return true
+		}
+		return removePath(list[step], steps[1:])
+	}
+	return false
+}
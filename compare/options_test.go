@@ -0,0 +1,126 @@
+package compare
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/camptocamp/terraboard/types"
+)
+
+func TestParsePath(t *testing.T) {
+	for path, expected := range map[string][]interface{}{
+		"id":                         {"id"},
+		"tags.Environment":           {"tags", "Environment"},
+		"ingress[0].cidr_blocks[1]":  {"ingress", 0, "cidr_blocks", 1},
+		`tags["kubernetes.io/role"]`: {"tags", "kubernetes.io/role"},
+	} {
+		steps, err := parsePath(path)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", path, err)
+		}
+		if !reflect.DeepEqual(steps, expected) {
+			t.Fatalf("Expected %v for %s, got %v", expected, path, steps)
+		}
+	}
+
+	for _, path := range []string{"", "[0]", ".id", "tags[foo]", `tags["Name`} {
+		if _, err := parsePath(path); err == nil {
+			t.Fatalf("Expected an error for %s, got nil", path)
+		}
+	}
+}
+
+func TestParseAddressMapping(t *testing.T) {
+	m, err := ParseAddressMapping(`_(staging|prod)\b=`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := m.Pattern.ReplaceAllString("aws_s3_bucket.logs_staging", m.Replacement); got != "aws_s3_bucket.logs" {
+		t.Fatalf("Expected aws_s3_bucket.logs, got %s", got)
+	}
+
+	if _, err := ParseAddressMapping("(=foo"); err == nil {
+		t.Fatalf("Expected an error, got nil")
+	}
+}
+
+func TestCompareWith(t *testing.T) {
+	staging := types.State{
+		Path: "staging/terraform.tfstate",
+		Modules: []types.Module{
+			{
+				Path: "module.staging",
+				Resources: []types.Resource{
+					{Type: "aws_s3_bucket", Name: "logs", Attributes: []types.Attribute{
+						{Key: "id", Value: `"logs-staging"`},
+						{Key: "versioning", Value: `true`},
+						{Key: "tags", Value: `{"Environment":"staging","Team":"platform"}`},
+					}},
+					{Type: "aws_instance", Name: "bastion"},
+				},
+			},
+		},
+	}
+	prod := types.State{
+		Path: "prod/terraform.tfstate",
+		Modules: []types.Module{
+			{
+				Path: "module.prod",
+				Resources: []types.Resource{
+					{Type: "aws_s3_bucket", Name: "logs", Attributes: []types.Attribute{
+						{Key: "id", Value: `"logs-prod"`},
+						{Key: "versioning", Value: `false`},
+						{Key: "tags", Value: `{"Environment":"prod","Team":"platform"}`},
+					}},
+				},
+			},
+		},
+	}
+
+	mapping, _ := ParseAddressMapping(`^module\.(staging|prod)\.=`)
+	comp, err := CompareWith(staging, prod, Options{
+		Mappings: []AddressMapping{mapping},
+		Ignore:   []string{"id", "tags.Environment"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(comp.Differences.InBoth, []string{"aws_s3_bucket.logs"}) {
+		t.Fatalf("Expected aws_s3_bucket.logs in both, got %v", comp.Differences.InBoth)
+	}
+	var onlyInOld []string
+	for r := range comp.Differences.OnlyInOld {
+		onlyInOld = append(onlyInOld, r)
+	}
+	sort.Strings(onlyInOld)
+	if !reflect.DeepEqual(onlyInOld, []string{"aws_instance.bastion"}) {
+		t.Fatalf("Expected aws_instance.bastion only in old, got %v", onlyInOld)
+	}
+
+	expectedChanges := []types.AttributeChange{
+		{Path: "versioning", Old: json.RawMessage(`true`), New: json.RawMessage(`false`)},
+	}
+	if changes := comp.Differences.ResourceDiff["aws_s3_bucket.logs"].Changes; !reflect.DeepEqual(changes, expectedChanges) {
+		t.Fatalf("Expected %v, got %v", expectedChanges, changes)
+	}
+
+	// The compared states are left untouched
+	if len(staging.Modules[0].Resources[0].Attributes) != 3 {
+		t.Fatalf("Expected the states to be left untouched")
+	}
+
+	// Large integers are kept when stripping an ignored path
+	stripped := stripAttributes([]types.Attribute{
+		{Key: "settings", Value: `{"id":"a","max_size":9007199254740993}`},
+	}, [][]interface{}{{"settings", "id"}})
+	if stripped[0].Value != `{"max_size":9007199254740993}` {
+		t.Fatalf("Expected the large integer to be kept, got %s", stripped[0].Value)
+	}
+
+	if _, err := CompareWith(staging, prod, Options{Ignore: []string{"[0]"}}); err == nil {
+		t.Fatalf("Expected an error, got nil")
+	}
+}
@@ -0,0 +1,275 @@
+package compare
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/camptocamp/terraboard/search"
+	"github.com/camptocamp/terraboard/sensitive"
+	"github.com/camptocamp/terraboard/types"
+)
+
+// plannedModule is a module of the planned_values of a JSON plan
+type plannedModule struct {
+	Resources []struct {
+		Address         string                 `json:"address"`
+		Values          map[string]interface{} `json:"values"`
+		SensitiveValues map[string]interface{} `json:"sensitive_values"`
+	} `json:"resources"`
+	ChildModules []plannedModule `json:"child_modules"`
+}
+
+// plannedValues holds the parts of a JSON plan used to verify it.
+// The parsed plan stored in the database flattens the attribute
+// values, so they are read again from the raw plan.
+type plannedValues struct {
+	PlannedValues struct {
+		RootModule plannedModule `json:"root_module"`
+	} `json:"planned_values"`
+	PriorState struct {
+		Values struct {
+			RootModule plannedModule `json:"root_module"`
+		} `json:"values"`
+	} `json:"prior_state"`
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Mode    string `json:"mode"`
+		Change  struct {
+			Actions []string `json:"actions"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+// Return the values and sensitive values of the resources
+// of a module and its children, by address
+func (m plannedModule) values() (values, sensitive map[string]map[string]interface{}) {
+	values = make(map[string]map[string]interface{})
+	sensitive = make(map[string]map[string]interface{})
+	modules := []plannedModule{m}
+	for len(modules) > 0 {
+		m := modules[0]
+		modules = append(modules[1:], m.ChildModules...)
+		for _, r := range m.Resources {
+			values[r.Address] = r.Values
+			sensitive[r.Address] = r.SensitiveValues
+		}
+	}
+	return
+}
+
+// Return whether a resource is expected in the state after the actions
+func expectedAfter(actions []string) bool {
+	for _, a := range actions {
+		if a == "create" || a == "update" {
+			return true
+		}
+	}
+	return false
+}
+
+// Return whether the actions change the state
+func changesState(actions []string) bool {
+	for _, a := range actions {
+		if a != "no-op" && a != "read" {
+			return true
+		}
+	}
+	return false
+}
+
+// diffPlanned returns the differences between a planned and an actual
+// value. Planned null values are unknown until the apply and are skipped,
+// as are the actual attributes missing from the plan.
+func diffPlanned(path string, planned, actual interface{}) (changes []types.AttributeChange) {
+	switch p := planned.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		if a, ok := actual.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(p))
+			for k := range p {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				av, ok := a[k]
+				if !ok {
+					av = absent
+				}
+				changes = append(changes, diffPlanned(search.KeyPath(path, k), p[k], av)...)
+			}
+			return
+		}
+	case []interface{}:
+		if a, ok := actual.([]interface{}); ok {
+			for i := 0; i < len(p) || i < len(a); i++ {
+				var pv, av interface{} = absent, absent
+				if i < len(p) {
+					pv = p[i]
+				}
+				if i < len(a) {
+					av = a[i]
+				}
+				changes = append(changes, diffPlanned(indexPath(path, i), pv, av)...)
+			}
+			return
+		}
+	}
+
+	if reflect.DeepEqual(planned, actual) {
+		return nil
+	}
+	return []types.AttributeChange{{
+		Path: path,
+		Old:  encodeValue(planned),
+		New:  encodeValue(actual),
+	}}
+}
+
+// Return whether a value of the sensitive_values of a planned
+// resource marks any of its leaves as sensitive
+func isSensitive(v interface{}) bool {
+	switch s := v.(type) {
+	case bool:
+		return s
+	case map[string]interface{}:
+		for _, e := range s {
+			if isSensitive(e) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range s {
+			if isSensitive(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// diffPlannedResource returns the differences between the planned values
+// of a resource and its actual attributes. Sensitive values are only
+// compared, never shown.
+func diffPlannedResource(values, sensitiveValues map[string]interface{}, res types.Resource) []types.AttributeChange {
+	changes := []types.AttributeChange{}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var actual interface{} = absent
+		attr, err := getResourceAttribute(res, key)
+		if err == nil {
+			// Protected values cannot be compared to the planned ones
+			if sensitive.IsProtected(attr.Value) {
+				continue
+			}
+			actual = decodeAttribute(attr)
+		}
+
+		diff := diffPlanned(search.KeyPath("", key), values[key], actual)
+		if len(diff) == 0 {
+			continue
+		}
+		if attr.Sensitive || isSensitive(sensitiveValues[key]) {
+			changes = append(changes, types.AttributeChange{
+				Path:      search.KeyPath("", key),
+				Sensitive: true,
+			})
+			continue
+		}
+		changes = append(changes, diff...)
+	}
+	return changes
+}
+
+// VerifyPlan checks whether the changes of a plan landed in the state,
+// the first version of its lineage that followed it. A nil state means
+// that no such version exists yet.
+func VerifyPlan(plan types.Plan, state *types.State) (verif types.PlanVerification, err error) {
+	verif.Resources = []types.PlannedResource{}
+
+	var pv plannedValues
+	if len(plan.PlanJSON) > 0 {
+		if err = json.Unmarshal(plan.PlanJSON, &pv); err != nil {
+			return
+		}
+	}
+
+	values, sensitive := pv.PlannedValues.RootModule.values()
+	priorValues, priorSensitive := pv.PriorState.Values.RootModule.values()
+
+	for _, rc := range pv.ResourceChanges {
+		if rc.Mode == "data" || !changesState(rc.Change.Actions) {
+			continue
+		}
+		verif.Resources = append(verif.Resources, types.PlannedResource{
+			Address:     rc.Address,
+			Actions:     rc.Change.Actions,
+			Status:      types.PlannedResourceNotApplied,
+			Differences: []types.AttributeChange{},
+		})
+	}
+	sort.Slice(verif.Resources, func(i, j int) bool {
+		return verif.Resources[i].Address < verif.Resources[j].Address
+	})
+
+	if len(verif.Resources) == 0 {
+		verif.Status = types.PlanNoChanges
+		return
+	}
+	if state == nil {
+		verif.Status = types.PlanUnapplied
+		return
+	}
+
+	verif.State = &types.StateInfo{
+		Path:          state.Path,
+		VersionID:     state.Version.VersionID,
+		ResourceCount: len(stateResources(*state)),
+		TFVersion:     state.TFVersion,
+		Serial:        state.Serial,
+	}
+
+	var applied, diverged int
+	for i, pr := range verif.Resources {
+		res, err := getResource(*state, pr.Address)
+		found := err == nil
+		switch {
+		case found != expectedAfter(pr.Actions):
+			continue
+		case found:
+			pr.Differences = diffPlannedResource(values[pr.Address], sensitive[pr.Address], res)
+			// An update that didn't land leaves the prior values
+			prior, ok := priorValues[pr.Address]
+			if len(pr.Differences) > 0 && ok && len(diffPlannedResource(prior, priorSensitive[pr.Address], res)) == 0 {
+				continue
+			}
+		}
+
+		applied++
+		pr.Status = types.PlannedResourceApplied
+		if len(pr.Differences) > 0 {
+			diverged++
+			pr.Status = types.PlannedResourceDiverged
+		}
+		verif.Resources[i] = pr
+	}
+
+	switch {
+	case applied == 0:
+		verif.Status = types.PlanUnapplied
+	case applied < len(verif.Resources):
+		verif.Status = types.PlanPartiallyApplied
+	case diverged > 0:
+		verif.Status = types.PlanDiverged
+	default:
+		verif.Status = types.PlanApplied
+	}
+	return
+}
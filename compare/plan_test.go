@@ -0,0 +1,170 @@
+package compare
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/camptocamp/terraboard/types"
+)
+
+const verifiedPlan = `{
+  "planned_values": {"root_module": {
+    "resources": [
+      {"address": "aws_instance.web", "values": {"ami": "ami-1", "id": null, "tags": {"Name": "web"}}},
+      {"address": "aws_s3_bucket.logs", "values": {"tags": {"Env": "prod"}}},
+      {"address": "aws_db_instance.main", "values": {"engine": "postgres", "password": "new"}, "sensitive_values": {"password": true}}
+    ],
+    "child_modules": [{"address": "module.vpc", "resources": [
+      {"address": "module.vpc.aws_subnet.private[0]", "values": {"cidr_block": "10.0.0.0/24", "tags": {}}}
+    ]}]
+  }},
+  "prior_state": {"values": {"root_module": {"resources": [
+    {"address": "aws_s3_bucket.logs", "values": {"tags": {"Env": "staging"}}},
+    {"address": "aws_db_instance.main", "values": {"engine": "postgres", "password": "old"}, "sensitive_values": {"password": true}},
+    {"address": "aws_eip.old", "values": {"vpc": true}}
+  ]}}},
+  "resource_changes": [
+    {"address": "aws_instance.web", "mode": "managed", "change": {"actions": ["create"]}},
+    {"address": "aws_s3_bucket.logs", "mode": "managed", "change": {"actions": ["update"]}},
+    {"address": "aws_db_instance.main", "mode": "managed", "change": {"actions": ["update"]}},
+    {"address": "module.vpc.aws_subnet.private[0]", "mode": "managed", "change": {"actions": ["delete", "create"]}},
+    {"address": "aws_eip.old", "mode": "managed", "change": {"actions": ["delete"]}},
+    {"address": "aws_vpc.main", "mode": "managed", "change": {"actions": ["no-op"]}},
+    {"address": "data.aws_ami.ubuntu", "mode": "data", "change": {"actions": ["read"]}}
+  ]
+}`
+
+func verifiedState(bucketTags, password, cidr string) *types.State {
+	return &types.State{
+		Path:    "network.tfstate",
+		Serial:  4,
+		Version: types.Version{VersionID: "v4"},
+		Modules: []types.Module{
+			{
+				Resources: []types.Resource{
+					{Type: "aws_instance", Name: "web", Attributes: []types.Attribute{
+						{Key: "ami", Value: `"ami-1"`},
+						{Key: "id", Value: `"i-123"`},
+						{Key: "tags", Value: `{"Name":"web"}`},
+					}},
+					{Type: "aws_s3_bucket", Name: "logs", Attributes: []types.Attribute{
+						{Key: "tags", Value: bucketTags},
+					}},
+					{Type: "aws_db_instance", Name: "main", Attributes: []types.Attribute{
+						{Key: "engine", Value: `"postgres"`},
+						{Key: "password", Value: password, Sensitive: true},
+					}},
+					{Type: "aws_vpc", Name: "main"},
+				},
+			},
+			{
+				Path: "module.vpc",
+				Resources: []types.Resource{
+					{Type: "aws_subnet", Name: "private", Index: "[0]", Attributes: []types.Attribute{
+						{Key: "cidr_block", Value: cidr},
+						{Key: "tags", Value: `{}`},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func plannedStatuses(verif types.PlanVerification) map[string]string {
+	statuses := make(map[string]string)
+	for _, r := range verif.Resources {
+		statuses[r.Address] = r.Status
+	}
+	return statuses
+}
+
+func TestVerifyPlan(t *testing.T) {
+	plan := types.Plan{PlanJSON: []byte(verifiedPlan)}
+
+	verif, err := VerifyPlan(plan, verifiedState(`{"Env":"staging"}`, `"old"`, `"10.0.1.0/24"`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if verif.Status != types.PlanPartiallyApplied {
+		t.Errorf("Expected status %s, got %s", types.PlanPartiallyApplied, verif.Status)
+	}
+	if verif.State == nil || verif.State.VersionID != "v4" {
+		t.Errorf("Expected the verification against version v4, got %v", verif.State)
+	}
+
+	expectedStatuses := map[string]string{
+		"aws_instance.web":                 types.PlannedResourceApplied,
+		"aws_s3_bucket.logs":               types.PlannedResourceNotApplied,
+		"aws_db_instance.main":             types.PlannedResourceNotApplied,
+		"module.vpc.aws_subnet.private[0]": types.PlannedResourceDiverged,
+		"aws_eip.old":                      types.PlannedResourceApplied,
+	}
+	if statuses := plannedStatuses(verif); !reflect.DeepEqual(statuses, expectedStatuses) {
+		t.Errorf("Expected statuses %v, got %v", expectedStatuses, statuses)
+	}
+
+	expectedDiff := []types.AttributeChange{
+		{Path: "cidr_block", Old: json.RawMessage(`"10.0.0.0/24"`), New: json.RawMessage(`"10.0.1.0/24"`)},
+	}
+	for _, r := range verif.Resources {
+		if r.Address == "module.vpc.aws_subnet.private[0]" && !reflect.DeepEqual(r.Differences, expectedDiff) {
+			t.Errorf("Expected differences %v, got %v", expectedDiff, r.Differences)
+		}
+	}
+}
+
+func TestVerifyPlan_diverged(t *testing.T) {
+	plan := types.Plan{PlanJSON: []byte(verifiedPlan)}
+
+	verif, err := VerifyPlan(plan, verifiedState(`{"Env":"prod"}`, `"other"`, `"10.0.0.0/24"`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if verif.Status != types.PlanDiverged {
+		t.Errorf("Expected status %s, got %s", types.PlanDiverged, verif.Status)
+	}
+	for _, r := range verif.Resources {
+		if r.Address != "aws_db_instance.main" {
+			if r.Status != types.PlannedResourceApplied {
+				t.Errorf("Expected %s to be applied, got %s", r.Address, r.Status)
+			}
+			continue
+		}
+		// Sensitive values are never shown
+		expected := []types.AttributeChange{{Path: "password", Sensitive: true}}
+		if !reflect.DeepEqual(r.Differences, expected) {
+			t.Errorf("Expected differences %v, got %v", expected, r.Differences)
+		}
+	}
+
+	verif, _ = VerifyPlan(plan, verifiedState(`{"Env":"prod"}`, `"new"`, `"10.0.0.0/24"`))
+	if verif.Status != types.PlanApplied {
+		t.Errorf("Expected status %s, got %s", types.PlanApplied, verif.Status)
+	}
+}
+
+func TestVerifyPlan_unapplied(t *testing.T) {
+	verif, err := VerifyPlan(types.Plan{PlanJSON: []byte(verifiedPlan)}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if verif.Status != types.PlanUnapplied || verif.State != nil {
+		t.Errorf("Expected an unapplied plan, got %v", verif)
+	}
+	if len(verif.Resources) != 5 {
+		t.Errorf("Expected 5 planned resources, got %d", len(verif.Resources))
+	}
+
+	verif, err = VerifyPlan(types.Plan{}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if verif.Status != types.PlanNoChanges {
+		t.Errorf("Expected status %s, got %s", types.PlanNoChanges, verif.Status)
+	}
+
+	if _, err := VerifyPlan(types.Plan{PlanJSON: []byte(`{`)}, nil); err == nil {
+		t.Errorf("Expected an error, got nil")
+	}
+}
@@ -16,11 +16,18 @@ func (s *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 			Name:         "gorm",
 			SSLMode:      "require",
 			SyncInterval: 1,
+			SyncWorkers:  4,
 		},
 		Log: LogConfig{
 			Level:  "info",
 			Format: "plain",
 		},
+		Sensitive: SensitiveConfig{
+			Mode: "plaintext",
+		},
+		Stale: StaleConfig{
+			Days: 90,
+		},
 		Web: WebConfig{
 			Port:        8080,
 			SwaggerPort: 8081,
@@ -60,3 +67,57 @@ func (s *GitlabConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*s = GitlabConfig(raw)
 	return nil
 }
+
+func (s *AzureConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawAzureConfig AzureConfig
+	raw := rawAzureConfig{
+		FileExtension: []string{".tfstate"},
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*s = AzureConfig(raw)
+	return nil
+}
+
+func (s *PostgresConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawPostgresConfig PostgresConfig
+	raw := rawPostgresConfig{
+		SchemaName: "terraform_remote_state",
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*s = PostgresConfig(raw)
+	return nil
+}
+
+func (s *HTTPConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawHTTPConfig HTTPConfig
+	raw := rawHTTPConfig{
+		LockMethod:    "LOCK",
+		UnlockMethod:  "UNLOCK",
+		ProbeInterval: 300,
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*s = HTTPConfig(raw)
+	return nil
+}
+
+func (s *WebhookConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawWebhookConfig WebhookConfig
+	raw := rawWebhookConfig{
+		MaxRetries: 3,
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*s = WebhookConfig(raw)
+	return nil
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"time"
+
+	"github.com/camptocamp/terraboard/types"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// latestPaths selects the lineages whose latest version is at some paths
+const latestPaths = "SELECT t.lineage_id FROM (SELECT DISTINCT ON(states.lineage_id) states.lineage_id, states.path" +
+	" FROM states JOIN versions ON versions.id = states.version_id" +
+	" ORDER BY states.lineage_id, versions.last_modified DESC) t WHERE t.path IN ?"
+
+// listedElsewhere tells whether a path is still listed by any provider
+const listedElsewhere = "NOT EXISTS (SELECT 1 FROM state_paths WHERE state_paths.path = ? AND state_paths.removed_at IS NULL)"
+
+// ListStatePaths returns the State paths listed by a provider
+// during its previous syncs, including the removed ones
+func (db *Database) ListStatePaths(provider string) (paths []types.StatePath, err error) {
+	err = db.Where("provider = ?", provider).Order("path").Find(&paths).Error
+	return
+}
+
+// ListAllStatePaths returns the State paths listed by all the providers
+// during their previous syncs, including the removed ones
+func (db *Database) ListAllStatePaths() (paths []types.StatePath, err error) {
+	err = db.Order("path").Find(&paths).Error
+	return
+}
+
+// PruneStatePaths deletes the State paths of the providers which are no
+// longer configured, e.g. renamed ones, without archiving their lineages
+func (db *Database) PruneStatePaths(providers []string) error {
+	if len(providers) == 0 {
+		return nil
+	}
+	res := db.Where("provider NOT IN ?", providers).Delete(&types.StatePath{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		log.WithFields(log.Fields{
+			"paths": res.RowsAffected,
+		}).Info("Pruned the state paths of former providers")
+	}
+	return nil
+}
+
+// UpdateStateCursor records the last modification time up to which the
+// versions of a State path listed by a provider are synced
+func (db *Database) UpdateStateCursor(provider, path string, cursor time.Time) error {
+	return db.Model(&types.StatePath{}).
+		Where("provider = ? AND path = ?", provider, path).
+		Update("cursor", cursor).Error
+}
+
+// UpdateStatePaths records the State paths listed by a provider, and the
+// paths it no longer lists along with their deletion time. The lineages
+// whose latest version is at a removed path are archived, unless another
+// provider still lists the path, and restored when it is listed again,
+// by any provider.
+func (db *Database) UpdateStatePaths(provider string, paths []string, removed map[string]time.Time, now time.Time) error {
+	known, err := db.ListStatePaths(provider)
+	if err != nil {
+		return err
+	}
+	wasRemoved := make(map[string]bool)
+	isKnown := make(map[string]bool)
+	for _, sp := range known {
+		isKnown[sp.Path] = true
+		wasRemoved[sp.Path] = sp.RemovedAt != nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for path, deletedAt := range removed {
+			t := deletedAt
+			if err := tx.Model(&types.StatePath{}).
+				Where("provider = ? AND path = ? AND removed_at IS NULL", provider, path).
+				Update("removed_at", &t).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&types.Lineage{}).
+				Where("archived_at IS NULL AND id IN ("+latestPaths+") AND "+listedElsewhere, []string{path}, path).
+				Update("archived_at", &t).Error; err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"provider":   provider,
+				"path":       path,
+				"deleted_at": t,
+			}).Info("State deleted, archiving its lineage")
+		}
+
+		var added []types.StatePath
+		var addedPaths []string
+		for _, path := range paths {
+			if !isKnown[path] {
+				added = append(added, types.StatePath{Path: path, Provider: provider, FirstSeen: now, LastSeen: now})
+				addedPaths = append(addedPaths, path)
+				continue
+			}
+			if !wasRemoved[path] {
+				continue
+			}
+			if err := tx.Model(&types.StatePath{}).
+				Where("provider = ? AND path = ?", provider, path).
+				Update("removed_at", nil).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&types.Lineage{}).
+				Where("archived_at IS NOT NULL AND id IN ("+latestPaths+")", []string{path}).
+				Update("archived_at", nil).Error; err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"provider": provider,
+				"path":     path,
+			}).Info("State restored, unarchiving its lineage")
+		}
+
+		if err := tx.Model(&types.StatePath{}).
+			Where("provider = ? AND removed_at IS NULL", provider).
+			Update("last_seen", now).Error; err != nil {
+			return err
+		}
+
+		if len(added) == 0 {
+			return nil
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "provider"}, {Name: "path"}},
+			DoUpdates: clause.AssignmentColumns([]string{"last_seen", "removed_at"}),
+		}).CreateInBatches(&added, 500).Error; err != nil {
+			return err
+		}
+		// Paths deleted from a former provider, e.g. a renamed one
+		return tx.Model(&types.Lineage{}).
+			Where("archived_at IS NOT NULL AND id IN ("+latestPaths+")", addedPaths).
+			Update("archived_at", nil).Error
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,12 +14,17 @@ import (
 	"github.com/camptocamp/terraboard/internal/terraform/addrs"
 	"github.com/camptocamp/terraboard/internal/terraform/states"
 	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	"github.com/camptocamp/terraboard/notify"
+	"github.com/camptocamp/terraboard/scan"
+	"github.com/camptocamp/terraboard/search"
+	"github.com/camptocamp/terraboard/sensitive"
 	"github.com/camptocamp/terraboard/state"
 	"github.com/camptocamp/terraboard/types"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/zclconf/go-cty/cty"
 	ctyJson "github.com/zclconf/go-cty/cty/json"
+	"gorm.io/datatypes"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -27,7 +33,10 @@ import (
 // Database is a wrapping structure to *gorm.DB
 type Database struct {
 	*gorm.DB
-	lock sync.Mutex
+	lock      sync.Mutex
+	notifier  *notify.Notifier
+	protector *sensitive.Protector
+	scanner   *scan.Scanner
 }
 
 var pageSize = 20
@@ -58,8 +67,12 @@ func Init(config config.DBConfig, debug bool) *Database {
 		&types.State{},
 		&types.Module{},
 		&types.Resource{},
+		&types.DeposedObject{},
 		&types.Attribute{},
+		&types.AttributePath{},
 		&types.OutputValue{},
+		&types.RemoteState{},
+		&types.Finding{},
 		&types.Plan{},
 		&types.PlanModel{},
 		&types.PlanModelVariable{},
@@ -72,11 +85,22 @@ func Init(config config.DBConfig, debug bool) *Database {
 		&types.PlanStateResourceAttribute{},
 		&types.PlanStateValue{},
 		&types.Change{},
+		&types.SyncRun{},
+		&types.SyncError{},
+		&types.StatePath{},
+		&types.StatRollup{},
 	)
 	if err != nil {
 		log.Fatalf("Migration failed: %v\n", err)
 	}
 
+	// State paths used to be unique regardless of their provider
+	if db.Migrator().HasIndex(&types.StatePath{}, "idx_state_paths_path") {
+		if err = db.Migrator().DropIndex(&types.StatePath{}, "idx_state_paths_path"); err != nil {
+			log.Fatalf("Migration failed: %v\n", err)
+		}
+	}
+
 	if debug {
 		db.Config.Logger.LogMode(logger.Info)
 	}
@@ -125,12 +149,19 @@ func (db *Database) stateS3toDB(sf *statefile.File, path string, versionID strin
 	var lineage types.Lineage
 	db.lock.Lock()
 	err = db.FirstOrCreate(&lineage, types.Lineage{Value: sf.Lineage}).Error
+	db.lock.Unlock()
 	if err != nil || lineage.ID == 0 {
 		log.WithField("error", err).
 			Error("Unknown error in stateS3toDB during lineage finding")
 		return types.State{}, err
 	}
-	db.lock.Unlock()
+
+	// A lineage with a new version is no longer archived
+	if lineage.ArchivedAt != nil {
+		if err = db.Model(&lineage).Update("archived_at", nil).Error; err != nil {
+			return types.State{}, err
+		}
+	}
 
 	st = types.State{
 		Path:      path,
@@ -147,12 +178,39 @@ func (db *Database) stateS3toDB(sf *statefile.File, path string, versionID strin
 		for _, r := range m.Resources {
 			for index, i := range r.Instances {
 				res := types.Resource{
+					Mode:       getResourceMode(r.Addr.Resource.Mode),
 					Type:       r.Addr.Resource.Type,
 					Name:       r.Addr.Resource.Name,
 					Index:      getResourceIndex(index),
-					Attributes: marshalAttributeValues(i.Current),
+					Provider:   r.ProviderConfig.String(),
+					Attributes: indexAttributes(db.protectAttributes(i.Current)),
+				}
+				if i.Current != nil {
+					res.SchemaVersion = i.Current.SchemaVersion
+					res.Status = getResourceStatus(i.Current.Status)
+					res.CreateBeforeDestroy = i.Current.CreateBeforeDestroy
+					res.Dependencies = marshalDependencies(i.Current.Dependencies)
+				}
+				for key, obj := range i.Deposed {
+					res.DeposedObjects = append(res.DeposedObjects, types.DeposedObject{
+						Key:                 string(key),
+						SchemaVersion:       obj.SchemaVersion,
+						Status:              getResourceStatus(obj.Status),
+						CreateBeforeDestroy: obj.CreateBeforeDestroy,
+						Dependencies:        marshalDependencies(obj.Dependencies),
+						Attributes:          indexAttributes(db.protectAttributes(obj)),
+					})
 				}
+				sort.Slice(res.DeposedObjects, func(i, j int) bool {
+					return res.DeposedObjects[i].Key < res.DeposedObjects[j].Key
+				})
 				mod.Resources = append(mod.Resources, res)
+
+				if r.Addr.Resource.Mode == addrs.DataResourceMode && r.Addr.Resource.Type == "terraform_remote_state" {
+					if rs, ok := parseRemoteState(r.Addr.Instance(index).String(), i.Current); ok {
+						st.RemoteStates = append(st.RemoteStates, rs)
+					}
+				}
 			}
 		}
 
@@ -162,10 +220,13 @@ func (db *Database) stateS3toDB(sf *statefile.File, path string, versionID strin
 				log.WithError(err).Errorf("failed to load output for %s", r.Addr.String())
 			}
 			out := types.OutputValue{
-				Sensitive: r.Sensitive,
+				Sensitive: r.Sensitive || db.protector.MatchKey(n) || db.protector.MatchNestedKey(string(jsonVal)),
 				Name:      n,
 				Value:     string(jsonVal),
 			}
+			if out.Sensitive {
+				out.Value = db.protector.Protect(out.Value)
+			}
 
 			mod.OutputValues = append(mod.OutputValues, out)
 		}
@@ -175,6 +236,102 @@ func (db *Database) stateS3toDB(sf *statefile.File, path string, versionID strin
 	return
 }
 
+// getResourceMode returns the name of a resource mode,
+// as used in the Terraform JSON output
+func getResourceMode(mode addrs.ResourceMode) string {
+	if mode == addrs.DataResourceMode {
+		return types.ResourceModeData
+	}
+	return types.ResourceModeManaged
+}
+
+// getResourceStatus returns the name of the status of a resource instance object
+func getResourceStatus(status states.ObjectStatus) string {
+	switch status {
+	case states.ObjectTainted:
+		return types.ResourceStatusTainted
+	case states.ObjectPlanned:
+		return types.ResourceStatusPlanned
+	}
+	return types.ResourceStatusReady
+}
+
+// marshalDependencies returns the JSON list of the addresses
+// of the dependencies of a resource instance object
+func marshalDependencies(deps []addrs.ConfigResource) datatypes.JSON {
+	addresses := make([]string, 0, len(deps))
+	for _, dep := range deps {
+		addresses = append(addresses, dep.String())
+	}
+	sort.Strings(addresses)
+	j, _ := json.Marshal(addresses)
+	return j
+}
+
+// remoteStateConfigKeys are the backend settings kept from the config of
+// the terraform_remote_state data sources to locate their upstream States.
+// Credentials are left out.
+var remoteStateConfigKeys = []string{
+	"address", "bucket", "container_name", "hostname", "key", "organization", "path",
+	"prefix", "schema_name", "storage_account_name", "workspace_dir", "workspace_key_prefix", "workspaces",
+}
+
+// parseRemoteState returns the upstream State read by a
+// terraform_remote_state data source instance
+func parseRemoteState(address string, src *states.ResourceInstanceObjectSrc) (rs types.RemoteState, ok bool) {
+	if src == nil {
+		return
+	}
+	var attrs struct {
+		Backend   string          `json:"backend"`
+		Workspace *string         `json:"workspace"`
+		Config    json.RawMessage `json:"config"`
+	}
+	if err := json.Unmarshal(src.AttrsJSON, &attrs); err != nil || attrs.Backend == "" {
+		log.WithFields(log.Fields{
+			"address": address,
+			"error":   err,
+		}).Warn("Ignoring invalid terraform_remote_state")
+		return
+	}
+
+	// Dynamically typed attributes are stored along with their type
+	var config map[string]interface{}
+	var typed struct {
+		Value map[string]interface{} `json:"value"`
+		Type  json.RawMessage        `json:"type"`
+	}
+	if err := json.Unmarshal(attrs.Config, &typed); err == nil && typed.Type != nil {
+		config = typed.Value
+	} else if err := json.Unmarshal(attrs.Config, &config); err != nil {
+		config = nil
+	}
+
+	kept := make(map[string]interface{})
+	for _, k := range remoteStateConfigKeys {
+		if v, ok := config[k]; ok && v != nil {
+			kept[k] = v
+		}
+	}
+	if a, ok := kept["address"].(string); ok {
+		if u, err := url.Parse(a); err == nil && u.User != nil {
+			u.User = nil
+			kept["address"] = u.String()
+		}
+	}
+
+	rs = types.RemoteState{
+		Address:   address,
+		Backend:   attrs.Backend,
+		Workspace: "default",
+	}
+	if attrs.Workspace != nil && *attrs.Workspace != "" {
+		rs.Workspace = *attrs.Workspace
+	}
+	rs.Config, _ = json.Marshal(kept)
+	return rs, true
+}
+
 // getResourceIndex transforms an addrs.InstanceKey instance into a string representation
 func getResourceIndex(index addrs.InstanceKey) string {
 	switch index.(type) {
@@ -199,6 +356,42 @@ func isAttributeSensitive(attrKey string, sensitivePaths []cty.PathValueMarks) b
 	return false
 }
 
+// hasSensitivePath checks if any sensitive path is nested in an attribute
+func hasSensitivePath(attrKey string, sensitivePaths []cty.PathValueMarks) bool {
+	for _, pathMark := range sensitivePaths {
+		if len(pathMark.Path) == 0 {
+			continue
+		}
+		if step, ok := pathMark.Path[0].(cty.GetAttrStep); ok && step.Name == attrKey {
+			return true
+		}
+	}
+	return false
+}
+
+// protectAttributes returns the attributes of a resource instance object,
+// applying the ingestion policy of the sensitive values. Attributes whose
+// name, or the name of a nested key, matches a key pattern are sensitive
+// too and, unless values are stored in plaintext, so are attributes
+// holding nested sensitive values.
+func (db *Database) protectAttributes(src *states.ResourceInstanceObjectSrc) []types.Attribute {
+	attrs := marshalAttributeValues(src)
+	if db.protector == nil {
+		return attrs
+	}
+	for i := range attrs {
+		if !attrs[i].Sensitive {
+			attrs[i].Sensitive = db.protector.MatchKey(attrs[i].Key) ||
+				db.protector.MatchNestedKey(attrs[i].Value) ||
+				(db.protector.Mode() != sensitive.ModePlaintext && hasSensitivePath(attrs[i].Key, src.AttrSensitivePaths))
+		}
+		if attrs[i].Sensitive {
+			attrs[i].Value = db.protector.Protect(attrs[i].Value)
+		}
+	}
+	return attrs
+}
+
 // MarshalAttributeValues is a public wrapper for testing purposes
 func MarshalAttributeValues(src *states.ResourceInstanceObjectSrc) []types.Attribute {
 	return marshalAttributeValues(src)
@@ -239,6 +432,8 @@ func (db *Database) InsertState(path string, versionID string, sf *statefile.Fil
 		return err
 	}
 
+	findings := db.scanner.ScanState(st)
+
 	// Extract modules to insert them one by one to avoid parameter limit
 	modules := st.Modules
 	st.Modules = nil
@@ -273,7 +468,7 @@ func (db *Database) InsertState(path string, versionID string, sf *statefile.Fil
 
 		for _, r := range resources {
 			r.ModuleID = sql.NullInt64{Int64: int64(m.ID), Valid: true}
-			if err := tx.Create(&r).Error; err != nil {
+			if err := insertResource(tx, &r); err != nil {
 				tx.Rollback()
 				return err
 			}
@@ -288,9 +483,50 @@ func (db *Database) InsertState(path string, versionID string, sf *statefile.Fil
 		}
 	}
 
+	if err := insertFindings(tx, st.ID, findings); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	return tx.Commit().Error
 }
 
+// insertResource inserts a Resource with its attributes. The paths of
+// the attributes are extracted to insert them in batches, as a single
+// resource can have too many of them for the parameter limit.
+func insertResource(tx *gorm.DB, r *types.Resource) error {
+	var attrs []*types.Attribute
+	for i := range r.Attributes {
+		attrs = append(attrs, &r.Attributes[i])
+	}
+	for i := range r.DeposedObjects {
+		for j := range r.DeposedObjects[i].Attributes {
+			attrs = append(attrs, &r.DeposedObjects[i].Attributes[j])
+		}
+	}
+	paths := make([][]types.AttributePath, len(attrs))
+	for i, a := range attrs {
+		paths[i] = a.Paths
+		a.Paths = nil
+	}
+
+	if err := tx.Create(r).Error; err != nil {
+		return err
+	}
+
+	var all []types.AttributePath
+	for i, a := range attrs {
+		for _, p := range paths[i] {
+			p.AttributeID = sql.NullInt64{Int64: int64(a.ID), Valid: true}
+			all = append(all, p)
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(all, 500).Error
+}
+
 // UpdateState update a Terraform State in the Database with Lineage foreign constraint
 // It will also insert Lineage entry in the db if needed.
 // This method is only use during the Lineage migration since States are immutable
@@ -356,11 +592,83 @@ func (db *Database) GetState(lineage, versionID string) (state types.State) {
 	db.Joins("JOIN lineages on states.lineage_id=lineages.id").
 		Joins("JOIN versions on states.version_id=versions.id").
 		Preload("Version").Preload("Modules").Preload("Modules.Resources").Preload("Modules.Resources.Attributes").
+		Preload("Modules.Resources.DeposedObjects").Preload("Modules.Resources.DeposedObjects.Attributes").
 		Preload("Modules.OutputValues").
 		Find(&state, "lineages.value = ? AND versions.version_id = ?", lineage, versionID)
 	return
 }
 
+// GetStateResources retrieves a State from the database by its lineage
+// and versionID, with its resources but without their attributes
+func (db *Database) GetStateResources(lineage, versionID string) (state types.State) {
+	db.Joins("JOIN lineages on states.lineage_id=lineages.id").
+		Joins("JOIN versions on states.version_id=versions.id").
+		Preload("Version").Preload("Modules").Preload("Modules.Resources").
+		Find(&state, "lineages.value = ? AND versions.version_id = ?", lineage, versionID)
+	return
+}
+
+// ListStackNodes returns the path and lineage of all the States
+func (db *Database) ListStackNodes() (nodes []types.StackNode, err error) {
+	err = db.Raw("SELECT DISTINCT states.path, lineages.value as lineage_value" +
+		" FROM states JOIN lineages ON lineages.id = states.lineage_id" +
+		" ORDER BY states.path").Find(&nodes).Error
+	return
+}
+
+// ListStackReferences returns the terraform_remote_state data sources
+// of the latest version of all the States
+func (db *Database) ListStackReferences() (refs []types.StackReference, err error) {
+	err = db.Raw("SELECT states.path, lineages.value as lineage_value, remote_states.address," +
+		" remote_states.backend, remote_states.workspace, remote_states.config" +
+		" FROM (SELECT states.path, max(states.serial) as mx FROM states GROUP BY states.path) t" +
+		" JOIN states ON t.path = states.path AND t.mx = states.serial" +
+		" JOIN lineages ON lineages.id = states.lineage_id" +
+		" JOIN remote_states ON remote_states.state_id = states.id" +
+		" ORDER BY states.path, remote_states.address").Find(&refs).Error
+	return
+}
+
+// ListResourceIdentities returns the non-sensitive identity attributes
+// (keys) of the managed resources of the latest version of all the lineages
+func (db *Database) ListResourceIdentities(keys []string) (ids []types.ResourceIdentity, err error) {
+	err = db.Raw("SELECT states.path, versions.version_id, lineages.value as lineage_value,"+
+		" modules.path as module_path, resources.type, resources.name, resources.index, attributes.key, attributes.value"+
+		" FROM (SELECT states.lineage_id, max(states.serial) as mx FROM states GROUP BY states.lineage_id) t"+
+		" JOIN states ON t.lineage_id = states.lineage_id AND t.mx = states.serial"+
+		" JOIN lineages ON lineages.id = states.lineage_id"+
+		" JOIN versions ON versions.id = states.version_id"+
+		" JOIN modules ON modules.state_id = states.id"+
+		" JOIN resources ON resources.module_id = modules.id"+
+		" JOIN attributes ON attributes.resource_id = resources.id"+
+		" WHERE resources.mode = ? AND attributes.key IN ? AND NOT attributes.sensitive"+
+		" ORDER BY states.path, modules.path, resources.type, resources.name, resources.index, attributes.key",
+		types.ResourceModeManaged, keys).Find(&ids).Error
+	return
+}
+
+// GetResourceHistory retrieves all the States of a lineage, from the oldest
+// to the newest, only loading the resource instance at the given address
+// (e.g. module.vpc.aws_subnet.private[2])
+func (db *Database) GetResourceHistory(lineage, address string) (states []types.State, err error) {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(address)
+	if diags.HasErrors() {
+		return nil, diags.Err()
+	}
+	res := addr.Resource.Resource
+
+	err = db.Joins("JOIN lineages on states.lineage_id=lineages.id").
+		Joins("JOIN versions on states.version_id=versions.id").
+		Preload("Version").
+		Preload("Modules", "path = ?", addr.Module.String()).
+		Preload("Modules.Resources", `type = ? AND name = ? AND "index" = ? AND mode IN (?, '')`, // states synced before modes were stored have none
+			res.Type, res.Name, getResourceIndex(addr.Resource.Key), getResourceMode(res.Mode)).
+		Preload("Modules.Resources.Attributes").
+		Order("versions.last_modified ASC").
+		Find(&states, "lineages.value = ?", lineage).Error
+	return
+}
+
 // GetLineageActivity returns a slice of StateStat from the Database
 // for a given lineage representing the State activity over time (Versions)
 func (db *Database) GetLineageActivity(lineage string) (states []types.StateStat) {
@@ -375,6 +683,31 @@ func (db *Database) GetLineageActivity(lineage string) (states []types.StateStat
 	return
 }
 
+// ListPathHistory returns the versions of the States from the oldest to the
+// newest synced, the serial telling apart the versions synced at the same
+// time, only at the paths a lineage was written at when it is not empty
+func (db *Database) ListPathHistory(lineage string) (states []types.StateStat, err error) {
+	sql := "SELECT states.path, lineages.value as lineage_value, states.serial, states.tf_version, versions.version_id, versions.last_modified, lineages.archived_at" +
+		" FROM states JOIN lineages ON lineages.id = states.lineage_id JOIN versions ON versions.id = states.version_id"
+	var params []interface{}
+	if lineage != "" {
+		sql += " WHERE states.path IN (SELECT states.path FROM states JOIN lineages ON lineages.id = states.lineage_id WHERE lineages.value = ?)"
+		params = append(params, lineage)
+	}
+
+	err = db.Raw(sql+" ORDER BY versions.last_modified, states.serial, states.id", params...).Find(&states).Error
+	return
+}
+
+// GetPathLineage returns the lineage of the latest State at a path,
+// or an empty string when no State was found at this path
+func (db *Database) GetPathLineage(path string) (lineage string, err error) {
+	sql := "SELECT lineages.value FROM states JOIN lineages ON lineages.id = states.lineage_id JOIN versions ON versions.id = states.version_id" +
+		" WHERE states.path = ? ORDER BY versions.last_modified DESC, states.id DESC LIMIT 1"
+	err = db.Raw(sql, path).Scan(&lineage).Error
+	return
+}
+
 // KnownVersions returns a slice of all known Versions in the Database
 func (db *Database) KnownVersions() (versions []string) {
 	// TODO: err
@@ -390,8 +723,20 @@ func (db *Database) KnownVersions() (versions []string) {
 	return
 }
 
+// ValidateSearchAttribute checks the JSON path search parameters of a query
+func ValidateSearchAttribute(query url.Values) error {
+	if query.Get("path") == "" && query.Get("op") == "" {
+		return nil
+	}
+	_, _, err := pathQuery(query.Get("path"), query.Get("op"), query.Get("value"))
+	return err
+}
+
 // SearchAttribute returns a slice of SearchResult given a query
-// The query might contain parameters 'type', 'name', 'key', 'value' and 'tf_version'
+// The query might contain parameters 'type', 'name', 'key', 'value' and 'tf_version'.
+// With a JSON 'path' (e.g. ingress[*].cidr_blocks) or an 'op' parameter,
+// the leaves of the attributes are searched, comparing their values
+// with the operator (eq by default)
 // SearchAttribute also returns paging information: the page number and the total results
 func (db *Database) SearchAttribute(query url.Values) (results []types.SearchResult, page int, total int) {
 	log.WithFields(log.Fields{
@@ -400,19 +745,19 @@ func (db *Database) SearchAttribute(query url.Values) (results []types.SearchRes
 
 	targetVersion := string(query.Get("versionid"))
 
-	sqlQuery := ""
-	if targetVersion == "" {
-		sqlQuery += " FROM (SELECT states.path, max(states.serial) as mx FROM states GROUP BY states.path) t" +
-			" JOIN states ON t.path = states.path AND t.mx = states.serial"
-	} else {
-		sqlQuery += " FROM states"
+	// Resources with only deposed objects have no attributes
+	attributesJoin := " JOIN attributes"
+	if query.Get("deposed") == "true" {
+		attributesJoin = " LEFT JOIN attributes"
 	}
 
-	sqlQuery += " JOIN modules ON states.id = modules.state_id" +
-		" JOIN resources ON modules.id = resources.module_id" +
-		" JOIN attributes ON resources.id = attributes.resource_id" +
-		" JOIN lineages ON lineages.id = states.lineage_id" +
-		" JOIN versions ON states.version_id = versions.id"
+	sqlQuery := searchFrom(targetVersion, attributesJoin)
+
+	// Search the leaves of the attributes by JSON path
+	pathMode := query.Get("path") != "" || query.Get("op") != ""
+	if pathMode {
+		sqlQuery += " JOIN attribute_paths ON attributes.id = attribute_paths.attribute_id"
+	}
 
 	var where []string
 	var params []interface{}
@@ -437,11 +782,38 @@ func (db *Database) SearchAttribute(query url.Values) (results []types.SearchRes
 		params = append(params, fmt.Sprintf("%%%s%%", v))
 	}
 
-	if v := string(query.Get("value")); v != "" {
+	if pathMode {
+		pathWhere, pathParams, err := pathQuery(query.Get("path"), query.Get("op"), query.Get("value"))
+		if err != nil {
+			log.WithError(err).Error("Invalid attribute path search")
+			return nil, 1, 0
+		}
+		where = append(where, pathWhere...)
+		params = append(params, pathParams...)
+	} else if v := string(query.Get("value")); v != "" {
 		where = append(where, "attributes.value LIKE ?")
 		params = append(params, fmt.Sprintf("%%%s%%", v))
 	}
 
+	if v := query.Get("mode"); v != "" {
+		where = append(where, "resources.mode = ?")
+		params = append(params, v)
+	}
+
+	if v := query.Get("provider"); v != "" {
+		where = append(where, "resources.provider LIKE ?")
+		params = append(params, fmt.Sprintf("%%%s%%", v))
+	}
+
+	if v := query.Get("status"); v != "" {
+		where = append(where, "resources.status = ?")
+		params = append(params, v)
+	}
+
+	if query.Get("deposed") == "true" {
+		where = append(where, "EXISTS (SELECT 1 FROM deposed_objects WHERE deposed_objects.resource_id = resources.id)")
+	}
+
 	if v := query.Get("tf_version"); string(v) != "" {
 		where = append(where, "states.tf_version LIKE ?")
 		params = append(params, fmt.Sprintf("%%%s%%", v))
@@ -452,6 +824,39 @@ func (db *Database) SearchAttribute(query url.Values) (results []types.SearchRes
 		params = append(params, fmt.Sprintf("%%%s%%", v))
 	}
 
+	if !includeArchived(query) {
+		where = append(where, "lineages.archived_at IS NULL")
+	}
+
+	var extra, order string
+	if pathMode {
+		extra = ", attribute_paths.path as attribute_path, attribute_paths.value as path_value"
+		order = ", attribute_paths.path"
+	}
+	return db.searchResults(sqlQuery, where, params, extra, order, query.Get("page"))
+}
+
+// Return the FROM clause of the searches, on the latest version
+// of the states, all versions (*) or the states of a version
+func searchFrom(targetVersion, attributesJoin string) (sqlQuery string) {
+	if targetVersion == "" {
+		sqlQuery += " FROM (SELECT states.path, max(states.serial) as mx FROM states GROUP BY states.path) t" +
+			" JOIN states ON t.path = states.path AND t.mx = states.serial"
+	} else {
+		sqlQuery += " FROM states"
+	}
+
+	sqlQuery += " JOIN modules ON states.id = modules.state_id" +
+		" JOIN resources ON modules.id = resources.module_id" +
+		attributesJoin + " ON resources.id = attributes.resource_id" +
+		" JOIN lineages ON lineages.id = states.lineage_id" +
+		" JOIN versions ON states.version_id = versions.id"
+	return
+}
+
+// Count and return a page of the results of a search,
+// with extra selected columns and order
+func (db *Database) searchResults(sqlQuery string, where []string, params []interface{}, extra, extraOrder, pageStr string) (results []types.SearchResult, page int, total int) {
 	if len(where) > 0 {
 		sqlQuery += " WHERE " + strings.Join(where, " AND ")
 	}
@@ -464,15 +869,16 @@ func (db *Database) SearchAttribute(query url.Values) (results []types.SearchRes
 
 	// Now get results
 	// gorm doesn't support subqueries...
-	sql := "SELECT states.path, versions.version_id, states.tf_version, states.serial, lineages.value as lineage_value, modules.path as module_path, resources.type, resources.name, resources.index, attributes.key, attributes.value, attributes.sensitive" +
-		sqlQuery +
+	sql := "SELECT states.path, versions.version_id, states.tf_version, states.serial, lineages.value as lineage_value, modules.path as module_path, resources.mode, resources.type, resources.name, resources.index, resources.provider, resources.status," +
+		" (SELECT count(*) FROM deposed_objects WHERE deposed_objects.resource_id = resources.id) as deposed_count, attributes.key, attributes.value, attributes.sensitive" +
+		extra + sqlQuery +
 		" ORDER BY states.path, states.serial, lineage_value, modules.path, resources.type, resources.name, resources.index, attributes.key" +
-		" LIMIT ?"
+		extraOrder + " LIMIT ?"
 
 	params = append(params, pageSize)
 
-	if v := string(query.Get("page")); v != "" {
-		page, _ = strconv.Atoi(v) // TODO: err
+	if pageStr != "" {
+		page, _ = strconv.Atoi(pageStr) // TODO: err
 		o := (page - 1) * pageSize
 		sql += " OFFSET ?"
 		params = append(params, o)
@@ -485,6 +891,26 @@ func (db *Database) SearchAttribute(query url.Values) (results []types.SearchRes
 	return
 }
 
+// Search returns the resource attributes of the states matching a
+// structured query, on the latest version of the states by default
+func (db *Database) Search(q search.Query, targetVersion, pageStr string, withArchived bool) (results []types.SearchResult, page int, total int) {
+	log.WithFields(log.Fields{
+		"query":   q.Where,
+		"version": targetVersion,
+	}).Info("Searching with structured query")
+
+	where := []string{q.Where}
+	params := append([]interface{}{}, q.Params...)
+	if targetVersion != "" && targetVersion != "*" {
+		where = append([]string{"states.version_id = ?"}, where...)
+		params = append([]interface{}{targetVersion}, params...)
+	}
+	if !withArchived {
+		where = append(where, "lineages.archived_at IS NULL")
+	}
+	return db.searchResults(searchFrom(targetVersion, " JOIN attributes"), where, params, "", "", pageStr)
+}
+
 // ListStatesVersions returns a map of Version IDs to a slice of State paths
 // from the Database
 func (db *Database) ListStatesVersions() (statesVersions map[string][]string) {
@@ -510,9 +936,14 @@ func (db *Database) ListStatesVersions() (statesVersions map[string][]string) {
 // to sort results. Default sorting is by descending version number.
 func (db *Database) ListTerraformVersionsWithCount(query url.Values) (results []map[string]string, err error) {
 	orderBy := string(query.Get("orderBy"))
+	var archived string
+	if !includeArchived(query) {
+		archived = " WHERE lineages.archived_at IS NULL"
+	}
 	sql := "SELECT t.tf_version, COUNT(*)" +
 		" FROM (SELECT DISTINCT ON(states.path) states.id, states.path, states.serial, states.tf_version, versions.version_id, versions.last_modified" +
-		" FROM states JOIN versions ON versions.id = states.version_id ORDER BY states.path, versions.last_modified DESC) t" +
+		" FROM states JOIN versions ON versions.id = states.version_id JOIN lineages ON lineages.id = states.lineage_id" +
+		archived + " ORDER BY states.path, versions.last_modified DESC) t" +
 		" GROUP BY t.tf_version ORDER BY "
 
 	if orderBy == "version" {
@@ -543,7 +974,12 @@ func (db *Database) ListTerraformVersionsWithCount(query url.Values) (results []
 
 // ListStateStats returns a slice of StateStat, along with paging information
 func (db *Database) ListStateStats(query url.Values) (states []types.StateStat, page int, total int) {
-	row := db.Raw("SELECT count(*) FROM (SELECT DISTINCT lineage_id FROM states) AS t").Row()
+	var archived string
+	if !includeArchived(query) {
+		archived = " WHERE lineages.archived_at IS NULL"
+	}
+
+	row := db.Raw("SELECT count(*) FROM (SELECT DISTINCT lineage_id FROM states JOIN lineages ON lineages.id = states.lineage_id" + archived + ") AS t").Row()
 	if err := row.Scan(&total); err != nil {
 		log.Error(err.Error())
 	}
@@ -559,12 +995,13 @@ func (db *Database) ListStateStats(query url.Values) (states []types.StateStat,
 		page = -1
 	}
 
-	sql := "SELECT t.path, lineages.value as lineage_value, t.serial, t.tf_version, t.version_id, t.last_modified, count(resources.*) as resource_count" +
+	sql := "SELECT t.path, lineages.value as lineage_value, t.serial, t.tf_version, t.version_id, t.last_modified, lineages.archived_at, count(resources.*) as resource_count" +
 		" FROM (SELECT DISTINCT ON(states.lineage_id) states.id, states.lineage_id, states.path, states.serial, states.tf_version, versions.version_id, versions.last_modified FROM states JOIN versions ON versions.id = states.version_id ORDER BY states.lineage_id, versions.last_modified DESC) t" +
 		" JOIN modules ON modules.state_id = t.id" +
 		" JOIN resources ON resources.module_id = modules.id" +
 		" JOIN lineages ON lineages.id = t.lineage_id" +
-		" GROUP BY t.path, lineages.value, t.serial, t.tf_version, t.version_id, t.last_modified" +
+		archived +
+		" GROUP BY t.path, lineages.value, t.serial, t.tf_version, t.version_id, t.last_modified, lineages.archived_at" +
 		" ORDER BY last_modified DESC" +
 		paginationQuery
 
@@ -572,6 +1009,25 @@ func (db *Database) ListStateStats(query url.Values) (states []types.StateStat,
 	return
 }
 
+// ListLineageStats returns the latest version of all the lineages, along
+// with their number of managed resources, including the empty lineages
+func (db *Database) ListLineageStats() (states []types.StateStat, err error) {
+	err = db.Raw("SELECT t.path, lineages.value as lineage_value, t.serial, t.tf_version, t.version_id, t.last_modified, count(resources.id) as resource_count"+
+		" FROM (SELECT DISTINCT ON(states.lineage_id) states.id, states.lineage_id, states.path, states.serial, states.tf_version, versions.version_id, versions.last_modified FROM states JOIN versions ON versions.id = states.version_id ORDER BY states.lineage_id, versions.last_modified DESC) t"+
+		" JOIN lineages ON lineages.id = t.lineage_id"+
+		" LEFT JOIN modules ON modules.state_id = t.id"+
+		" LEFT JOIN resources ON resources.module_id = modules.id AND resources.mode = ?"+
+		" GROUP BY t.path, lineages.value, t.serial, t.tf_version, t.version_id, t.last_modified"+
+		" ORDER BY last_modified",
+		types.ResourceModeManaged).Find(&states).Error
+	return
+}
+
+// includeArchived checks whether the archived lineages are requested
+func includeArchived(query url.Values) bool {
+	return query.Get("include_archived") == "true"
+}
+
 // listField is a wrapper utility method to list distinct values in Database tables.
 func (db *Database) listField(table, field string) (results []string, err error) {
 	rows, err := db.Table(table).Select(fmt.Sprintf("DISTINCT %s", field)).Rows()
@@ -667,6 +1123,30 @@ func (db *Database) ListAttributeKeys(resourceType string) (results []string, er
 	return
 }
 
+// ListAttributePaths returns the keys of the leaves of the attributes,
+// with their list indices replaced by [*], optionally filtered by resource
+// type and restricted to the leaves at or under a JSON path
+func (db *Database) ListAttributePaths(resourceType, path string) (results []string, err error) {
+	query := db.Table("attribute_paths").
+		Select("DISTINCT attribute_paths.key").
+		Joins("JOIN attributes ON attribute_paths.attribute_id = attributes.id").
+		Joins("JOIN resources ON attributes.resource_id = resources.id")
+
+	if resourceType != "" {
+		query = query.Where("resources.type = ?", resourceType)
+	}
+	if path != "" {
+		re, err := search.PathRegexp(path)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("attribute_paths.path ~ ?", re)
+	}
+
+	err = query.Order("attribute_paths.key").Scan(&results).Error
+	return
+}
+
 // InsertPlan inserts a Terraform plan with associated information in the Database
 func (db *Database) InsertPlan(plan []byte) error {
 	var lineage types.Lineage
@@ -689,7 +1169,11 @@ func (db *Database) InsertPlan(plan []byte) error {
 	}
 
 	p.LineageID = lineage.ID
-	return db.Create(&p).Error
+	if err := db.Create(&p).Error; err != nil {
+		return err
+	}
+	db.notifier.PlanSubmitted(lineage.Value, p)
+	return nil
 }
 
 // GetPlansSummary retrieves a summary of all Plans of a lineage from the database
@@ -769,6 +1253,29 @@ func (db *Database) GetPlan(id string) (plans types.Plan) {
 	return
 }
 
+// GetPlanAppliedState retrieves the first State version of the lineage
+// of a Plan whose serial is above the serial of the state the plan was
+// computed against, if any. Without this prior serial, the first version
+// last modified after the plan submission is used.
+func (db *Database) GetPlanAppliedState(plan types.Plan) (*types.State, error) {
+	q := db.Joins("JOIN versions on states.version_id=versions.id").
+		Preload("Version").Preload("Modules").Preload("Modules.Resources").Preload("Modules.Resources.Attributes")
+	if plan.PriorSerial != nil {
+		q = q.Where("states.lineage_id = ? AND states.serial > ?", plan.LineageID, *plan.PriorSerial).
+			Order("states.serial ASC, versions.last_modified ASC")
+	} else {
+		q = q.Where("states.lineage_id = ? AND versions.last_modified >= ?", plan.LineageID, plan.CreatedAt).
+			Order("versions.last_modified ASC")
+	}
+
+	var states []types.State
+	err := q.Limit(1).Find(&states).Error
+	if err != nil || len(states) == 0 {
+		return nil, err
+	}
+	return &states[0], nil
+}
+
 // GetPlans retrieves all Plan of a lineage from the database
 func (db *Database) GetPlans(lineage, limitStr, pageStr string) (plans []types.Plan, page int, total int) {
 	var whereClause []interface{}
@@ -836,8 +1343,9 @@ func (db *Database) GetPlans(lineage, limitStr, pageStr string) (plans []types.P
 	return
 }
 
-// GetLineages retrieves all Lineage from the database
-func (db *Database) GetLineages(limitStr string) (lineages []types.Lineage) {
+// GetLineages retrieves all Lineage from the database,
+// the archived ones only when requested
+func (db *Database) GetLineages(limitStr string, withArchived bool) (lineages []types.Lineage) {
 	var limit int
 	if limitStr == "" {
 		limit = -1
@@ -850,9 +1358,11 @@ func (db *Database) GetLineages(limitStr string) (lineages []types.Lineage) {
 		}
 	}
 
-	db.Order("created_at desc").
-		Limit(limit).
-		Find(&lineages)
+	tx := db.Order("created_at desc")
+	if !withArchived {
+		tx = tx.Where("archived_at IS NULL")
+	}
+	tx.Limit(limit).Find(&lineages)
 	return
 }
 
@@ -872,6 +1382,17 @@ func (db *Database) DefaultVersion(lineage string) (version string, err error) {
 	return
 }
 
+// SetNotifier sets the Notifier used to report failed plans
+func (db *Database) SetNotifier(n *notify.Notifier) {
+	db.notifier = n
+}
+
+// SetProtector sets the Protector applying the ingestion
+// policy of the sensitive values
+func (db *Database) SetProtector(p *sensitive.Protector) {
+	db.protector = p
+}
+
 // Close get generic database interface *sql.DB from the current *gorm.DB
 // and close it
 func (db *Database) Close() {
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/camptocamp/terraboard/scan"
+	"github.com/camptocamp/terraboard/types"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrScanDisabled is returned when scanning States while the scanner is disabled
+var ErrScanDisabled = errors.New("secret scanning is disabled")
+
+// SetScanner sets the Scanner looking for leaked secrets
+// in the States when they are inserted
+func (db *Database) SetScanner(s *scan.Scanner) {
+	db.scanner = s
+}
+
+// insertFindings stores the Findings of a State
+func insertFindings(tx *gorm.DB, stateID uint, findings []types.Finding) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	for i := range findings {
+		findings[i].StateID = sql.NullInt64{Int64: int64(stateID), Valid: true}
+	}
+	return tx.CreateInBatches(findings, 100).Error
+}
+
+// ScanStates scans again all the versions of the States of a lineage,
+// or a single version, replacing their Findings with the current rules.
+// It returns the new Findings and the number of scanned States.
+func (db *Database) ScanStates(lineage, versionID string) (findings []types.Finding, scanned int, err error) {
+	if db.scanner == nil {
+		return nil, 0, ErrScanDisabled
+	}
+
+	tx := db.Joins("JOIN lineages on states.lineage_id=lineages.id").
+		Joins("JOIN versions on states.version_id=versions.id").
+		Preload("Version").Preload("Modules").Preload("Modules.Resources").Preload("Modules.Resources.Attributes").
+		Preload("Modules.Resources.DeposedObjects").Preload("Modules.Resources.DeposedObjects.Attributes").
+		Preload("Modules.OutputValues").
+		Where("lineages.value = ?", lineage)
+	if versionID != "" {
+		tx = tx.Where("versions.version_id = ?", versionID)
+	}
+	var states []types.State
+	if err = tx.Find(&states).Error; err != nil {
+		return
+	}
+
+	findings = []types.Finding{}
+	for _, st := range states {
+		stateFindings := db.scanner.ScanState(st)
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("state_id = ?", st.ID).Delete(&types.Finding{}).Error; err != nil {
+				return err
+			}
+			return insertFindings(tx, st.ID, stateFindings)
+		})
+		if err != nil {
+			return
+		}
+		for i := range stateFindings {
+			stateFindings[i].Path = st.Path
+			stateFindings[i].VersionID = st.Version.VersionID
+			stateFindings[i].LineageValue = lineage
+		}
+		findings = append(findings, stateFindings...)
+	}
+	scanned = len(states)
+	return
+}
+
+// ListFindings retrieves the Findings of the latest version of the States,
+// of a given version, or of all versions ("*"), most severe first,
+// optionally filtered by lineage and severity.
+// It also returns paging information: the page number and the total results
+func (db *Database) ListFindings(lineage, severity, versionID, limitStr, pageStr string) (findings []types.Finding, page int, total int) {
+	tx := db.Model(&types.Finding{}).
+		Joins("JOIN states ON states.id = findings.state_id").
+		Joins("JOIN lineages ON lineages.id = states.lineage_id").
+		Joins("JOIN versions ON versions.id = states.version_id")
+	switch versionID {
+	case "":
+		tx = tx.Where("states.serial = (SELECT max(s.serial) FROM states s WHERE s.path = states.path)")
+	case "*":
+	default:
+		tx = tx.Where("versions.version_id = ?", versionID)
+	}
+	if lineage != "" {
+		tx = tx.Where("lineages.value = ?", lineage)
+	}
+	if severity != "" {
+		tx = tx.Where("findings.severity = ?", severity)
+	}
+
+	var count int64
+	if err := tx.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		log.Error(err.Error())
+	}
+	total = int(count)
+
+	limit := pageSize
+	if limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			log.Warnf("ListFindings limit ignored: %v", err)
+			limit = pageSize
+		}
+	}
+
+	page = 1
+	if pageStr != "" {
+		var err error
+		page, err = strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			log.Warnf("ListFindings page ignored: %v", err)
+			page = 1
+		}
+	}
+
+	tx.Select("findings.*, states.path, versions.version_id, lineages.value as lineage_value").
+		Order("CASE findings.severity WHEN 'critical' THEN 0 WHEN 'high' THEN 1 WHEN 'medium' THEN 2 ELSE 3 END").
+		Order("states.path, findings.address, findings.attribute_path, findings.id").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&findings)
+
+	return
+}
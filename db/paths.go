@@ -0,0 +1,171 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/camptocamp/terraboard/search"
+	"github.com/camptocamp/terraboard/types"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+// Comparison operators on the values of the attribute paths
+const (
+	PathOpEqual      = "eq"
+	PathOpNotEqual   = "ne"
+	PathOpContains   = "contains"
+	PathOpPrefix     = "prefix"
+	PathOpRegex      = "regex"
+	PathOpLess       = "lt"
+	PathOpLessEqual  = "le"
+	PathOpGreater    = "gt"
+	PathOpGreatEqual = "ge"
+)
+
+// numericOps maps the numeric operators to their SQL operator
+var numericOps = map[string]string{
+	PathOpLess:       "<",
+	PathOpLessEqual:  "<=",
+	PathOpGreater:    ">",
+	PathOpGreatEqual: ">=",
+}
+
+// invalidRegexCode is the SQLSTATE of PostgreSQL's invalid_regular_expression
+const invalidRegexCode = "2201B"
+
+// ValidateRegex checks a regex against PostgreSQL, as the values are
+// matched with its POSIX regexes (~), whose syntax differs from Go's.
+// Other errors of the database are left to the search itself.
+func (db *Database) ValidateRegex(re string) error {
+	var matched bool
+	err := db.Raw("SELECT '' ~ ?", re).Row().Scan(&matched)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == invalidRegexCode {
+		return fmt.Errorf("invalid regex %q: %s", re, pgErr.Message)
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to validate regex")
+	}
+	return nil
+}
+
+// flattenValue calls f on the leaves of a JSON value, with their path
+// and their key, i.e. their path with the list indices replaced by [*].
+// Empty maps and lists have no leaves.
+func flattenValue(path, key string, v interface{}, f func(path, key string, v interface{})) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flattenValue(search.KeyPath(path, k), search.KeyPath(key, k), val[k], f)
+		}
+	case []interface{}:
+		for i, e := range val {
+			flattenValue(fmt.Sprintf("%s[%d]", path, i), key+"[*]", e, f)
+		}
+	default:
+		f(path, key, val)
+	}
+}
+
+// attributePaths returns the leaves of the value of an Attribute
+func attributePaths(attr types.Attribute) (paths []types.AttributePath) {
+	d := json.NewDecoder(bytes.NewReader([]byte(attr.Value)))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil
+	}
+
+	flattenValue(attr.Key, attr.Key, v, func(path, key string, leaf interface{}) {
+		if leaf == nil {
+			return
+		}
+		p := types.AttributePath{Path: path, Key: key}
+		switch l := leaf.(type) {
+		case string:
+			p.Value = l
+		case json.Number:
+			p.Value = l.String()
+		default:
+			j, _ := json.Marshal(l)
+			p.Value = string(j)
+		}
+		if _, ok := leaf.(bool); !ok {
+			if n, err := strconv.ParseFloat(p.Value, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+				p.Number = sql.NullFloat64{Float64: n, Valid: true}
+			}
+		}
+		paths = append(paths, p)
+	})
+	return
+}
+
+// indexAttributes indexes the paths of the non-sensitive attributes
+func indexAttributes(attrs []types.Attribute) []types.Attribute {
+	for i := range attrs {
+		if !attrs[i].Sensitive {
+			attrs[i].Paths = attributePaths(attrs[i])
+		}
+	}
+	return attrs
+}
+
+// pathCondition returns the SQL condition, and its parameters,
+// comparing the values of the attribute paths with an operator
+func pathCondition(op, value string) (string, []interface{}, error) {
+	switch op {
+	case "", PathOpEqual:
+		return "attribute_paths.value = ?", []interface{}{value}, nil
+	case PathOpNotEqual:
+		return "attribute_paths.value <> ?", []interface{}{value}, nil
+	case PathOpContains:
+		return "attribute_paths.value LIKE ?", []interface{}{"%" + search.EscapeLike(value) + "%"}, nil
+	case PathOpPrefix:
+		return "attribute_paths.value LIKE ?", []interface{}{search.EscapeLike(value) + "%"}, nil
+	case PathOpRegex:
+		// Checked by ValidateRegex
+		return "attribute_paths.value ~ ?", []interface{}{value}, nil
+	}
+	if sqlOp, ok := numericOps[op]; ok {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid number %q for operator %s", value, op)
+		}
+		return "attribute_paths.number " + sqlOp + " ?", []interface{}{n}, nil
+	}
+	return "", nil, fmt.Errorf("unknown operator %q", op)
+}
+
+// pathQuery returns the SQL conditions, and their parameters, of the
+// path, op and value parameters of an attribute search
+func pathQuery(path, op, value string) (where []string, params []interface{}, err error) {
+	if path != "" {
+		re, err := search.PathRegexp(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		where = append(where, "attribute_paths.path ~ ?")
+		params = append(params, re)
+	}
+	if value != "" || op != "" {
+		cond, p, err := pathCondition(op, value)
+		if err != nil {
+			return nil, nil, err
+		}
+		where = append(where, cond)
+		params = append(params, p...)
+	}
+	return
+}
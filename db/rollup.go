@@ -0,0 +1,153 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/camptocamp/terraboard/types"
+	"gorm.io/gorm"
+)
+
+// liveStates selects the latest version of the States whose lineage is not archived
+const liveStates = "SELECT t.* FROM (SELECT DISTINCT ON(states.path) states.id, states.path, states.tf_version, lineages.value AS lineage_value, lineages.archived_at" +
+	" FROM states JOIN versions ON versions.id = states.version_id JOIN lineages ON lineages.id = states.lineage_id" +
+	" ORDER BY states.path, versions.last_modified DESC) t WHERE t.archived_at IS NULL"
+
+// rollupDimension is a dimension of a metric of the daily rollups,
+// keyed by an SQL expression over the live States
+type rollupDimension struct {
+	metric string
+	name   string
+	key    string
+}
+
+// rollupDimensions are the dimensions of the daily rollups. The first
+// dimension of a metric is summed up into its totals.
+var rollupDimensions = []rollupDimension{
+	{types.StatMetricResources, "type", "resources.type"},
+	{types.StatMetricResources, "lineage", "t.lineage_value"},
+	{types.StatMetricResources, "module", "modules.path"},
+	// provider["registry.terraform.io/hashicorp/aws"].east is counted as registry.terraform.io/hashicorp/aws
+	{types.StatMetricResources, "provider", `COALESCE(substring(resources.provider from 'provider\["([^"]+)"\]'), resources.provider)`},
+	{types.StatMetricStates, "terraform_version", "t.tf_version"},
+}
+
+// Return the query counting the metric by the key of the dimension
+func (d rollupDimension) query() (string, []interface{}) {
+	if d.metric == types.StatMetricStates {
+		return "SELECT " + d.key + " AS key, COUNT(*) AS count FROM (" + liveStates + ") t GROUP BY 1", nil
+	}
+	// States synced before modes were stored have none
+	return "SELECT " + d.key + " AS key, COUNT(*) AS count FROM (" + liveStates + ") t" +
+		" JOIN modules ON modules.state_id = t.id" +
+		" JOIN resources ON resources.module_id = modules.id" +
+		" WHERE resources.mode IN (?, '') GROUP BY 1", []interface{}{types.ResourceModeManaged}
+}
+
+// Return the dimension of a metric, its first one when groupBy is empty
+func findRollupDimension(metric, groupBy string) (rollupDimension, error) {
+	var names []string
+	for _, d := range rollupDimensions {
+		if d.metric != metric {
+			continue
+		}
+		if groupBy == "" || d.name == groupBy {
+			return d, nil
+		}
+		names = append(names, d.name)
+	}
+	if names == nil {
+		return rollupDimension{}, fmt.Errorf("unknown metric %q", metric)
+	}
+	return rollupDimension{}, fmt.Errorf("metric %q cannot be grouped by %q, only by %s", metric, groupBy, strings.Join(names, ", "))
+}
+
+// ValidateTimeSeries checks the metric and dimension of a time series query
+func ValidateTimeSeries(metric, groupBy string) error {
+	_, err := findRollupDimension(metric, groupBy)
+	return err
+}
+
+// UpdateStatRollups snapshots the counts of all the dimensions of the
+// rollups for the day of now, replacing the previous snapshot of the day
+func (db *Database) UpdateStatRollups(now time.Time) error {
+	day := now.UTC().Truncate(24 * time.Hour)
+	var rollups []types.StatRollup
+	for _, d := range rollupDimensions {
+		var counts []struct {
+			Key   string
+			Count int64
+		}
+		sql, params := d.query()
+		if err := db.Raw(sql, params...).Scan(&counts).Error; err != nil {
+			return err
+		}
+		for _, c := range counts {
+			rollups = append(rollups, types.StatRollup{
+				Day:       day,
+				Metric:    d.metric,
+				Dimension: d.name,
+				Key:       c.Key,
+				Count:     c.Count,
+			})
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("day = ?", day).Delete(&types.StatRollup{}).Error; err != nil {
+			return err
+		}
+		if len(rollups) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&rollups, 500).Error
+	})
+}
+
+// ListTimeSeries returns the daily series of a metric between two days,
+// one per key of the dimension it is grouped by, or its totals when
+// groupBy is empty. The days without a snapshot are left out.
+func (db *Database) ListTimeSeries(metric, groupBy string, from, to time.Time) ([]types.TimeSeries, error) {
+	d, err := findRollupDimension(metric, groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	var rollups []types.StatRollup
+	if err := db.Where("metric = ? AND dimension = ? AND day BETWEEN ? AND ?", d.metric, d.name, from, to).
+		Order("day, key").
+		Find(&rollups).Error; err != nil {
+		return nil, err
+	}
+
+	var days, keys []string
+	counts := make(map[string]map[string]int64)
+	for _, r := range rollups {
+		day := r.Day.Format(types.DayFormat)
+		if len(days) == 0 || days[len(days)-1] != day {
+			days = append(days, day)
+		}
+		key := r.Key
+		if groupBy == "" {
+			key = ""
+		}
+		if _, ok := counts[key]; !ok {
+			counts[key] = make(map[string]int64)
+			keys = append(keys, key)
+		}
+		counts[key][day] += r.Count
+	}
+	sort.Strings(keys)
+
+	series := []types.TimeSeries{}
+	for _, key := range keys {
+		s := types.TimeSeries{Key: key, Points: []types.TimeSeriesPoint{}}
+		for _, day := range days {
+			s.Points = append(s.Points, types.TimeSeriesPoint{Day: day, Count: counts[key][day]})
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
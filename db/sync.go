@@ -0,0 +1,58 @@
+package db
+
+import (
+	"strconv"
+
+	"github.com/camptocamp/terraboard/types"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// InsertSyncRun records a sync run along with its errors in the database
+func (db *Database) InsertSyncRun(run *types.SyncRun) error {
+	return db.Create(run).Error
+}
+
+// ListSyncRuns retrieves the sync runs from the database, most recent first,
+// optionally filtered by provider.
+// It also returns paging information: the page number and the total results
+func (db *Database) ListSyncRuns(provider, limitStr, pageStr string) (runs []types.SyncRun, page int, total int) {
+	tx := db.Model(&types.SyncRun{})
+	if provider != "" {
+		tx = tx.Where("provider = ?", provider)
+	}
+
+	var count int64
+	if err := tx.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		log.Error(err.Error())
+	}
+	total = int(count)
+
+	limit := pageSize
+	if limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			log.Warnf("ListSyncRuns limit ignored: %v", err)
+			limit = pageSize
+		}
+	}
+
+	page = 1
+	if pageStr != "" {
+		var err error
+		page, err = strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			log.Warnf("ListSyncRuns page ignored: %v", err)
+			page = 1
+		}
+	}
+
+	tx.Preload("Errors").
+		Order("started_at desc").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&runs)
+
+	return
+}
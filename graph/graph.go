@@ -0,0 +1,204 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/camptocamp/terraboard/internal/terraform/addrs"
+	"github.com/camptocamp/terraboard/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Return the instance address of a resource in a module, e.g.
+// module.vpc["prod"].aws_subnet.private[0], and the address of its
+// resource in the configuration, e.g. module.vpc.aws_subnet.private,
+// as used in the dependencies
+func resourceAddresses(modulePath string, r types.Resource) (instance, config string) {
+	instance = r.Address(modulePath)
+	abs, diags := addrs.ParseAbsResourceInstanceStr(instance)
+	if diags.HasErrors() {
+		return instance, instance
+	}
+	return instance, abs.ContainingResource().Config().String()
+}
+
+// Build returns the dependency graph of the resource instances of a State.
+// The instances depend on all the instances of their dependencies, and
+// dependencies missing from the State are ignored.
+func Build(state types.State) (g types.Graph) {
+	g.Nodes = []types.GraphNode{}
+	g.Edges = []types.GraphEdge{}
+
+	instances := make(map[string][]string)
+	dependencies := make(map[string][]string)
+	for _, m := range state.Modules {
+		for _, r := range m.Resources {
+			address, config := resourceAddresses(m.Path, r)
+			g.Nodes = append(g.Nodes, types.GraphNode{
+				Address:  address,
+				Module:   m.Path,
+				Mode:     r.Mode,
+				Type:     r.Type,
+				Name:     r.Name,
+				Index:    r.Index,
+				Provider: r.Provider,
+				Status:   r.Status,
+			})
+			instances[config] = append(instances[config], address)
+
+			if len(r.Dependencies) == 0 {
+				continue
+			}
+			var deps []string
+			if err := json.Unmarshal(r.Dependencies, &deps); err != nil {
+				log.WithFields(log.Fields{
+					"address": address,
+					"error":   err,
+				}).Warn("Ignoring invalid resource dependencies")
+				continue
+			}
+			dependencies[address] = deps
+		}
+	}
+
+	for from, deps := range dependencies {
+		seen := make(map[string]bool)
+		for _, dep := range deps {
+			for _, to := range instances[dep] {
+				if to == from || seen[to] {
+					continue
+				}
+				seen[to] = true
+				g.Edges = append(g.Edges, types.GraphEdge{From: from, To: to})
+			}
+		}
+	}
+
+	sortGraph(g)
+	return
+}
+
+// Sort the nodes of a Graph by address, and its edges
+// by the address of their ends
+func sortGraph(g types.Graph) {
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].Address < g.Nodes[j].Address
+	})
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+}
+
+// Return the subgraph of the nodes for which keep returns true
+func subgraph(g types.Graph, keep func(types.GraphNode) bool) (sub types.Graph) {
+	sub.Nodes = []types.GraphNode{}
+	sub.Edges = []types.GraphEdge{}
+
+	kept := make(map[string]bool)
+	for _, n := range g.Nodes {
+		if keep(n) {
+			kept[n.Address] = true
+			sub.Nodes = append(sub.Nodes, n)
+		}
+	}
+	for _, e := range g.Edges {
+		if kept[e.From] && kept[e.To] {
+			sub.Edges = append(sub.Edges, e)
+		}
+	}
+	return
+}
+
+// FilterModule returns the subgraph of the resources of a module
+// (e.g. module.vpc) and its child modules
+func FilterModule(g types.Graph, module string) types.Graph {
+	return subgraph(g, func(n types.GraphNode) bool {
+		return n.Module == module ||
+			strings.HasPrefix(n.Module, module+".") ||
+			strings.HasPrefix(n.Module, module+"[")
+	})
+}
+
+// BlastRadius returns the subgraph of a resource instance and of all the
+// instances that transitively depend on it, up to a depth (unlimited
+// when not positive). The depth of each node is its distance to the
+// resource instance.
+func BlastRadius(g types.Graph, address string, depth int) (types.Graph, error) {
+	if abs, diags := addrs.ParseAbsResourceInstanceStr(address); !diags.HasErrors() {
+		address = abs.String()
+	}
+
+	dependents := make(map[string][]string)
+	for _, e := range g.Edges {
+		dependents[e.To] = append(dependents[e.To], e.From)
+	}
+
+	depths := make(map[string]int)
+	for _, n := range g.Nodes {
+		if n.Address == address {
+			depths[address] = 0
+		}
+	}
+	if _, ok := depths[address]; !ok {
+		return types.Graph{}, fmt.Errorf("resource %s not found", address)
+	}
+
+	queue := []string{address}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if depth > 0 && depths[current] >= depth {
+			continue
+		}
+		for _, d := range dependents[current] {
+			if _, ok := depths[d]; !ok {
+				depths[d] = depths[current] + 1
+				queue = append(queue, d)
+			}
+		}
+	}
+
+	sub := subgraph(g, func(n types.GraphNode) bool {
+		_, ok := depths[n.Address]
+		return ok
+	})
+	for i := range sub.Nodes {
+		sub.Nodes[i].Depth = depths[sub.Nodes[i].Address]
+	}
+	return sub, nil
+}
+
+// DOT returns the Graphviz representation of a Graph.
+// Data sources are drawn as dashed nodes and tainted
+// resource instances in red.
+func DOT(g types.Graph, name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", strconv.Quote(name))
+	b.WriteString("  rankdir = \"RL\";\n")
+	b.WriteString("  node [shape = \"box\"];\n")
+	for _, n := range g.Nodes {
+		var attrs []string
+		if n.Mode == types.ResourceModeData {
+			attrs = append(attrs, `style = "dashed"`)
+		}
+		if n.Status == types.ResourceStatusTainted {
+			attrs = append(attrs, `color = "red"`)
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, "  %s [%s];\n", strconv.Quote(n.Address), strings.Join(attrs, ", "))
+		} else {
+			fmt.Fprintf(&b, "  %s;\n", strconv.Quote(n.Address))
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
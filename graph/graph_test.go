@@ -0,0 +1,128 @@
+package graph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/camptocamp/terraboard/types"
+)
+
+func testState() types.State {
+	return types.State{
+		Modules: []types.Module{
+			{
+				Resources: []types.Resource{
+					{Mode: "managed", Type: "aws_vpc", Name: "main", Dependencies: []byte(`[]`)},
+					{Mode: "data", Type: "aws_ami", Name: "ubuntu"},
+					{Mode: "managed", Type: "aws_instance", Name: "web", Status: "tainted",
+						Dependencies: []byte(`["data.aws_ami.ubuntu","module.vpc.aws_subnet.private","aws_iam_role.gone"]`)},
+				},
+			},
+			{
+				Path: `module.vpc["prod"]`,
+				Resources: []types.Resource{
+					{Mode: "managed", Type: "aws_subnet", Name: "private", Index: "[0]", Dependencies: []byte(`["aws_vpc.main"]`)},
+					{Mode: "managed", Type: "aws_subnet", Name: "private", Index: "[1]", Dependencies: []byte(`["aws_vpc.main"]`)},
+				},
+			},
+		},
+	}
+}
+
+func nodeAddresses(g types.Graph) (addresses []string) {
+	for _, n := range g.Nodes {
+		addresses = append(addresses, n.Address)
+	}
+	return
+}
+
+func TestBuild(t *testing.T) {
+	g := Build(testState())
+
+	expectedNodes := []string{
+		"aws_instance.web",
+		"aws_vpc.main",
+		"data.aws_ami.ubuntu",
+		`module.vpc["prod"].aws_subnet.private[0]`,
+		`module.vpc["prod"].aws_subnet.private[1]`,
+	}
+	if nodes := nodeAddresses(g); !reflect.DeepEqual(nodes, expectedNodes) {
+		t.Fatalf("Expected nodes %v, got %v", expectedNodes, nodes)
+	}
+
+	expectedEdges := []types.GraphEdge{
+		{From: "aws_instance.web", To: "data.aws_ami.ubuntu"},
+		{From: "aws_instance.web", To: `module.vpc["prod"].aws_subnet.private[0]`},
+		{From: "aws_instance.web", To: `module.vpc["prod"].aws_subnet.private[1]`},
+		{From: `module.vpc["prod"].aws_subnet.private[0]`, To: "aws_vpc.main"},
+		{From: `module.vpc["prod"].aws_subnet.private[1]`, To: "aws_vpc.main"},
+	}
+	if !reflect.DeepEqual(g.Edges, expectedEdges) {
+		t.Fatalf("Expected edges %v, got %v", expectedEdges, g.Edges)
+	}
+}
+
+func TestFilterModule(t *testing.T) {
+	g := FilterModule(Build(testState()), "module.vpc")
+
+	expectedNodes := []string{
+		`module.vpc["prod"].aws_subnet.private[0]`,
+		`module.vpc["prod"].aws_subnet.private[1]`,
+	}
+	if nodes := nodeAddresses(g); !reflect.DeepEqual(nodes, expectedNodes) {
+		t.Fatalf("Expected nodes %v, got %v", expectedNodes, nodes)
+	}
+	if len(g.Edges) != 0 {
+		t.Fatalf("Expected no edges, got %v", g.Edges)
+	}
+}
+
+func TestBlastRadius(t *testing.T) {
+	g := Build(testState())
+
+	radius, err := BlastRadius(g, "aws_vpc.main", 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	depths := make(map[string]int)
+	for _, n := range radius.Nodes {
+		depths[n.Address] = n.Depth
+	}
+	expectedDepths := map[string]int{
+		"aws_vpc.main": 0,
+		`module.vpc["prod"].aws_subnet.private[0]`: 1,
+		`module.vpc["prod"].aws_subnet.private[1]`: 1,
+		"aws_instance.web":                         2,
+	}
+	if !reflect.DeepEqual(depths, expectedDepths) {
+		t.Fatalf("Expected depths %v, got %v", expectedDepths, depths)
+	}
+	if len(radius.Edges) != 4 {
+		t.Fatalf("Expected 4 edges, got %v", radius.Edges)
+	}
+
+	radius, _ = BlastRadius(g, "aws_vpc.main", 1)
+	if len(radius.Nodes) != 3 {
+		t.Fatalf("Expected 3 nodes within depth 1, got %v", nodeAddresses(radius))
+	}
+
+	if _, err := BlastRadius(g, "aws_vpc.other", 0); err == nil {
+		t.Fatalf("Expected an error, got nil")
+	}
+}
+
+func TestDOT(t *testing.T) {
+	dot := DOT(Build(testState()), "lineage")
+
+	for _, line := range []string{
+		`digraph "lineage" {`,
+		`  "aws_instance.web" [color = "red"];`,
+		`  "data.aws_ami.ubuntu" [style = "dashed"];`,
+		`  "module.vpc[\"prod\"].aws_subnet.private[0]" -> "aws_vpc.main";`,
+	} {
+		if !strings.Contains(dot, line+"\n") {
+			t.Errorf("Expected line %s in:\n%s", line, dot)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package graph
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/camptocamp/terraboard/types"
+)
+
+const defaultWorkspace = "default"
+
+// remoteStatePaths returns the possible paths, as listed by the state
+// providers, of the upstream State of a terraform_remote_state data source,
+// following the layout of each Terraform backend
+func remoteStatePaths(ref types.StackReference) (paths []string) {
+	var config map[string]interface{}
+	if err := json.Unmarshal(ref.Config, &config); err != nil {
+		return nil
+	}
+	get := func(key, def string) string {
+		if v, ok := config[key].(string); ok && v != "" {
+			return v
+		}
+		return def
+	}
+	workspace := ref.Workspace
+	if workspace == "" {
+		workspace = defaultWorkspace
+	}
+	isDefault := workspace == defaultWorkspace
+
+	switch ref.Backend {
+	case "s3":
+		key := get("key", "")
+		if key == "" {
+			return nil
+		}
+		if !isDefault {
+			key = strings.Join([]string{get("workspace_key_prefix", "env:"), workspace, key}, "/")
+		}
+		return []string{key, get("bucket", "") + "/" + key}
+	case "gcs":
+		name := workspace + ".tfstate"
+		if prefix := strings.Trim(get("prefix", ""), "/"); prefix != "" {
+			name = prefix + "/" + name
+		}
+		return []string{get("bucket", "") + "/" + name, name}
+	case "azurerm":
+		key := get("key", "")
+		if key == "" {
+			return nil
+		}
+		if !isDefault {
+			key += "env:" + workspace
+		}
+		return []string{get("container_name", "") + "/" + key, key}
+	case "remote", "cloud":
+		workspaces, _ := config["workspaces"].(map[string]interface{})
+		if name, ok := workspaces["name"].(string); ok && name != "" {
+			return []string{name}
+		}
+		if prefix, ok := workspaces["prefix"].(string); ok {
+			return []string{prefix + workspace}
+		}
+		return []string{workspace}
+	case "consul":
+		p := get("path", "")
+		if p == "" {
+			return nil
+		}
+		if !isDefault {
+			p += "-env:" + workspace
+		}
+		return []string{p}
+	case "pg":
+		return []string{get("schema_name", "terraform_remote_state") + "/" + workspace}
+	case "http":
+		if a := get("address", ""); a != "" {
+			return []string{a}
+		}
+	case "local":
+		p := get("path", "terraform.tfstate")
+		if !isDefault {
+			p = path.Join(get("workspace_dir", "terraform.tfstate.d"), workspace, "terraform.tfstate")
+		}
+		// Relative paths point to another directory of the same repository
+		p = path.Clean(p)
+		for strings.HasPrefix(p, "../") {
+			p = strings.TrimPrefix(p, "../")
+		}
+		return []string{p}
+	}
+	return nil
+}
+
+// ResolveRemoteState returns the path of the upstream State of a
+// terraform_remote_state data source among the known State paths.
+// Paths matching exactly are preferred, then a single path ending
+// with the expected one (e.g. with a bucket or directory prefix).
+func ResolveRemoteState(ref types.StackReference, known []string) (string, bool) {
+	candidates := remoteStatePaths(ref)
+	for _, c := range candidates {
+		for _, k := range known {
+			if k == c {
+				return k, true
+			}
+		}
+	}
+	for _, c := range candidates {
+		var matches []string
+		for _, k := range known {
+			if strings.HasSuffix(k, "/"+c) {
+				matches = append(matches, k)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], true
+		}
+	}
+	return "", false
+}
+
+// Stacks returns the graph of the dependencies between States, resolving
+// the terraform_remote_state data sources against the known States
+func Stacks(nodes []types.StackNode, refs []types.StackReference) (g types.StackGraph) {
+	g.Nodes = nodes
+	if g.Nodes == nil {
+		g.Nodes = []types.StackNode{}
+	}
+	g.Edges = []types.StackEdge{}
+	g.Unresolved = []types.StackReference{}
+
+	known := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		known = append(known, n.Path)
+	}
+
+	edges := make(map[[2]string]int)
+	for _, ref := range refs {
+		upstream, ok := ResolveRemoteState(ref, known)
+		if !ok {
+			g.Unresolved = append(g.Unresolved, ref)
+			continue
+		}
+		if upstream == ref.Path {
+			continue
+		}
+		key := [2]string{ref.Path, upstream}
+		i, ok := edges[key]
+		if !ok {
+			i = len(g.Edges)
+			edges[key] = i
+			g.Edges = append(g.Edges, types.StackEdge{From: ref.Path, To: upstream})
+		}
+		g.Edges[i].Addresses = append(g.Edges[i].Addresses, ref.Address)
+	}
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+	return
+}
+
+// Consumers returns the States reading the outputs of the States of a
+// lineage, directly or transitively up to a depth (unlimited when not
+// positive), sorted by depth. The addresses of a consumer are its
+// data sources reading the State through which it was reached.
+func Consumers(g types.StackGraph, lineage string, depth int) []types.StackConsumer {
+	lineages := make(map[string]string)
+	for _, n := range g.Nodes {
+		lineages[n.Path] = n.LineageValue
+	}
+	consumers := make(map[string][]types.StackEdge)
+	for _, e := range g.Edges {
+		consumers[e.To] = append(consumers[e.To], e)
+	}
+
+	depths := make(map[string]int)
+	var queue []string
+	for _, n := range g.Nodes {
+		if n.LineageValue == lineage {
+			depths[n.Path] = 0
+			queue = append(queue, n.Path)
+		}
+	}
+
+	result := []types.StackConsumer{}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if depth > 0 && depths[current] >= depth {
+			continue
+		}
+		for _, e := range consumers[current] {
+			if _, ok := depths[e.From]; ok {
+				continue
+			}
+			depths[e.From] = depths[current] + 1
+			queue = append(queue, e.From)
+			result = append(result, types.StackConsumer{
+				Path:         e.From,
+				LineageValue: lineages[e.From],
+				Addresses:    e.Addresses,
+				Depth:        depths[e.From],
+			})
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Depth != result[j].Depth {
+			return result[i].Depth < result[j].Depth
+		}
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
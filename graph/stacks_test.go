@@ -0,0 +1,118 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/camptocamp/terraboard/types"
+)
+
+func TestResolveRemoteState(t *testing.T) {
+	known := []string{
+		"network/terraform.tfstate",
+		"env:/staging/network/terraform.tfstate",
+		"my-bucket/apps/default.tfstate",
+		"states/shared/prod.tfstate",
+		"tfstate/shared.tfstate",
+		"prod-network",
+		"terraform_remote_state/default",
+		"stacks/vpc/terraform.tfstate",
+		"eu/default.tfstate",
+		"us/default.tfstate",
+	}
+
+	for _, tc := range []struct {
+		name      string
+		backend   string
+		workspace string
+		config    string
+		expected  string
+	}{
+		{"s3", "s3", "default", `{"bucket":"states","key":"network/terraform.tfstate"}`, "network/terraform.tfstate"},
+		{"s3 workspace", "s3", "staging", `{"bucket":"states","key":"network/terraform.tfstate"}`, "env:/staging/network/terraform.tfstate"},
+		{"gcs", "gcs", "", `{"bucket":"my-bucket","prefix":"apps/"}`, "my-bucket/apps/default.tfstate"},
+		{"azurerm", "azurerm", "default", `{"container_name":"tfstate","key":"shared.tfstate"}`, "tfstate/shared.tfstate"},
+		{"remote", "remote", "default", `{"organization":"acme","workspaces":{"name":"prod-network"}}`, "prod-network"},
+		{"remote prefix", "remote", "network", `{"organization":"acme","workspaces":{"prefix":"prod-"}}`, "prod-network"},
+		{"pg", "pg", "default", `{}`, "terraform_remote_state/default"},
+		{"local", "local", "default", `{"path":"../vpc/terraform.tfstate"}`, "stacks/vpc/terraform.tfstate"},
+		{"ambiguous", "gcs", "default", `{"bucket":"other"}`, ""},
+		{"unknown", "s3", "default", `{"bucket":"states","key":"unknown.tfstate"}`, ""},
+		{"unsupported", "etcdv3", "default", `{}`, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ref := types.StackReference{
+				Backend:   tc.backend,
+				Workspace: tc.workspace,
+				Config:    []byte(tc.config),
+			}
+			path, ok := ResolveRemoteState(ref, known)
+			if ok != (tc.expected != "") || path != tc.expected {
+				t.Errorf("Expected %q, got %q (%v)", tc.expected, path, ok)
+			}
+		})
+	}
+}
+
+func stackTestGraph() types.StackGraph {
+	nodes := []types.StackNode{
+		{Path: "network.tfstate", LineageValue: "network"},
+		{Path: "database.tfstate", LineageValue: "database"},
+		{Path: "app.tfstate", LineageValue: "app"},
+		{Path: "monitoring.tfstate", LineageValue: "monitoring"},
+	}
+	s3 := func(path, address, key string) types.StackReference {
+		return types.StackReference{
+			Path:      path,
+			Address:   address,
+			Backend:   "s3",
+			Workspace: "default",
+			Config:    []byte(`{"bucket":"states","key":"` + key + `"}`),
+		}
+	}
+	refs := []types.StackReference{
+		s3("database.tfstate", "data.terraform_remote_state.network", "network.tfstate"),
+		s3("app.tfstate", "data.terraform_remote_state.network", "network.tfstate"),
+		s3("app.tfstate", "data.terraform_remote_state.db", "database.tfstate"),
+		s3("app.tfstate", "module.x.data.terraform_remote_state.db", "database.tfstate"),
+		s3("monitoring.tfstate", "data.terraform_remote_state.app", "app.tfstate"),
+		s3("monitoring.tfstate", "data.terraform_remote_state.legacy", "legacy.tfstate"),
+	}
+	return Stacks(nodes, refs)
+}
+
+func TestStacks(t *testing.T) {
+	g := stackTestGraph()
+
+	expectedEdges := []types.StackEdge{
+		{From: "app.tfstate", To: "database.tfstate", Addresses: []string{"data.terraform_remote_state.db", "module.x.data.terraform_remote_state.db"}},
+		{From: "app.tfstate", To: "network.tfstate", Addresses: []string{"data.terraform_remote_state.network"}},
+		{From: "database.tfstate", To: "network.tfstate", Addresses: []string{"data.terraform_remote_state.network"}},
+		{From: "monitoring.tfstate", To: "app.tfstate", Addresses: []string{"data.terraform_remote_state.app"}},
+	}
+	if !reflect.DeepEqual(g.Edges, expectedEdges) {
+		t.Fatalf("Expected edges %v, got %v", expectedEdges, g.Edges)
+	}
+	if len(g.Unresolved) != 1 || g.Unresolved[0].Address != "data.terraform_remote_state.legacy" {
+		t.Fatalf("Expected the legacy remote state to be unresolved, got %v", g.Unresolved)
+	}
+}
+
+func TestConsumers(t *testing.T) {
+	g := stackTestGraph()
+
+	expected := []types.StackConsumer{
+		{Path: "app.tfstate", LineageValue: "app", Addresses: []string{"data.terraform_remote_state.network"}, Depth: 1},
+		{Path: "database.tfstate", LineageValue: "database", Addresses: []string{"data.terraform_remote_state.network"}, Depth: 1},
+		{Path: "monitoring.tfstate", LineageValue: "monitoring", Addresses: []string{"data.terraform_remote_state.app"}, Depth: 2},
+	}
+	if consumers := Consumers(g, "network", 0); !reflect.DeepEqual(consumers, expected) {
+		t.Fatalf("Expected %v, got %v", expected, consumers)
+	}
+	if consumers := Consumers(g, "network", 1); !reflect.DeepEqual(consumers, expected[:2]) {
+		t.Fatalf("Expected %v, got %v", expected[:2], consumers)
+	}
+	if consumers := Consumers(g, "monitoring", 0); len(consumers) != 0 {
+		t.Fatalf("Expected no consumers, got %v", consumers)
+	}
+}
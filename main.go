@@ -11,22 +11,33 @@ package main
 // @host localhost:8080
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
+	"github.com/camptocamp/terraboard/analysis"
 	"github.com/camptocamp/terraboard/api"
 	"github.com/camptocamp/terraboard/auth"
 	"github.com/camptocamp/terraboard/config"
 	"github.com/camptocamp/terraboard/db"
+	"github.com/camptocamp/terraboard/metrics"
+	"github.com/camptocamp/terraboard/notify"
+	"github.com/camptocamp/terraboard/scan"
+	"github.com/camptocamp/terraboard/sensitive"
 	"github.com/camptocamp/terraboard/state"
+	"github.com/camptocamp/terraboard/sync"
 	"github.com/camptocamp/terraboard/util"
 	"github.com/gorilla/mux"
 	tfversion "github.com/hashicorp/terraform/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 
 	_ "github.com/camptocamp/terraboard/docs" // docs is generated by Swag CLI, you have to import it.
@@ -43,82 +54,25 @@ func handleWithDB(apiF func(w http.ResponseWriter, r *http.Request,
 	})
 }
 
-func handleWithStateProviders(apiF func(w http.ResponseWriter, r *http.Request,
-	sps []state.Provider), sps []state.Provider) func(http.ResponseWriter, *http.Request) {
+func handleWithSyncEngine(apiF func(w http.ResponseWriter, r *http.Request,
+	e *sync.Engine), e *sync.Engine) func(http.ResponseWriter, *http.Request) {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiF(w, r, sps)
+		apiF(w, r, e)
 	})
 }
 
-func isKnownStateVersion(statesVersions map[string][]string, versionID, path string) bool {
-	if v, ok := statesVersions[versionID]; ok {
-		for _, s := range v {
-			if s == path {
-				return true
-			}
-		}
-	}
-	return false
+func handleWithDBAndSyncEngine(apiF func(w http.ResponseWriter, r *http.Request,
+	d *db.Database, e *sync.Engine), d *db.Database, e *sync.Engine) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiF(w, r, d, e)
+	})
 }
 
-// Refresh the DB
-// This should be the only direct bridge between the state providers and the DB
-func refreshDB(syncInterval uint16, d *db.Database, sp state.Provider) {
-	interval := time.Duration(syncInterval) * time.Minute
-	for {
-		log.Infof("Refreshing DB")
-		states, err := sp.GetStates()
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-			}).Error("Failed to retrieve states. Retrying in 1 minute.")
-			time.Sleep(interval)
-			continue
-		}
-
-		statesVersions := d.ListStatesVersions()
-		for _, st := range states {
-			versions, _ := sp.GetVersions(st)
-			for k, v := range versions {
-				if _, ok := statesVersions[v.ID]; ok {
-					log.WithFields(log.Fields{
-						"version_id": v.ID,
-					}).Debug("Version is already in the database, skipping")
-				} else {
-					if err := d.InsertVersion(&versions[k]); err != nil {
-						log.Error(err.Error())
-					}
-				}
-
-				if isKnownStateVersion(statesVersions, v.ID, st) {
-					log.WithFields(log.Fields{
-						"path":       st,
-						"version_id": v.ID,
-					}).Debug("State is already in the database, skipping")
-					continue
-				}
-				state, err := sp.GetState(st, v.ID)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"path":       st,
-						"version_id": v.ID,
-						"error":      err,
-					}).Error("Failed to fetch state from bucket")
-					continue
-				}
-				if err = d.InsertState(st, v.ID, state); err != nil {
-					log.WithFields(log.Fields{
-						"path":       st,
-						"version_id": v.ID,
-						"error":      err,
-					}).Error("Failed to insert state in the database")
-				}
-			}
-		}
-
-		log.Debugf("Waiting %d minutes until next DB sync", syncInterval)
-		time.Sleep(interval)
-	}
+func handleWithStateProviders(apiF func(w http.ResponseWriter, r *http.Request,
+	sps []state.Provider), sps []state.Provider) func(http.ResponseWriter, *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiF(w, r, sps)
+	})
 }
 
 var version = "undefined"
@@ -168,15 +122,69 @@ func main() {
 	// Set up auth
 	auth.Setup(c)
 
+	// Set up the thresholds of the analyses
+	if err := analysis.Setup(c); err != nil {
+		log.Fatalf("Failed to set up the analyses: %v", err)
+	}
+
+	// Stop the sync and the server on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Set up the DB and start S3->DB sync
+	// The sync engine should be the only direct bridge between the state providers and the DB
 	database := db.Init(c.DB, c.Log.Level == "debug")
+
+	// Set up the protection of the sensitive values
+	protector, err := sensitive.New(c.Sensitive)
+	if err != nil {
+		log.Fatalf("Failed to set up the protection of sensitive values: %v", err)
+	}
+	if protector != nil {
+		log.Infof("Storing sensitive values with the %s mode", protector.Mode())
+		database.SetProtector(protector)
+	}
+
+	// Set up the scan of the states for leaked secrets
+	scanner, err := scan.New(c.Scan)
+	if err != nil {
+		log.Fatalf("Failed to set up the secret scanner: %v", err)
+	}
+	if scanner != nil {
+		log.Infof("Scanning states for leaked secrets with %d rules", len(scanner.Rules()))
+		database.SetScanner(scanner)
+	}
+
+	// Set up the webhook notifications
+	notifier := notify.New(c.Webhooks)
+	notifyDone := make(chan struct{})
+	if notifier != nil {
+		log.Info("Sending notifications to the configured webhooks")
+		database.SetNotifier(notifier)
+	}
+	go func() {
+		notifier.Run(ctx)
+		close(notifyDone)
+	}()
+
+	// Watch the locks for the metrics and the notifications
+	lockWatcher := sync.NewLockWatcher(sps, time.Duration(c.DB.SyncInterval)*time.Minute, database)
+	lockWatcher.SetNotifier(notifier)
+	go lockWatcher.Run(ctx)
+
+	syncDone := make(chan struct{})
+	var engine *sync.Engine
 	if c.DB.NoSync {
 		log.Infof("Not syncing database, as requested.")
+		close(syncDone)
 	} else {
 		log.Debugf("Total providers: %d\n", len(sps))
-		for _, sp := range sps {
-			go refreshDB(c.DB.SyncInterval, database, sp)
-		}
+		engine = sync.NewEngine(database, sps, time.Duration(c.DB.SyncInterval)*time.Minute, int(c.DB.SyncWorkers))
+		engine.SetNotifier(notifier)
+		go func() {
+			engine.Run(ctx)
+			close(syncDone)
+		}()
 	}
 	defer database.Close()
 
@@ -189,20 +197,41 @@ func main() {
 	apiRouter.HandleFunc(util.GetFullPath("user"), api.GetUser)
 	apiRouter.HandleFunc(util.GetFullPath("lineages"), handleWithDB(api.GetLineages, database))
 	apiRouter.HandleFunc(util.GetFullPath("lineages/stats"), handleWithDB(api.ListStateStats, database))
+	apiRouter.HandleFunc(util.GetFullPath("lineages/compare"), handleWithDB(api.CompareLineages, database))
 	apiRouter.HandleFunc(util.GetFullPath("lineages/tfversion/count"),
 		handleWithDB(api.ListTerraformVersionsWithCount, database))
 	apiRouter.HandleFunc(util.GetFullPath("lineages/{lineage}"), handleWithDB(api.GetState, database))
 	apiRouter.HandleFunc(util.GetFullPath("lineages/{lineage}/activity"), handleWithDB(api.GetLineageActivity, database))
 	apiRouter.HandleFunc(util.GetFullPath("lineages/{lineage}/compare"), handleWithDB(api.StateCompare, database))
+	apiRouter.HandleFunc(util.GetFullPath("lineages/{lineage}/graph"), handleWithDB(api.GetLineageGraph, database))
+	apiRouter.HandleFunc(util.GetFullPath("lineages/{lineage}/consumers"), handleWithDB(api.GetLineageConsumers, database))
+	apiRouter.HandleFunc(util.GetFullPath("lineages/{lineage}/timeline"), handleWithDB(api.GetLineageTimeline, database))
+	apiRouter.HandleFunc(util.GetFullPath("lineages/{lineage}/resources/{address}/history"),
+		handleWithDB(api.GetResourceHistory, database))
+	apiRouter.HandleFunc(util.GetFullPath("findings"), handleWithDB(api.ListFindings, database))
+	apiRouter.HandleFunc(util.GetFullPath("findings/scan"), handleWithDB(api.ScanFindings, database))
 	apiRouter.HandleFunc(util.GetFullPath("locks"), handleWithStateProviders(api.GetLocks, sps))
+	apiRouter.HandleFunc(util.GetFullPath("stacks"), handleWithDB(api.GetStacks, database))
+	apiRouter.HandleFunc(util.GetFullPath("analysis/duplicates"), handleWithDB(api.GetDuplicates, database))
+	apiRouter.HandleFunc(util.GetFullPath("analysis/stale"), handleWithDBAndSyncEngine(api.GetStaleStates, database, engine))
+	apiRouter.HandleFunc(util.GetFullPath("analysis/lineages"), handleWithDB(api.GetLineageAnomalies, database))
+	apiRouter.HandleFunc(util.GetFullPath("search"), handleWithDB(api.Search, database))
 	apiRouter.HandleFunc(util.GetFullPath("search/attribute"), handleWithDB(api.SearchAttribute, database))
 	apiRouter.HandleFunc(util.GetFullPath("resource/types"), handleWithDB(api.ListResourceTypes, database))
 	apiRouter.HandleFunc(util.GetFullPath("resource/types/count"), handleWithDB(api.ListResourceTypesWithCount, database))
+	apiRouter.HandleFunc(util.GetFullPath("stats/timeseries"), handleWithDB(api.GetTimeSeries, database))
 	apiRouter.HandleFunc(util.GetFullPath("resource/names"), handleWithDB(api.ListResourceNames, database))
 	apiRouter.HandleFunc(util.GetFullPath("attribute/keys"), handleWithDB(api.ListAttributeKeys, database))
 	apiRouter.HandleFunc(util.GetFullPath("tfversions"), handleWithDB(api.ListTfVersions, database))
 	apiRouter.HandleFunc(util.GetFullPath("plans"), handleWithDB(api.ManagePlans, database))
 	apiRouter.HandleFunc(util.GetFullPath("plans/summary"), handleWithDB(api.GetPlansSummary, database))
+	apiRouter.HandleFunc(util.GetFullPath("sync/status"), handleWithSyncEngine(api.GetSyncStatus, engine))
+	apiRouter.HandleFunc(util.GetFullPath("sync/runs"), handleWithDB(api.ListSyncRuns, database))
+	apiRouter.HandleFunc(util.GetFullPath("sync/trigger"), handleWithSyncEngine(api.TriggerSync, engine))
+
+	// Handle Prometheus metrics
+	prometheus.MustRegister(metrics.NewCollector(database, lockWatcher))
+	r.Handle(util.GetFullPath("metrics"), promhttp.Handler())
 
 	// Handle swagger files
 	swaggerRouter := mux.NewRouter()
@@ -229,9 +258,23 @@ func main() {
 		ReadHeaderTimeout: 3 * time.Second,
 	}
 
+	go func() {
+		<-ctx.Done()
+		log.Info("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error(err.Error())
+		}
+	}()
+
 	// Start server
 	log.Debugf("Listening on port %d\n", c.Web.Port)
-	log.Fatal(server.ListenAndServe())
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-syncDone
+	<-notifyDone
 }
 
 func serveSwagger(port int, router *mux.Router) {
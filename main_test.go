@@ -8,28 +8,6 @@ import (
 	"gorm.io/gorm"
 )
 
-func TestIsKnownStateVersion_Match(t *testing.T) {
-
-	statesVersions := map[string][]string{
-		"fakeVersionID": {"myfakepath/terraform.tfstate"},
-	}
-
-	if !isKnownStateVersion(statesVersions, "fakeVersionID", "myfakepath/terraform.tfstate") {
-		t.Fatalf("Expected %t, got %t", true, false)
-	}
-}
-
-func TestIsKnownStateVersion_NoMatch(t *testing.T) {
-
-	statesVersions := map[string][]string{
-		"fakeVersionID": {"myfakepath/terraform.tfstate"},
-	}
-
-	if isKnownStateVersion(statesVersions, "VersionID", "myfakepath/terraform.tfstate") {
-		t.Fatalf("Expected %t, got %t", false, true)
-	}
-}
-
 func handlerWithDB(w http.ResponseWriter, r *http.Request, d *db.Database) {
 }
 
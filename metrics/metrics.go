@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/camptocamp/terraboard/state"
+	"github.com/camptocamp/terraboard/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const namespace = "terraboard"
+
+// stageList is the stage reported for sync errors
+// occurring while listing the states of a provider
+const stageList = "list"
+
+// Sync metrics, updated at the end of each sync run
+var (
+	syncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "duration_seconds",
+		Help:      "Duration of the sync runs.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+	}, []string{"provider"})
+	syncRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "runs_total",
+		Help:      "Number of sync runs.",
+	}, []string{"provider", "trigger"})
+	syncErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "errors_total",
+		Help:      "Number of sync errors, by stage.",
+	}, []string{"provider", "stage"})
+	syncStates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "states_total",
+		Help:      "Number of states processed by the sync.",
+	}, []string{"provider"})
+	syncVersions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "versions_ingested_total",
+		Help:      "Number of state versions ingested in the database.",
+	}, []string{"provider"})
+	syncLastRun = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Time at which the last sync run ended.",
+	}, []string{"provider"})
+	syncLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Time at which the last successful sync run ended.",
+	}, []string{"provider"})
+)
+
+// ObserveSyncRun updates the sync metrics with a finished sync run
+func ObserveSyncRun(run *types.SyncRun) {
+	syncDuration.WithLabelValues(run.Provider).Observe(run.EndedAt.Sub(run.StartedAt).Seconds())
+	syncRuns.WithLabelValues(run.Provider, run.Trigger).Inc()
+	syncStates.WithLabelValues(run.Provider).Add(float64(run.States))
+	syncVersions.WithLabelValues(run.Provider).Add(float64(run.NewVersions))
+	for _, serr := range run.Errors {
+		syncErrors.WithLabelValues(run.Provider, serr.Stage).Inc()
+	}
+
+	syncLastRun.WithLabelValues(run.Provider).Set(float64(run.EndedAt.Unix()))
+	if run.Error != "" {
+		syncErrors.WithLabelValues(run.Provider, stageList).Inc()
+		return
+	}
+	syncLastSuccess.WithLabelValues(run.Provider).Set(float64(run.EndedAt.Unix()))
+}
+
+// Inventory is the part of the database read when collecting inventory metrics
+type Inventory interface {
+	ListResourceTypesWithCount() ([]map[string]string, error)
+	ListTerraformVersionsWithCount(query url.Values) ([]map[string]string, error)
+}
+
+// LockSource provides the latest locks polled from each provider,
+// by provider name
+type LockSource interface {
+	Locks() map[string]map[string]state.LockInfo
+}
+
+// Collector collects the locks and inventory metrics at scrape time
+type Collector struct {
+	inventory Inventory
+	locks     LockSource
+
+	locksHeld      *prometheus.Desc
+	lockAge        *prometheus.Desc
+	resources      *prometheus.Desc
+	stateTfVersion *prometheus.Desc
+}
+
+// NewCollector returns a Collector reading the locks polled by the lock
+// source and inventory counts from the database
+func NewCollector(inventory Inventory, locks LockSource) *Collector {
+	return &Collector{
+		inventory: inventory,
+		locks:     locks,
+		locksHeld: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "locks_held"),
+			"Number of currently held state locks.",
+			nil, nil,
+		),
+		lockAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "lock", "age_seconds"),
+			"Time since a currently held state lock was acquired.",
+			[]string{"provider", "path", "operation", "who"}, nil,
+		),
+		resources: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "resources"),
+			"Number of resources in the latest state versions, by type.",
+			[]string{"type"}, nil,
+		),
+		stateTfVersion: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "states"),
+			"Number of states, by Terraform version of their latest version.",
+			[]string{"terraform_version"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.locksHeld
+	ch <- c.lockAge
+	ch <- c.resources
+	ch <- c.stateTfVersion
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collectLocks(ch)
+	c.collectInventory(ch)
+}
+
+func (c *Collector) collectLocks(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	held := 0
+	for provider, locks := range c.locks.Locks() {
+		for path, lock := range locks {
+			held++
+			if lock.Created == nil {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(c.lockAge, prometheus.GaugeValue,
+				now.Sub(*lock.Created).Seconds(), provider, path, lock.Operation, lock.Who)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.locksHeld, prometheus.GaugeValue, float64(held))
+}
+
+func (c *Collector) collectInventory(ch chan<- prometheus.Metric) {
+	if c.inventory == nil {
+		return
+	}
+
+	resourceTypes, err := c.inventory.ListResourceTypesWithCount()
+	if err != nil {
+		log.Errorf("Failed to list resource types for metrics: %v", err)
+	}
+	for _, t := range resourceTypes {
+		if count, err := strconv.ParseFloat(t["count"], 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.resources, prometheus.GaugeValue, count, t["name"])
+		}
+	}
+
+	versions, err := c.inventory.ListTerraformVersionsWithCount(url.Values{})
+	if err != nil {
+		log.Errorf("Failed to list Terraform versions for metrics: %v", err)
+	}
+	for _, v := range versions {
+		if count, err := strconv.ParseFloat(v["count"], 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.stateTfVersion, prometheus.GaugeValue, count, v["name"])
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/state"
+	"github.com/camptocamp/terraboard/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLockSource map[string]map[string]state.LockInfo
+
+func (s fakeLockSource) Locks() map[string]map[string]state.LockInfo {
+	return s
+}
+
+type fakeInventory struct{}
+
+func (fakeInventory) ListResourceTypesWithCount() ([]map[string]string, error) {
+	return []map[string]string{
+		{"name": "aws_instance", "count": "3"},
+		{"name": "aws_s3_bucket", "count": "1"},
+	}, nil
+}
+
+func (fakeInventory) ListTerraformVersionsWithCount(url.Values) ([]map[string]string, error) {
+	return []map[string]string{
+		{"name": "1.5.7", "count": "2"},
+	}, nil
+}
+
+func TestObserveSyncRun(t *testing.T) {
+	started := time.Now().Add(-time.Minute)
+	ObserveSyncRun(&types.SyncRun{
+		Provider:    "test-0",
+		Trigger:     "schedule",
+		StartedAt:   started,
+		EndedAt:     started.Add(30 * time.Second),
+		States:      4,
+		NewVersions: 2,
+		Errors: []types.SyncError{
+			{Path: "a.tfstate", Stage: "fetch"},
+		},
+	})
+	ObserveSyncRun(&types.SyncRun{
+		Provider:  "test-0",
+		Trigger:   "manual",
+		StartedAt: started.Add(time.Minute),
+		EndedAt:   started.Add(time.Minute),
+		Error:     "access denied",
+	})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(syncRuns.WithLabelValues("test-0", "schedule")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(syncRuns.WithLabelValues("test-0", "manual")))
+	assert.Equal(t, float64(4), testutil.ToFloat64(syncStates.WithLabelValues("test-0")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(syncVersions.WithLabelValues("test-0")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(syncErrors.WithLabelValues("test-0", "fetch")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(syncErrors.WithLabelValues("test-0", stageList)))
+	assert.Equal(t, float64(started.Add(30*time.Second).Unix()), testutil.ToFloat64(syncLastSuccess.WithLabelValues("test-0")))
+	assert.Equal(t, float64(started.Add(time.Minute).Unix()), testutil.ToFloat64(syncLastRun.WithLabelValues("test-0")))
+}
+
+func TestCollector(t *testing.T) {
+	created := time.Now().Add(-3 * time.Hour)
+	locks := fakeLockSource{
+		"s3-0": {
+			"network.tfstate": {Operation: "OperationTypeApply", Who: "alice@host", Created: &created},
+			"dns.tfstate":     {Operation: "OperationTypePlan", Who: "N/A"},
+		},
+		// The same path listed by another provider
+		"s3-1": {
+			"network.tfstate": {Operation: "OperationTypeApply", Who: "alice@host", Created: &created},
+		},
+	}
+	c := NewCollector(fakeInventory{}, locks)
+
+	expected := `
+# HELP terraboard_locks_held Number of currently held state locks.
+# TYPE terraboard_locks_held gauge
+terraboard_locks_held 3
+# HELP terraboard_resources Number of resources in the latest state versions, by type.
+# TYPE terraboard_resources gauge
+terraboard_resources{type="aws_instance"} 3
+terraboard_resources{type="aws_s3_bucket"} 1
+# HELP terraboard_states Number of states, by Terraform version of their latest version.
+# TYPE terraboard_states gauge
+terraboard_states{terraform_version="1.5.7"} 2
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"terraboard_locks_held", "terraboard_resources", "terraboard_states")
+	assert.Nil(t, err)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	families, err := reg.Gather()
+	assert.Nil(t, err)
+	for _, f := range families {
+		if f.GetName() != "terraboard_lock_age_seconds" {
+			continue
+		}
+		assert.Len(t, f.GetMetric(), 2)
+		for _, m := range f.GetMetric() {
+			assert.InDelta(t, 3*time.Hour.Seconds(), m.GetGauge().GetValue(), 60)
+		}
+		return
+	}
+	t.Error("terraboard_lock_age_seconds not found")
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/camptocamp/terraboard/state"
+	log "github.com/sirupsen/logrus"
+)
+
+// LineageFinder finds the lineage of the latest state at a path
+type LineageFinder interface {
+	GetPathLineage(path string) (string, error)
+}
+
+// LocksChanged notifies the locks which were acquired or released
+// between two polls of the locks of a provider
+func (n *Notifier) LocksChanged(previous, current map[string]state.LockInfo, lf LineageFinder) {
+	if n == nil {
+		return
+	}
+	for _, ev := range diffLocks(previous, current, time.Now()) {
+		ev.Lineage = pathLineage(lf, ev.Path)
+		n.Notify(ev)
+	}
+}
+
+// pathLineage returns the lineage of the latest state at a path,
+// or an empty string when it is unknown
+func pathLineage(lf LineageFinder, path string) string {
+	lineage, err := lf.GetPathLineage(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  path,
+			"error": err,
+		}).Error("Failed to find the lineage of a locked state")
+	}
+	return lineage
+}
+
+// diffLocks returns the events of the locks released and acquired
+// between two polls, sorted by path.
+// A lock whose ID changed was released then acquired again.
+func diffLocks(previous, current map[string]state.LockInfo, now time.Time) (events []Event) {
+	for path, lock := range previous {
+		if cur, ok := current[path]; !ok || cur.ID != lock.ID {
+			events = append(events, lockEvent(EventLockReleased, path, lock, now))
+		}
+	}
+	for path, lock := range current {
+		if prev, ok := previous[path]; !ok || prev.ID != lock.ID {
+			events = append(events, lockEvent(EventLockAcquired, path, lock, now))
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Path < events[j].Path
+	})
+	return
+}
+
+func lockEvent(eventType, path string, lock state.LockInfo, now time.Time) Event {
+	lock.Path = path
+	text := fmt.Sprintf("State %s was unlocked", path)
+	if eventType == EventLockAcquired {
+		text = fmt.Sprintf("State %s was locked by %s", path, lock.Who)
+	}
+	return Event{
+		Type: eventType,
+		Time: now,
+		Text: text,
+		Path: path,
+		Lock: &lock,
+	}
+}
@@ -0,0 +1,297 @@
+// Package notify sends Terraboard events to outbound webhooks.
+//
+// Events are queued by Notify and delivered asynchronously by Run, so that
+// a slow or unavailable webhook never blocks the sync or the API. Each event
+// is posted as JSON to every webhook whose filters it matches, and failed
+// deliveries are retried with an exponential backoff.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	gosync "sync"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	"github.com/camptocamp/terraboard/state"
+	"github.com/camptocamp/terraboard/types"
+	"github.com/hashicorp/go-cleanhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// Types of events
+const (
+	EventStateVersion = "state.version"
+	EventLockAcquired = "lock.acquired"
+	EventLockReleased = "lock.released"
+	EventPlanFailed   = "plan.failed"
+)
+
+// Headers set on the webhook requests
+const (
+	HeaderEvent     = "X-Terraboard-Event"
+	HeaderSignature = "X-Terraboard-Signature"
+)
+
+const (
+	queueSize      = 256
+	requestTimeout = 10 * time.Second
+	minRetryDelay  = time.Second
+)
+
+// Event is the JSON payload posted to the webhooks.
+// Text is a human readable summary, so that chat incoming webhooks
+// (Slack, Teams...) can display the event as is.
+type Event struct {
+	Type             string          `json:"type"`
+	Time             time.Time       `json:"time"`
+	Text             string          `json:"text"`
+	Lineage          string          `json:"lineage,omitempty"`
+	Path             string          `json:"path,omitempty"`
+	VersionID        string          `json:"version_id,omitempty"`
+	Serial           uint64          `json:"serial,omitempty"`
+	TerraformVersion string          `json:"terraform_version,omitempty"`
+	Lock             *state.LockInfo `json:"lock,omitempty"`
+	Plan             *Plan           `json:"plan,omitempty"`
+}
+
+// Plan describes a submitted plan
+type Plan struct {
+	GitRemote string `json:"git_remote"`
+	GitCommit string `json:"git_commit"`
+	CiURL     string `json:"ci_url"`
+	Source    string `json:"source"`
+	ExitCode  int    `json:"exit_code"`
+}
+
+// webhook is a configured notification target
+type webhook struct {
+	config.WebhookConfig
+	client *http.Client
+}
+
+// Notifier delivers events to the configured webhooks.
+// A nil Notifier silently drops all events.
+type Notifier struct {
+	webhooks      []*webhook
+	events        chan Event
+	minRetryDelay time.Duration
+}
+
+// New returns a Notifier for the webhooks which have a URL,
+// or nil when none is configured
+func New(c []config.WebhookConfig) *Notifier {
+	n := &Notifier{
+		events:        make(chan Event, queueSize),
+		minRetryDelay: minRetryDelay,
+	}
+	for _, wc := range c {
+		if wc.URL == "" {
+			continue
+		}
+		client := cleanhttp.DefaultPooledClient()
+		client.Timeout = requestTimeout
+		n.webhooks = append(n.webhooks, &webhook{WebhookConfig: wc, client: client})
+	}
+	if len(n.webhooks) == 0 {
+		return nil
+	}
+	return n
+}
+
+// Notify queues an event for delivery. It never blocks:
+// events are dropped when the queue is full.
+func (n *Notifier) Notify(ev Event) {
+	if n == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case n.events <- ev:
+	default:
+		log.WithFields(log.Fields{
+			"type": ev.Type,
+			"path": ev.Path,
+		}).Warn("Notification queue is full, dropping event")
+	}
+}
+
+// StateVersion notifies a new version of a state. The sync engine only
+// calls it once the initial sync of the provider is done, so that importing
+// the history of existing states doesn't flood the webhooks.
+func (n *Notifier) StateVersion(path, versionID string, sf *statefile.File) {
+	if n == nil {
+		return
+	}
+	ev := Event{
+		Type:      EventStateVersion,
+		Text:      fmt.Sprintf("State %s was updated to serial %d", path, sf.Serial),
+		Lineage:   sf.Lineage,
+		Path:      path,
+		VersionID: versionID,
+		Serial:    sf.Serial,
+	}
+	if sf.TerraformVersion != nil {
+		ev.TerraformVersion = sf.TerraformVersion.String()
+	}
+	n.Notify(ev)
+}
+
+// PlanSubmitted notifies a submitted plan when it failed,
+// i.e. when its exit code isn't zero
+func (n *Notifier) PlanSubmitted(lineage string, p types.Plan) {
+	if n == nil || p.ExitCode == 0 {
+		return
+	}
+	n.Notify(Event{
+		Type:             EventPlanFailed,
+		Text:             fmt.Sprintf("Plan of lineage %s failed with exit code %d", lineage, p.ExitCode),
+		Lineage:          lineage,
+		TerraformVersion: p.TFVersion,
+		Plan: &Plan{
+			GitRemote: p.GitRemote,
+			GitCommit: p.GitCommit,
+			CiURL:     p.CiURL,
+			Source:    p.Source,
+			ExitCode:  p.ExitCode,
+		},
+	})
+}
+
+// Run delivers the queued events until the context is cancelled,
+// then waits for the pending deliveries
+func (n *Notifier) Run(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	var wg gosync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-n.events:
+			for _, w := range n.webhooks {
+				if !w.matches(ev) {
+					continue
+				}
+				wg.Add(1)
+				go func(w *webhook) {
+					defer wg.Done()
+					if err := n.deliver(ctx, w, ev); err != nil {
+						log.WithFields(log.Fields{
+							"webhook": w.URL,
+							"type":    ev.Type,
+							"error":   err,
+						}).Error("Failed to deliver notification")
+					}
+				}(w)
+			}
+		}
+	}
+}
+
+// deliver posts an event to a webhook, retrying on failure
+func (n *Notifier) deliver(ctx context.Context, w *webhook, ev Event) (err error) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	delay := n.minRetryDelay
+	for attempt := 0; ; attempt++ {
+		if err = w.post(ctx, ev.Type, body); err == nil {
+			return nil
+		}
+		if attempt >= int(w.MaxRetries) {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"webhook": w.URL,
+			"type":    ev.Type,
+			"error":   err,
+		}).Debugf("Retrying notification in %v", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (w *webhook) post(ctx context.Context, eventType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, eventType)
+	if w.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(w.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// matches checks an event against the filters of the webhook.
+// An event which lacks the field of a filter doesn't match it.
+func (w *webhook) matches(ev Event) bool {
+	if len(w.Events) > 0 && !contains(w.Events, ev.Type) {
+		return false
+	}
+	if len(w.Lineages) > 0 && !matchGlobs(w.Lineages, ev.Lineage) {
+		return false
+	}
+	if len(w.Paths) > 0 && !matchGlobs(w.Paths, ev.Path) {
+		return false
+	}
+	return true
+}
+
+// Sign returns the signature of a payload, as sent in the
+// X-Terraboard-Signature header: sha256=<hex HMAC-SHA256 of the body>
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlobs(patterns []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	"github.com/camptocamp/terraboard/state"
+	"github.com/camptocamp/terraboard/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver is a webhook endpoint failing its first requests
+type receiver struct {
+	mu       gosync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	if len(rc.requests) <= rc.failures {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+func TestNewWithoutWebhooks(t *testing.T) {
+	var n *Notifier = New([]config.WebhookConfig{{}})
+	assert.Nil(t, n)
+
+	// A nil Notifier is usable
+	n.Notify(Event{Type: EventStateVersion})
+	n.StateVersion("prod/network.tfstate", "v1", &statefile.File{})
+	n.Run(context.Background())
+}
+
+func TestDeliver(t *testing.T) {
+	rc := &receiver{failures: 2}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	n := New([]config.WebhookConfig{{URL: ts.URL, Secret: "foo", MaxRetries: 3}})
+	n.minRetryDelay = time.Millisecond
+
+	ev := Event{Type: EventLockAcquired, Path: "prod/network.tfstate", Text: "locked"}
+	err := n.deliver(context.Background(), n.webhooks[0], ev)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rc.count())
+
+	r := rc.requests[2]
+	assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	assert.Equal(t, EventLockAcquired, r.Header.Get(HeaderEvent))
+	assert.Equal(t, Sign("foo", rc.bodies[2]), r.Header.Get(HeaderSignature))
+
+	var got Event
+	assert.Nil(t, json.Unmarshal(rc.bodies[2], &got))
+	assert.Equal(t, ev, got)
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	rc := &receiver{failures: 10}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	n := New([]config.WebhookConfig{{URL: ts.URL, MaxRetries: 1}})
+	n.minRetryDelay = time.Millisecond
+
+	err := n.deliver(context.Background(), n.webhooks[0], Event{Type: EventPlanFailed})
+	assert.EqualError(t, err, "webhook returned 502 Bad Gateway")
+	assert.Equal(t, 2, rc.count())
+	assert.Empty(t, rc.requests[0].Header.Get(HeaderSignature))
+}
+
+func TestRun(t *testing.T) {
+	prod := &receiver{}
+	prodServer := httptest.NewServer(prod)
+	defer prodServer.Close()
+	all := &receiver{}
+	allServer := httptest.NewServer(all)
+	defer allServer.Close()
+
+	n := New([]config.WebhookConfig{
+		{URL: prodServer.URL, Paths: []string{"prod/*"}},
+		{URL: allServer.URL},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+
+	n.StateVersion("prod/network.tfstate", "v1", &statefile.File{Lineage: "lineage", Serial: 3})
+	n.PlanSubmitted("lineage", types.Plan{ExitCode: 0})
+	n.PlanSubmitted("lineage", types.Plan{ExitCode: 1})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for (prod.count() < 1 || all.count() < 2) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	assert.Equal(t, 1, prod.count())
+	assert.Equal(t, 2, all.count())
+	assert.Contains(t, string(prod.bodies[0]), `"text":"State prod/network.tfstate was updated to serial 3"`)
+}
+
+func TestMatches(t *testing.T) {
+	w := &webhook{WebhookConfig: config.WebhookConfig{
+		Events:   []string{EventStateVersion, EventLockAcquired},
+		Lineages: []string{"prod-*"},
+	}}
+
+	assert.True(t, w.matches(Event{Type: EventStateVersion, Lineage: "prod-network"}))
+	assert.False(t, w.matches(Event{Type: EventStateVersion, Lineage: "dev-network"}))
+	assert.False(t, w.matches(Event{Type: EventPlanFailed, Lineage: "prod-network"}))
+	assert.True(t, w.matches(Event{Type: EventLockAcquired, Lineage: "prod-network", Path: "prod/network.tfstate"}))
+	// Locks of unknown states have no lineage
+	assert.False(t, w.matches(Event{Type: EventLockAcquired, Path: "prod/network.tfstate"}))
+}
+
+func TestDiffLocks(t *testing.T) {
+	now := time.Now()
+	previous := map[string]state.LockInfo{
+		"a.tfstate": {ID: "1", Who: "alice"},
+		"b.tfstate": {ID: "2", Who: "bob"},
+	}
+	current := map[string]state.LockInfo{
+		"b.tfstate": {ID: "3", Who: "carol"},
+		"c.tfstate": {ID: "4", Who: "dave"},
+	}
+
+	var got []string
+	for _, ev := range diffLocks(previous, current, now) {
+		got = append(got, ev.Type+" "+ev.Path+" "+ev.Lock.Who)
+		assert.Equal(t, ev.Path, ev.Lock.Path)
+	}
+	assert.Equal(t, []string{
+		"lock.released a.tfstate alice",
+		"lock.released b.tfstate bob",
+		"lock.acquired b.tfstate carol",
+		"lock.acquired c.tfstate dave",
+	}, got)
+
+	assert.Empty(t, diffLocks(current, current, now))
+}
@@ -0,0 +1,155 @@
+package scan
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+
+	"github.com/camptocamp/terraboard/types"
+	"gopkg.in/yaml.v2"
+)
+
+// Rule detects a kind of secret in the string values of the States.
+// Pattern is matched against the values, and the secret is the "secret"
+// named group of the pattern when it has one, or the whole match.
+// Keys restricts the rule to the attribute paths and outputs it matches,
+// and MinEntropy to the secrets with a Shannon entropy, in bits per
+// character, of at least that value.
+type Rule struct {
+	ID          string  `yaml:"id" json:"id"`
+	Description string  `yaml:"description" json:"description"`
+	Severity    string  `yaml:"severity" json:"severity"`
+	Pattern     string  `yaml:"pattern" json:"pattern"`
+	Keys        string  `yaml:"keys" json:"keys,omitempty"`
+	MinEntropy  float64 `yaml:"min-entropy" json:"min_entropy,omitempty"`
+
+	pattern *regexp.Regexp
+	keys    *regexp.Regexp
+}
+
+// rulesFile is the format of the YAML file of additional rules
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// defaultRules are the rules used unless disabled
+var defaultRules = []Rule{
+	{
+		ID:          "aws-access-key-id",
+		Description: "AWS access key ID",
+		Severity:    types.SeverityHigh,
+		Pattern:     `\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`,
+	},
+	{
+		ID:          "aws-secret-access-key",
+		Description: "AWS secret access key",
+		Severity:    types.SeverityCritical,
+		Pattern:     `(?:^|[^A-Za-z0-9/+])(?P<secret>[A-Za-z0-9/+]{40})(?:$|[^A-Za-z0-9/+=])`,
+		Keys:        `(?i)secret`,
+		MinEntropy:  4,
+	},
+	{
+		ID:          "private-key",
+		Description: "PEM private key",
+		Severity:    types.SeverityCritical,
+		Pattern:     `-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`,
+	},
+	{
+		ID:          "jwt",
+		Description: "JSON Web Token",
+		Severity:    types.SeverityHigh,
+		Pattern:     `\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`,
+	},
+	{
+		ID:          "github-token",
+		Description: "GitHub token",
+		Severity:    types.SeverityHigh,
+		Pattern:     `\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	},
+	{
+		ID:          "slack-token",
+		Description: "Slack token",
+		Severity:    types.SeverityHigh,
+		Pattern:     `\bxox[abposr]-[A-Za-z0-9-]{10,}`,
+	},
+	{
+		ID:          "kubeconfig-credentials",
+		Description: "Kubeconfig client key or token",
+		Severity:    types.SeverityHigh,
+		Pattern:     `(?:client-key-data|token):\s*["']?(?P<secret>[A-Za-z0-9+/=._-]{20,})`,
+	},
+	{
+		ID:          "connection-string-password",
+		Description: "Password in a connection string",
+		Severity:    types.SeverityMedium,
+		Pattern:     `\b[a-zA-Z][a-zA-Z0-9+.-]*://[^\s:/@]+:(?P<secret>[^\s@/$]{3,})@`,
+	},
+	{
+		ID:          "assigned-secret",
+		Description: "High-entropy secret assigned to a password, secret or token",
+		Severity:    types.SeverityMedium,
+		Pattern:     `(?i)(?:password|passwd|secret|token|api_?key)\w*["']?\s*[:=]\s*["']?(?P<secret>[^\s"',;]{12,})`,
+		MinEntropy:  3.5,
+	},
+	{
+		ID:          "high-entropy-string",
+		Description: "High-entropy string in a credential attribute",
+		Severity:    types.SeverityLow,
+		Pattern:     `^(?P<secret>[A-Za-z0-9+/=_.-]{20,})$`,
+		Keys:        `(?i)(?:password|passwd|secret|token|credential|api_?key|auth)`,
+		MinEntropy:  4,
+	},
+}
+
+// compile validates a Rule and compiles its regexes
+func (r *Rule) compile() (err error) {
+	if r.ID == "" {
+		return fmt.Errorf("scan rule without ID")
+	}
+	switch r.Severity {
+	case types.SeverityCritical, types.SeverityHigh, types.SeverityMedium, types.SeverityLow:
+	default:
+		return fmt.Errorf("invalid severity %q for scan rule %s", r.Severity, r.ID)
+	}
+	if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern for scan rule %s: %v", r.ID, err)
+	}
+	if r.Keys != "" {
+		if r.keys, err = regexp.Compile(r.Keys); err != nil {
+			return fmt.Errorf("invalid keys for scan rule %s: %v", r.ID, err)
+		}
+	}
+	return nil
+}
+
+// loadRules returns the rules of a YAML file
+func loadRules(filename string) ([]Rule, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scan rules: %v", err)
+	}
+	var f rulesFile
+	if err := yaml.UnmarshalStrict(content, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse scan rules: %v", err)
+	}
+	return f.Rules, nil
+}
+
+// entropy returns the Shannon entropy of a string, in bits per character
+func entropy(s string) (e float64) {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	n := 0
+	for _, c := range s {
+		counts[c]++
+		n++
+	}
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		e -= p * math.Log2(p)
+	}
+	return
+}
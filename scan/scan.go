@@ -0,0 +1,212 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/sensitive"
+	"github.com/camptocamp/terraboard/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// severityRanks orders the severities, most severe first
+var severityRanks = map[string]int{
+	types.SeverityCritical: 0,
+	types.SeverityHigh:     1,
+	types.SeverityMedium:   2,
+	types.SeverityLow:      3,
+}
+
+// Scanner runs a set of rules over the non-sensitive attributes
+// and outputs of the States to find leaked secrets
+type Scanner struct {
+	rules []Rule
+}
+
+// New returns the Scanner of a configuration, with the default rules
+// overridden or extended by the rules file, or nil when disabled
+func New(c config.ScanConfig) (*Scanner, error) {
+	if c.NoScan {
+		return nil, nil
+	}
+
+	var rules []Rule
+	if !c.NoDefaultRules {
+		rules = append(rules, defaultRules...)
+	}
+	if c.RulesFile != "" {
+		extra, err := loadRules(c.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range extra {
+			overridden := false
+			for i := range rules {
+				if rules[i].ID == r.ID {
+					rules[i] = r
+					overridden = true
+				}
+			}
+			if !overridden {
+				rules = append(rules, r)
+			}
+		}
+	}
+
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	// Check the most severe rules first, as a secret
+	// is only reported by the first rule matching it
+	sort.SliceStable(rules, func(i, j int) bool {
+		return severityRanks[rules[i].Severity] < severityRanks[rules[j].Severity]
+	})
+	return &Scanner{rules: rules}, nil
+}
+
+// Rules returns the rules of the Scanner
+func (s *Scanner) Rules() []Rule {
+	if s == nil {
+		return nil
+	}
+	return s.rules
+}
+
+// ScanState returns the Findings of the non-sensitive attributes of the
+// resources and deposed objects of a State, and of its non-sensitive outputs
+func (s *Scanner) ScanState(state types.State) (findings []types.Finding) {
+	if s == nil {
+		return nil
+	}
+	for _, m := range state.Modules {
+		for _, r := range m.Resources {
+			address := r.Address(m.Path)
+			findings = append(findings, s.scanAttributes(address, r.Attributes)...)
+			for _, d := range r.DeposedObjects {
+				findings = append(findings, s.scanAttributes(fmt.Sprintf("%s (deposed %s)", address, d.Key), d.Attributes)...)
+			}
+		}
+		for _, o := range m.OutputValues {
+			if o.Sensitive || sensitive.IsProtected(o.Value) {
+				continue
+			}
+			address := "output." + o.Name
+			if m.Path != "" {
+				address = m.Path + "." + address
+			}
+			findings = append(findings, s.scanValue(address, "", o.Name, o.Value)...)
+		}
+	}
+	return
+}
+
+func (s *Scanner) scanAttributes(address string, attrs []types.Attribute) (findings []types.Finding) {
+	for _, a := range attrs {
+		if a.Sensitive || sensitive.IsProtected(a.Value) {
+			continue
+		}
+		findings = append(findings, s.scanValue(address, a.Key, a.Key, a.Value)...)
+	}
+	return
+}
+
+// Scan the string leaves of a JSON value
+func (s *Scanner) scanValue(address, attributePath, key, value string) (findings []types.Finding) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		log.WithFields(log.Fields{
+			"address": address,
+			"error":   err,
+		}).Debug("Scanning invalid JSON value as a string")
+		v = value
+	}
+	walk(attributePath, key, v, func(path, key, str string) {
+		findings = append(findings, s.scanString(address, path, key, str)...)
+	})
+	return
+}
+
+// Call f on the string leaves of a value, with their path
+// and the key (or output name) used to match the rules
+func walk(path, key string, v interface{}, f func(path, key, str string)) {
+	switch val := v.(type) {
+	case string:
+		f(path, key, val)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			walk(p, p, val[k], f)
+		}
+	case []interface{}:
+		for i, e := range val {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			walk(p, p, e, f)
+		}
+	}
+}
+
+// Return the Findings of a string, reporting each
+// part of it with the most severe rule matching it
+func (s *Scanner) scanString(address, path, key, str string) (findings []types.Finding) {
+	var spans [][2]int
+	overlaps := func(start, end int) bool {
+		for _, sp := range spans {
+			if start < sp[1] && sp[0] < end {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, r := range s.rules {
+		if r.keys != nil && !r.keys.MatchString(key) {
+			continue
+		}
+		group := r.pattern.SubexpIndex("secret")
+		for _, m := range r.pattern.FindAllStringSubmatchIndex(str, -1) {
+			start, end := m[0], m[1]
+			if group > 0 && m[2*group] >= 0 {
+				start, end = m[2*group], m[2*group+1]
+			}
+			secret := str[start:end]
+			if r.MinEntropy > 0 && entropy(secret) < r.MinEntropy {
+				continue
+			}
+			if overlaps(start, end) {
+				continue
+			}
+			spans = append(spans, [2]int{start, end})
+			findings = append(findings, types.Finding{
+				RuleID:        r.ID,
+				Severity:      r.Severity,
+				Description:   r.Description,
+				Address:       address,
+				AttributePath: path,
+				Match:         mask(secret),
+			})
+		}
+	}
+	return
+}
+
+// mask only keeps the first characters of a secret, and its length
+func mask(secret string) string {
+	runes := []rune(secret)
+	if len(runes) <= 8 {
+		return fmt.Sprintf("***(%d)", len(runes))
+	}
+	return fmt.Sprintf("%s***(%d)", string(runes[:4]), len(runes))
+}
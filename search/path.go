@@ -0,0 +1,46 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// anyKey matches a map key or a list index in an attribute path
+const anyKey = `(\.[A-Za-z_][A-Za-z0-9_-]*|\[[0-9]+\]|\["([^"\\]|\\.)*"\])`
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// KeyPath returns the JSON path of a map key, e.g. tags.Name or
+// tags["kubernetes.io/cluster"] when the key isn't an identifier
+func KeyPath(path, key string) string {
+	if !identifierRegexp.MatchString(key) {
+		return fmt.Sprintf("%s[%s]", path, strconv.Quote(key))
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// PathRegexp returns the POSIX regex matching the attribute paths at or
+// under a JSON path, where [*] stands for any list index and * for any
+// map key or list index, e.g. ingress[*].cidr_blocks, ingress.*.cidr_blocks
+// or tags.*
+func PathRegexp(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	re := regexp.QuoteMeta(path)
+	re = strings.ReplaceAll(re, `\[\*\]`, `\[[0-9]+\]`)
+	re = strings.ReplaceAll(re, `\.\*`, anyKey)
+	if strings.HasPrefix(re, `\*`) {
+		re = `[A-Za-z_][A-Za-z0-9_-]*` + strings.TrimPrefix(re, `\*`)
+	}
+	re = "^" + re + `($|[.[])`
+	if _, err := regexp.Compile(re); err != nil {
+		return "", fmt.Errorf("invalid path %q: %v", path, err)
+	}
+	return re, nil
+}
@@ -0,0 +1,551 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// columns maps the fields of the query language to their SQL column
+var columns = map[string]string{
+	"type":       "resources.type",
+	"name":       "resources.name",
+	"index":      "resources.index",
+	"mode":       "resources.mode",
+	"provider":   "resources.provider",
+	"status":     "resources.status",
+	"module":     "modules.path",
+	"path":       "states.path",
+	"lineage":    "lineages.value",
+	"tf_version": "states.tf_version",
+	"serial":     "states.serial",
+	"key":        "attributes.key",
+	"value":      "attributes.value",
+	"modified":   "versions.last_modified",
+}
+
+// Fields of the query language which are not plain columns
+const (
+	fieldAttr   = "attr"
+	fieldOutput = "output"
+)
+
+// Comparison operators of the terms
+const (
+	opMatch        = ":"
+	opEqual        = "="
+	opNotEqual     = "!="
+	opLess         = "<"
+	opLessEqual    = "<="
+	opGreater      = ">"
+	opGreaterEqual = ">="
+)
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+// Error is an error at a position of a query
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Pos, e.Msg)
+}
+
+// Query is a parsed search query, as a parameterized SQL condition
+// on the states, modules, resources, attributes, lineages and versions.
+// Regexes are the /.../ values of the query, PostgreSQL POSIX regexes
+// left to the database to validate.
+type Query struct {
+	Where   string
+	Params  []interface{}
+	Regexes []string
+}
+
+// Parse parses a search query, made of terms such as type:aws_instance
+// combined with AND (or juxtaposition), OR, NOT (or -) and parentheses.
+//
+// Terms compare a field with a value: quoted values match exactly, bare
+// values containing * or ? match as wildcards, and /.../ values as POSIX
+// regexes.
+// attr:<path> and output:<name> terms compare the nested values of the
+// attributes of the resources and the outputs of the states, e.g.
+// attr:ingress.*.cidr_blocks="0.0.0.0/0", and attr:<path> alone checks that
+// a path exists. modified and serial also support <, <=, > and >=, and
+// modified:<from>..<to> matches a date range.
+func Parse(q string) (Query, error) {
+	p := &parser{input: q}
+	if p.skipSpaces(); p.eof() {
+		return Query{}, p.errorf("empty query")
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return Query{}, err
+	}
+	if p.skipSpaces(); !p.eof() {
+		return Query{}, p.errorf("unexpected %q", string(p.peek()))
+	}
+	return Query{Where: n.sql, Params: n.params, Regexes: p.regexes}, nil
+}
+
+// node is a parsed SQL condition
+type node struct {
+	sql    string
+	params []interface{}
+}
+
+func join(op string, nodes []node) node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	var n node
+	parts := make([]string, 0, len(nodes))
+	for _, c := range nodes {
+		parts = append(parts, c.sql)
+		n.params = append(n.params, c.params...)
+	}
+	n.sql = "(" + strings.Join(parts, " "+op+" ") + ")"
+	return n
+}
+
+type parser struct {
+	input   string
+	pos     int
+	regexes []string
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) peek() byte {
+	return p.input[p.pos]
+}
+
+func (p *parser) skipSpaces() {
+	for !p.eof() && unicode.IsSpace(rune(p.peek())) {
+		p.pos++
+	}
+}
+
+// Return whether a keyword (AND, OR, NOT) follows, and skip it
+func (p *parser) keyword(kw string) bool {
+	end := p.pos + len(kw)
+	if end > len(p.input) || p.input[p.pos:end] != kw {
+		return false
+	}
+	if end < len(p.input) && !unicode.IsSpace(rune(p.input[end])) && p.input[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *parser) parseOr() (node, error) {
+	var nodes []node
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return node{}, err
+		}
+		nodes = append(nodes, n)
+		if p.skipSpaces(); !p.keyword("OR") {
+			return join("OR", nodes), nil
+		}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	var nodes []node
+	for {
+		n, err := p.parseNot()
+		if err != nil {
+			return node{}, err
+		}
+		nodes = append(nodes, n)
+
+		p.skipSpaces()
+		if p.keyword("AND") {
+			continue
+		}
+		save := p.pos
+		if p.eof() || p.peek() == ')' || p.keyword("OR") {
+			p.pos = save
+			return join("AND", nodes), nil
+		}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	p.skipSpaces()
+	if p.eof() {
+		return node{}, p.errorf("unexpected end of query")
+	}
+	negated := p.keyword("NOT")
+	if !negated && p.peek() == '-' {
+		p.pos++
+		negated = true
+	}
+	if negated {
+		n, err := p.parseNot()
+		if err != nil {
+			return node{}, err
+		}
+		return node{sql: "NOT " + n.sql, params: n.params}, nil
+	}
+	if p.peek() == '(' {
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return node{}, err
+		}
+		if p.skipSpaces(); p.eof() || p.peek() != ')' {
+			return node{}, p.errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return node{sql: "(" + n.sql + ")", params: n.params}, nil
+	}
+	return p.parseTerm()
+}
+
+// value is the value of a term
+type value struct {
+	text   string
+	quoted bool
+	regex  bool
+}
+
+func (v value) wildcard() bool {
+	return !v.quoted && !v.regex && strings.ContainsAny(v.text, "*?")
+}
+
+func (p *parser) parseTerm() (node, error) {
+	start := p.pos
+	for !p.eof() && (p.peek() == '_' || unicode.IsLetter(rune(p.peek()))) {
+		p.pos++
+	}
+	field := strings.ToLower(p.input[start:p.pos])
+	if field == "" {
+		return node{}, p.errorf("expected a field, got %q", string(p.peek()))
+	}
+	_, isColumn := columns[field]
+	if !isColumn && field != fieldAttr && field != fieldOutput {
+		p.pos = start
+		return node{}, p.errorf("unknown field %q", field)
+	}
+
+	op := p.parseOp()
+	if op == "" {
+		return node{}, p.errorf("expected an operator after %s", field)
+	}
+
+	if field == fieldAttr || field == fieldOutput {
+		if op != opMatch {
+			return node{}, p.errorf("expected %s:<path>", field)
+		}
+		name, err := p.parsePath()
+		if err != nil {
+			return node{}, err
+		}
+		op = p.parseOp()
+		var v value
+		if op != "" {
+			if v, err = p.parseValue(); err != nil {
+				return node{}, err
+			}
+		}
+		if field == fieldAttr {
+			return p.attrTerm(name, op, v)
+		}
+		return p.outputTerm(name, op, v)
+	}
+
+	v, err := p.parseValue()
+	if err != nil {
+		return node{}, err
+	}
+	return p.columnTerm(field, op, v)
+}
+
+func (p *parser) parseOp() string {
+	for _, op := range []string{opNotEqual, opLessEqual, opGreaterEqual, opMatch, opEqual, opLess, opGreater} {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+// Parse the path of an attr or output term, up to its operator
+func (p *parser) parsePath() (string, error) {
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if unicode.IsSpace(rune(c)) || strings.IndexByte("()=!<>:", c) >= 0 {
+			break
+		}
+		if c == '"' {
+			if _, err := p.parseQuoted(); err != nil {
+				return "", err
+			}
+			continue
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a path")
+	}
+	return p.input[start:p.pos], nil
+}
+
+// Parse a double-quoted string, with backslash escapes
+func (p *parser) parseQuoted() (string, error) {
+	start := p.pos
+	p.pos++
+	for !p.eof() {
+		switch p.peek() {
+		case '\\':
+			p.pos += 2
+			continue
+		case '"':
+			p.pos++
+			s, err := strconv.Unquote(p.input[start:p.pos])
+			if err != nil {
+				p.pos = start
+				return "", p.errorf("invalid quoted string")
+			}
+			return s, nil
+		}
+		p.pos++
+	}
+	p.pos = start
+	return "", p.errorf("unterminated quoted string")
+}
+
+func (p *parser) parseValue() (value, error) {
+	if p.eof() {
+		return value{}, p.errorf("expected a value")
+	}
+	switch p.peek() {
+	case '"':
+		s, err := p.parseQuoted()
+		return value{text: s, quoted: true}, err
+	case '/':
+		start := p.pos
+		p.pos++
+		var b strings.Builder
+		for !p.eof() && p.peek() != '/' {
+			if p.peek() == '\\' && p.pos+1 < len(p.input) && p.input[p.pos+1] == '/' {
+				p.pos++
+			}
+			b.WriteByte(p.peek())
+			p.pos++
+		}
+		if p.eof() {
+			p.pos = start
+			return value{}, p.errorf("unterminated regex")
+		}
+		p.pos++
+		p.regexes = append(p.regexes, b.String())
+		return value{text: b.String(), regex: true}, nil
+	}
+	start := p.pos
+	for !p.eof() && !unicode.IsSpace(rune(p.peek())) && p.peek() != ')' {
+		p.pos++
+	}
+	if p.pos == start {
+		return value{}, p.errorf("expected a value")
+	}
+	return value{text: p.input[start:p.pos]}, nil
+}
+
+// Return the condition matching a column with a value,
+// exactly, as a wildcard or as a regex
+func match(column string, v value) node {
+	switch {
+	case v.regex:
+		return node{sql: column + " ~ ?", params: []interface{}{v.text}}
+	case v.wildcard():
+		return node{sql: column + " LIKE ?", params: []interface{}{likePattern(v.text)}}
+	}
+	return node{sql: column + " = ?", params: []interface{}{v.text}}
+}
+
+// EscapeLike escapes the wildcards of a LIKE pattern
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Return the LIKE pattern of a wildcard value
+func likePattern(s string) string {
+	return strings.NewReplacer("*", "%", "?", "_").Replace(EscapeLike(s))
+}
+
+// Return the condition of a comparison operator
+func compare(n node, op string) node {
+	if op == opNotEqual {
+		return node{sql: "NOT " + n.sql, params: n.params}
+	}
+	return n
+}
+
+func (p *parser) columnTerm(field, op string, v value) (node, error) {
+	column := columns[field]
+	switch field {
+	case "modified":
+		return p.dateTerm(column, op, v)
+	case "serial":
+		if isOrdering(op) {
+			n, err := strconv.ParseInt(v.text, 10, 64)
+			if err != nil {
+				return node{}, p.errorf("invalid serial %q", v.text)
+			}
+			return node{sql: fmt.Sprintf("%s %s ?", column, op), params: []interface{}{n}}, nil
+		}
+		column = "CAST(" + column + " AS text)"
+	case "index":
+		if !v.quoted && !v.regex && !v.wildcard() && !strings.HasPrefix(v.text, "[") {
+			v.text = resourceIndex(v.text)
+		}
+	case "value":
+		// Sensitive values are never searched
+		n := compare(match(column, v), op)
+		return node{sql: "(NOT attributes.sensitive AND " + n.sql + ")", params: n.params}, nil
+	}
+	if isOrdering(op) {
+		return node{}, p.errorf("operator %s is not supported for %s", op, field)
+	}
+	return compare(match(column, v), op), nil
+}
+
+func isOrdering(op string) bool {
+	return op == opLess || op == opLessEqual || op == opGreater || op == opGreaterEqual
+}
+
+// Return the index of a resource instance as stored,
+// e.g. [0] for 0 or ["a"] for a
+func resourceIndex(s string) string {
+	if _, err := strconv.Atoi(s); err == nil {
+		return "[" + s + "]"
+	}
+	j, _ := json.Marshal(s)
+	return "[" + string(j) + "]"
+}
+
+// Parse a date, returning the end of the period it stands for
+// (the next day for a date without time)
+func parseDate(s string) (t, end time.Time, err error) {
+	for _, layout := range dateLayouts {
+		if t, err = time.Parse(layout, s); err == nil {
+			if layout == dateLayouts[len(dateLayouts)-1] {
+				return t, t.AddDate(0, 0, 1), nil
+			}
+			return t, t, nil
+		}
+	}
+	return t, t, fmt.Errorf("invalid date %q", s)
+}
+
+// Return the condition of a date term: a comparison, a range
+// (from..to, either bound being optional and both being included)
+// or a single date, a date without time matching the whole day
+func (p *parser) dateTerm(column, op string, v value) (node, error) {
+	if isOrdering(op) {
+		t, end, err := parseDate(v.text)
+		if err != nil {
+			return node{}, p.errorf("%v", err)
+		}
+		// Compare with the end of a day for > and <=
+		if !end.Equal(t) {
+			switch op {
+			case opGreater:
+				t, op = end, opGreaterEqual
+			case opLessEqual:
+				t, op = end, opLess
+			}
+		}
+		return node{sql: fmt.Sprintf("%s %s ?", column, op), params: []interface{}{t}}, nil
+	}
+
+	from, to := v.text, v.text
+	if i := strings.Index(v.text, ".."); i >= 0 {
+		from, to = v.text[:i], v.text[i+2:]
+	}
+	var nodes []node
+	if from != "" {
+		t, _, err := parseDate(from)
+		if err != nil {
+			return node{}, p.errorf("%v", err)
+		}
+		nodes = append(nodes, node{sql: column + " >= ?", params: []interface{}{t}})
+	}
+	if to != "" {
+		t, end, err := parseDate(to)
+		if err != nil {
+			return node{}, p.errorf("%v", err)
+		}
+		if end.Equal(t) {
+			nodes = append(nodes, node{sql: column + " <= ?", params: []interface{}{t}})
+		} else {
+			nodes = append(nodes, node{sql: column + " < ?", params: []interface{}{end}})
+		}
+	}
+	if len(nodes) == 0 {
+		return node{}, p.errorf("empty date range")
+	}
+	return compare(join("AND", nodes), op), nil
+}
+
+// Return the condition of an attr term, on the nested
+// values of any attribute of the resources
+func (p *parser) attrTerm(path, op string, v value) (node, error) {
+	re, err := PathRegexp(path)
+	if err != nil {
+		return node{}, p.errorf("%v", err)
+	}
+	cond := node{sql: "ap.path ~ ?", params: []interface{}{re}}
+	if op != "" {
+		var c node
+		if isOrdering(op) {
+			n, err := strconv.ParseFloat(v.text, 64)
+			if err != nil {
+				return node{}, p.errorf("invalid number %q", v.text)
+			}
+			c = node{sql: fmt.Sprintf("ap.number %s ?", op), params: []interface{}{n}}
+		} else {
+			c = compare(match("ap.value", v), op)
+		}
+		cond = join("AND", []node{cond, c})
+	}
+	return node{
+		sql: "EXISTS (SELECT 1 FROM attributes a JOIN attribute_paths ap ON ap.attribute_id = a.id" +
+			" WHERE a.resource_id = resources.id AND " + cond.sql + ")",
+		params: cond.params,
+	}, nil
+}
+
+// Return the condition of an output term, on the
+// non-sensitive outputs of the modules of the states
+func (p *parser) outputTerm(name, op string, v value) (node, error) {
+	if isOrdering(op) {
+		return node{}, p.errorf("operator %s is not supported for outputs", op)
+	}
+	cond := match("o.name", value{text: name})
+	if op != "" {
+		cond = join("AND", []node{cond, compare(match("btrim(o.value, '\"')", v), op)})
+	}
+	return node{
+		sql: "EXISTS (SELECT 1 FROM output_values o JOIN modules om ON om.id = o.module_id" +
+			" WHERE om.state_id = states.id AND NOT o.sensitive AND " + cond.sql + ")",
+		params: cond.params,
+	}, nil
+}
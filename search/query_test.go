@@ -0,0 +1,145 @@
+package search
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPath(t *testing.T) {
+	assert.Equal(t, "tags", KeyPath("", "tags"))
+	assert.Equal(t, "tags.Team", KeyPath("tags", "Team"))
+	assert.Equal(t, `tags["kubernetes.io/role"]`, KeyPath("tags", "kubernetes.io/role"))
+	assert.Equal(t, `["1st"]`, KeyPath("", "1st"))
+}
+
+func TestPathRegexp(t *testing.T) {
+	for _, tc := range []struct {
+		path    string
+		matches []string
+		others  []string
+	}{
+		{"tags.Team", []string{"tags.Team"}, []string{"tags.TeamName", "tags", "mytags.Team"}},
+		{"tags", []string{"tags.Team", `tags["kubernetes.io/role"]`}, []string{"tags_all.Team"}},
+		{"ingress[*].cidr_blocks", []string{"ingress[0].cidr_blocks[1]", "ingress[12].cidr_blocks[0]"}, []string{"ingress[0].from_port", "ingress[0].x[1].cidr_blocks[0]"}},
+		{"ingress.*.cidr_blocks", []string{"ingress[0].cidr_blocks[1]", "ingress.main.cidr_blocks"}, []string{"ingress[0].x.cidr_blocks"}},
+		{"tags.*", []string{"tags.Team", `tags["kubernetes.io/role"]`}, []string{"tags"}},
+		{"*.Team", []string{"tags.Team", "labels.Team"}, []string{"tags.Owner"}},
+	} {
+		re, err := PathRegexp(tc.path)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", tc.path, err)
+		}
+		r := regexp.MustCompile(re)
+		for _, m := range tc.matches {
+			if !r.MatchString(m) {
+				t.Errorf("Expected %s to match %s (%s)", tc.path, m, re)
+			}
+		}
+		for _, o := range tc.others {
+			if r.MatchString(o) {
+				t.Errorf("Expected %s not to match %s (%s)", tc.path, o, re)
+			}
+		}
+	}
+
+	if _, err := PathRegexp(""); err == nil {
+		t.Errorf("Expected an error for an empty path")
+	}
+}
+
+func TestParse(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		query  string
+		where  string
+		params []interface{}
+	}{
+		{
+			`type:aws_security_group AND attr:ingress.*.cidr_blocks="0.0.0.0/0" AND NOT module:module.legacy*`,
+			`(resources.type = ? AND EXISTS (SELECT 1 FROM attributes a JOIN attribute_paths ap ON ap.attribute_id = a.id WHERE a.resource_id = resources.id AND (ap.path ~ ? AND ap.value = ?)) AND NOT modules.path LIKE ?)`,
+			[]interface{}{"aws_security_group", `^ingress(\.[A-Za-z_][A-Za-z0-9_-]*|\[[0-9]+\]|\["([^"\\]|\\.)*"\])\.cidr_blocks($|[.[])`, "0.0.0.0/0", "module.legacy%"},
+		},
+		{
+			`type:aws_instance (name:web OR -name:/^db_[0-9]+$/)`,
+			`(resources.type = ? AND ((resources.name = ? OR NOT resources.name ~ ?)))`,
+			[]interface{}{"aws_instance", "web", "^db_[0-9]+$"},
+		},
+		{
+			`index:0 OR index:"a" OR index:web`,
+			`(resources.index = ? OR resources.index = ? OR resources.index = ?)`,
+			[]interface{}{"[0]", "a", `["web"]`},
+		},
+		{
+			`output:vpc_id=vpc-* serial>=3 lineage!=abc`,
+			`(EXISTS (SELECT 1 FROM output_values o JOIN modules om ON om.id = o.module_id WHERE om.state_id = states.id AND NOT o.sensitive AND (o.name = ? AND btrim(o.value, '"') LIKE ?)) AND states.serial >= ? AND NOT lineages.value = ?)`,
+			[]interface{}{"vpc_id", "vpc-%", int64(3), "abc"},
+		},
+		{
+			`attr:tags["kubernetes.io/role"] attr:cpu_core_count>2 value:foo_*`,
+			`(EXISTS (SELECT 1 FROM attributes a JOIN attribute_paths ap ON ap.attribute_id = a.id WHERE a.resource_id = resources.id AND ap.path ~ ?) AND EXISTS (SELECT 1 FROM attributes a JOIN attribute_paths ap ON ap.attribute_id = a.id WHERE a.resource_id = resources.id AND (ap.path ~ ? AND ap.number > ?)) AND (NOT attributes.sensitive AND attributes.value LIKE ?))`,
+			[]interface{}{`^tags\["kubernetes\.io/role"\]($|[.[])`, `^cpu_core_count($|[.[])`, float64(2), `foo\_%`},
+		},
+		{
+			`modified:2024-03-01`,
+			`(versions.last_modified >= ? AND versions.last_modified < ?)`,
+			[]interface{}{day, day.AddDate(0, 0, 1)},
+		},
+		{
+			`modified:2024-03-01..2024-03-31`,
+			`(versions.last_modified >= ? AND versions.last_modified < ?)`,
+			[]interface{}{day, day.AddDate(0, 0, 31)},
+		},
+		{
+			`modified:..2024-03-01T12:00:00Z`,
+			`versions.last_modified <= ?`,
+			[]interface{}{day.Add(12 * time.Hour)},
+		},
+		{
+			`modified>2024-03-01`,
+			`versions.last_modified >= ?`,
+			[]interface{}{day.AddDate(0, 0, 1)},
+		},
+	} {
+		q, err := Parse(tc.query)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", tc.query, err)
+		}
+		assert.Equal(t, tc.where, q.Where, tc.query)
+		assert.Equal(t, tc.params, q.Params, tc.query)
+	}
+
+	// Regexes are POSIX ones, validated by the database
+	q, err := Parse(`name:/^(web|db)\1$/ OR lineage:/[a/`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`^(web|db)\1$`, "[a"}, q.Regexes)
+}
+
+func TestParse_errors(t *testing.T) {
+	for query, msg := range map[string]string{
+		"":                   "at position 0: empty query",
+		"foo:bar":            `at position 0: unknown field "foo"`,
+		"type:a AND":         "at position 10: unexpected end of query",
+		"type:x NOT":         "at position 10: unexpected end of query",
+		"NOT":                "at position 3: unexpected end of query",
+		"(type:a OR type:b":  "at position 17: missing closing parenthesis",
+		"type:a)":            `at position 6: unexpected ")"`,
+		`name:"web`:          "at position 5: unterminated quoted string",
+		"type<a":             "at position 6: operator < is not supported for type",
+		"serial>x":           `at position 8: invalid serial "x"`,
+		"modified:yesterday": `at position 18: invalid date "yesterday"`,
+		"attr:cpu>many":      `at position 13: invalid number "many"`,
+		"attr=cpu":           "at position 5: expected attr:<path>",
+		"output:vpc_id<3":    "at position 15: operator < is not supported for outputs",
+		"type aws_instance":  "at position 4: expected an operator after type",
+	} {
+		_, err := Parse(query)
+		if err == nil {
+			t.Errorf("Expected an error for %q", query)
+			continue
+		}
+		assert.Equal(t, msg, err.Error(), query)
+	}
+}
@@ -0,0 +1,343 @@
+package sensitive
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/camptocamp/terraboard/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Storage modes of the sensitive values
+const (
+	ModePlaintext = "plaintext"
+	ModeRedact    = "redact"
+	ModeEncrypt   = "encrypt"
+)
+
+// Prefixes of the protected values, stored as JSON strings
+const (
+	redactedPrefix  = "redacted:"
+	encryptedPrefix = "encrypted:"
+)
+
+const keySize = 32
+
+// Protector applies the ingestion policy of the sensitive values.
+// Redacted values only keep a keyed hash and the length of their JSON
+// encoding. Encrypted values keep them too, along with the value
+// encrypted with a random data key, itself encrypted with the
+// configured key (envelope encryption).
+type Protector struct {
+	mode     string
+	hashKey  []byte
+	wrapKey  []byte
+	patterns []*regexp.Regexp
+}
+
+// New returns the Protector of a configuration, or nil when sensitive
+// values are stored in plaintext and no key pattern is configured
+func New(c config.SensitiveConfig) (*Protector, error) {
+	p := &Protector{mode: c.Mode}
+	if p.mode == "" {
+		p.mode = ModePlaintext
+	}
+	switch p.mode {
+	case ModePlaintext, ModeRedact, ModeEncrypt:
+	default:
+		return nil, fmt.Errorf("unknown sensitive values mode %q", c.Mode)
+	}
+
+	for _, expr := range c.KeyPatterns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sensitive key pattern %q: %v", expr, err)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+
+	key, err := loadKey(c)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		// Derive distinct keys to hash values and to encrypt data keys
+		p.hashKey = deriveKey(key, "hash")
+		p.wrapKey = deriveKey(key, "encrypt")
+	} else if p.mode != ModePlaintext {
+		// An unkeyed hash would let low-entropy values be guessed
+		return nil, fmt.Errorf("a key is required to %s sensitive values", p.mode)
+	}
+
+	if p.mode == ModePlaintext && len(p.patterns) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// loadKey returns the configured key, read from the key file if any,
+// base64-encoded or raw
+func loadKey(c config.SensitiveConfig) ([]byte, error) {
+	encoded := c.Key
+	if c.KeyFile != "" {
+		content, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sensitive key file: %v", err)
+		}
+		if len(content) == keySize {
+			return content, nil
+		}
+		encoded = strings.TrimSpace(string(content))
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sensitive key: %v", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("sensitive key must be %d bytes long, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+func deriveKey(key []byte, usage string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("terraboard sensitive " + usage))
+	return mac.Sum(nil)
+}
+
+// Mode returns the storage mode of the sensitive values
+func (p *Protector) Mode() string {
+	if p == nil {
+		return ModePlaintext
+	}
+	return p.mode
+}
+
+// MatchKey returns whether the name of an attribute or an output
+// matches one of the configured key patterns
+func (p *Protector) MatchKey(key string) bool {
+	if p == nil {
+		return false
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchNestedKey returns whether a JSON value holds an object with a key
+// matching one of the configured key patterns, at any depth, such as the
+// password of a connection block
+func (p *Protector) MatchNestedKey(value string) bool {
+	if p == nil || len(p.patterns) == 0 {
+		return false
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return false
+	}
+	return p.matchNested(v)
+}
+
+func (p *Protector) matchNested(v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if p.MatchKey(k) || p.matchNested(e) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if p.matchNested(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Protect returns the value to store for a sensitive JSON value.
+// Null values are kept, as they hold no secret.
+func (p *Protector) Protect(value string) string {
+	if p == nil || p.mode == ModePlaintext || value == "null" {
+		return value
+	}
+
+	fingerprint := p.fingerprint(value)
+	if p.mode == ModeEncrypt {
+		encrypted, err := p.encrypt(value)
+		if err == nil {
+			return encode(encryptedPrefix + fingerprint + "," + encrypted)
+		}
+		log.WithError(err).Error("Failed to encrypt sensitive value, redacting it")
+	}
+	return encode(redactedPrefix + fingerprint)
+}
+
+// Return the keyed hash and length of a value
+func (p *Protector) fingerprint(value string) string {
+	mac := hmac.New(sha256.New, p.hashKey)
+	mac.Write([]byte(value))
+	return fmt.Sprintf("hmac-sha256=%s,length=%d", hex.EncodeToString(mac.Sum(nil)), len(value))
+}
+
+func (p *Protector) encrypt(value string) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	data, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(p.wrapKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("key=%s,data=%s",
+		base64.RawStdEncoding.EncodeToString(wrapped),
+		base64.RawStdEncoding.EncodeToString(data)), nil
+}
+
+// Reveal returns the original JSON value of an encrypted value.
+// Values which are not protected are returned as is.
+func (p *Protector) Reveal(value string) (string, error) {
+	s, ok := decode(value)
+	if !ok || !strings.HasPrefix(s, encryptedPrefix) {
+		if IsProtected(value) {
+			return "", fmt.Errorf("redacted values cannot be revealed")
+		}
+		return value, nil
+	}
+	if p == nil || p.wrapKey == nil {
+		return "", fmt.Errorf("a key is required to decrypt sensitive values")
+	}
+
+	fields := parseFields(strings.TrimPrefix(s, encryptedPrefix))
+	wrapped, err := base64.RawStdEncoding.DecodeString(fields["key"])
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.RawStdEncoding.DecodeString(fields["data"])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(p.wrapKey, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt data key: %v", err)
+	}
+	plain, err := open(dataKey, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %v", err)
+	}
+	return string(plain), nil
+}
+
+// Encrypt with AES-256-GCM, prepending the random nonce
+func seal(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encode(s string) string {
+	j, _ := json.Marshal(s)
+	return string(j)
+}
+
+// Return the string held by a protected JSON value
+func decode(value string) (string, bool) {
+	if !strings.HasPrefix(value, `"`+redactedPrefix) && !strings.HasPrefix(value, `"`+encryptedPrefix) {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal([]byte(value), &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+func parseFields(s string) map[string]string {
+	fields := make(map[string]string)
+	for _, f := range strings.Split(s, ",") {
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	return fields
+}
+
+// IsProtected returns whether a stored value was redacted or encrypted
+func IsProtected(value string) bool {
+	_, ok := decode(value)
+	return ok
+}
+
+// Fingerprint returns the part of a stored value that identifies the
+// original value, i.e. its hash and length for protected values, so that
+// values can be compared whether they were encrypted or not
+func Fingerprint(value string) string {
+	s, ok := decode(value)
+	if !ok {
+		return value
+	}
+	s = strings.TrimPrefix(strings.TrimPrefix(s, redactedPrefix), encryptedPrefix)
+	if i := strings.Index(s, ",key="); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// Length returns the length of the original JSON encoding of a stored value
+func Length(value string) int {
+	if !IsProtected(value) {
+		return len(value)
+	}
+	if n, err := strconv.Atoi(parseFields(Fingerprint(value))["length"]); err == nil {
+		return n
+	}
+	return len(value)
+}
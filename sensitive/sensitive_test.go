@@ -0,0 +1,163 @@
+package sensitive
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/camptocamp/terraboard/config"
+)
+
+var testKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+func TestNew(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config config.SensitiveConfig
+		isNil  bool
+		hasErr bool
+	}{
+		{"plaintext", config.SensitiveConfig{Mode: ModePlaintext}, true, false},
+		{"patterns", config.SensitiveConfig{Mode: ModePlaintext, KeyPatterns: []string{"password"}}, false, false},
+		{"redact", config.SensitiveConfig{Mode: ModeRedact, Key: testKey}, false, false},
+		{"redact without key", config.SensitiveConfig{Mode: ModeRedact}, true, true},
+		{"encrypt", config.SensitiveConfig{Mode: ModeEncrypt, Key: testKey}, false, false},
+		{"encrypt without key", config.SensitiveConfig{Mode: ModeEncrypt}, true, true},
+		{"short key", config.SensitiveConfig{Mode: ModeEncrypt, Key: "Zm9v"}, true, true},
+		{"unknown mode", config.SensitiveConfig{Mode: "hide"}, true, true},
+		{"invalid pattern", config.SensitiveConfig{Mode: ModeRedact, Key: testKey, KeyPatterns: []string{"("}}, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(tc.config)
+			if (err != nil) != tc.hasErr {
+				t.Fatalf("Expected error %v, got %v", tc.hasErr, err)
+			}
+			if (p == nil) != tc.isNil {
+				t.Fatalf("Expected nil Protector %v, got %v", tc.isNil, p)
+			}
+		})
+	}
+}
+
+func TestNew_keyFile(t *testing.T) {
+	f, err := os.CreateTemp("", "sensitive-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(testKey + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	p, err := New(config.SensitiveConfig{Mode: ModeEncrypt, KeyFile: f.Name()})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	withKey, _ := New(config.SensitiveConfig{Mode: ModeEncrypt, Key: testKey})
+	if Fingerprint(p.Protect(`"secret"`)) != Fingerprint(withKey.Protect(`"secret"`)) {
+		t.Fatalf("Expected the key file and the key to hash values alike")
+	}
+}
+
+func TestMatchKey(t *testing.T) {
+	p, _ := New(config.SensitiveConfig{KeyPatterns: []string{"password|secret|token|private_key"}})
+	for key, expected := range map[string]bool{
+		"master_password":  true,
+		"private_key_pem":  true,
+		"client_secret":    true,
+		"name":             false,
+		"public_key":       false,
+		"token_expiration": true,
+	} {
+		if p.MatchKey(key) != expected {
+			t.Errorf("Expected %s to match %v", key, expected)
+		}
+	}
+
+	var nilProtector *Protector
+	if nilProtector.MatchKey("password") {
+		t.Errorf("Expected a nil Protector to match no key")
+	}
+}
+
+func TestMatchNestedKey(t *testing.T) {
+	p, _ := New(config.SensitiveConfig{KeyPatterns: []string{"password|secret"}})
+	for value, expected := range map[string]bool{
+		`{"host":"db","password":"hunter2"}`:          true,
+		`[{"name":"a"},{"settings":{"secret":"s3"}}]`: true,
+		`{"host":"db","user":"admin"}`:                false,
+		`"password"`:                                  false,
+		`not json`:                                    false,
+	} {
+		if p.MatchNestedKey(value) != expected {
+			t.Errorf("Expected %s to match %v", value, expected)
+		}
+	}
+
+	var nilProtector *Protector
+	if nilProtector.MatchNestedKey(`{"password":"hunter2"}`) {
+		t.Errorf("Expected a nil Protector to match no key")
+	}
+}
+
+func TestProtect_redact(t *testing.T) {
+	p, _ := New(config.SensitiveConfig{Mode: ModeRedact, Key: testKey})
+
+	value := `"hunter2"`
+	protected := p.Protect(value)
+	if strings.Contains(protected, "hunter2") {
+		t.Fatalf("Expected the value to be redacted, got %s", protected)
+	}
+	expected := `"redacted:hmac-sha256=cb2da9ab0a6be448a86d3228bb47121c6e90927f4033ed1eda91826e9deb42fd,length=9"`
+	if protected != expected {
+		t.Fatalf("Expected %s, got %s", expected, protected)
+	}
+	if !IsProtected(protected) || IsProtected(value) {
+		t.Fatalf("Expected only the redacted value to be protected")
+	}
+	if Length(protected) != len(value) {
+		t.Fatalf("Expected length %d, got %d", len(value), Length(protected))
+	}
+	if _, err := p.Reveal(protected); err == nil {
+		t.Fatalf("Expected redacted values not to be revealed")
+	}
+	if p.Protect("null") != "null" {
+		t.Fatalf("Expected null values to be kept")
+	}
+}
+
+func TestProtect_encrypt(t *testing.T) {
+	p, _ := New(config.SensitiveConfig{Mode: ModeEncrypt, Key: testKey})
+
+	value := `{"user":"admin","password":"hunter2"}`
+	protected := p.Protect(value)
+	if strings.Contains(protected, "hunter2") || !strings.HasPrefix(protected, `"encrypted:hmac-sha256=`) {
+		t.Fatalf("Expected the value to be encrypted, got %s", protected)
+	}
+	again := p.Protect(value)
+	if again == protected {
+		t.Fatalf("Expected each encryption to use a new data key")
+	}
+	if Fingerprint(again) != Fingerprint(protected) {
+		t.Fatalf("Expected the fingerprints to match, got %s and %s", Fingerprint(again), Fingerprint(protected))
+	}
+	if Length(protected) != len(value) {
+		t.Fatalf("Expected length %d, got %d", len(value), Length(protected))
+	}
+
+	revealed, err := p.Reveal(protected)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if revealed != value {
+		t.Fatalf("Expected %s, got %s", value, revealed)
+	}
+
+	otherKey := base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+	other, _ := New(config.SensitiveConfig{Mode: ModeEncrypt, Key: otherKey})
+	if _, err := other.Reveal(protected); err == nil {
+		t.Fatalf("Expected an error with another key")
+	}
+}
@@ -23,6 +23,7 @@ import (
 type AWS struct {
 	svc           s3iface.S3API
 	dynamoSvc     dynamodbiface.DynamoDBAPI
+	endpoint      string
 	bucket        string
 	dynamoTable   string
 	keyPrefix     string
@@ -55,6 +56,7 @@ func NewAWS(aws config.AWSConfig, bucket config.S3BucketConfig, noLocks, noVersi
 
 	return &AWS{
 		svc:           s3.New(sess, awsConfig),
+		endpoint:      aws.Endpoint,
 		bucket:        bucket.Bucket,
 		keyPrefix:     bucket.KeyPrefix,
 		fileExtension: bucket.FileExtension,
@@ -65,6 +67,11 @@ func NewAWS(aws config.AWSConfig, bucket config.S3BucketConfig, noLocks, noVersi
 	}
 }
 
+// Identity returns the endpoint, bucket and key prefix of the States
+func (a *AWS) Identity() string {
+	return fmt.Sprintf("%s/%s/%s", a.endpoint, a.bucket, a.keyPrefix)
+}
+
 // NewAWSCollection instantiate all needed AWS objects configurated by the user and return a slice
 func NewAWSCollection(c *config.Config) []*AWS {
 	var awsInstances []*AWS
@@ -223,3 +230,26 @@ func (a *AWS) GetVersions(state string) (versions []Version, err error) {
 
 	return
 }
+
+// GetDeletion returns when a state was deleted, from the latest delete
+// marker of its key in a versioned bucket
+func (a *AWS) GetDeletion(st string) (deletedAt time.Time, ok bool, err error) {
+	if a.noVersioning {
+		return
+	}
+
+	result, err := a.svc.ListObjectVersions(&s3.ListObjectVersionsInput{
+		Bucket: aws_sdk.String(a.bucket),
+		Prefix: aws_sdk.String(st),
+	})
+	if err != nil {
+		return
+	}
+
+	for _, m := range result.DeleteMarkers {
+		if aws_sdk.StringValue(m.Key) == st && aws_sdk.BoolValue(m.IsLatest) && m.LastModified != nil {
+			return *m.LastModified, true, nil
+		}
+	}
+	return
+}
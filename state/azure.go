@@ -0,0 +1,345 @@
+package state
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	log "github.com/sirupsen/logrus"
+)
+
+// azureLockInfoMetaKey is the blob metadata key used by Terraform's azurerm
+// backend to store the (base64 encoded) lock information
+const azureLockInfoMetaKey = "terraformlockid"
+
+// Prefixes used to tell apart the different kinds of Azure blob versions
+const (
+	azureBlobVersion  = "version:"
+	azureBlobSnapshot = "snapshot:"
+	azureBlobETag     = "etag:"
+)
+
+// Azure is a state provider type, leveraging Azure Blob Storage
+type Azure struct {
+	svc           *azblob.Client
+	endpoint      string
+	containers    []string
+	keyPrefix     string
+	fileExtension []string
+	noLocks       bool
+	noVersioning  bool
+}
+
+// NewAzure creates an Azure object
+func NewAzure(az config.AzureConfig, noLocks, noVersioning bool) (*Azure, error) {
+	if len(az.Containers) == 0 {
+		return nil, nil
+	}
+
+	serviceURL := az.Endpoint
+	if serviceURL == "" {
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", az.StorageAccount)
+	}
+
+	var client *azblob.Client
+	var err error
+	if az.ConnectionString != "" {
+		client, err = azblob.NewClientFromConnectionString(az.ConnectionString, nil)
+	} else if az.AccessKey != "" {
+		var cred *azblob.SharedKeyCredential
+		cred, err = azblob.NewSharedKeyCredential(az.StorageAccount, az.AccessKey)
+		if err != nil {
+			return nil, err
+		}
+		client, err = azblob.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+	} else if az.SASToken != "" {
+		client, err = azblob.NewClientWithNoCredential(
+			fmt.Sprintf("%s?%s", serviceURL, strings.TrimPrefix(az.SASToken, "?")), nil)
+	} else {
+		log.WithFields(log.Fields{
+			"storage_account": az.StorageAccount,
+		}).Warn("No Azure credentials provided, using anonymous access")
+		client, err = azblob.NewClientWithNoCredential(serviceURL, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"storage_account": az.StorageAccount,
+		"containers":      az.Containers,
+	}).Info("Client successfully created")
+
+	return &Azure{
+		svc:           client,
+		endpoint:      strings.SplitN(client.URL(), "?", 2)[0], // without any SAS token
+		containers:    az.Containers,
+		keyPrefix:     az.KeyPrefix,
+		fileExtension: az.FileExtension,
+		noLocks:       noLocks,
+		noVersioning:  noVersioning,
+	}, nil
+}
+
+// Identity returns the endpoint, containers and key prefix of the States
+func (a *Azure) Identity() string {
+	return fmt.Sprintf("%s/%s/%s", a.endpoint, strings.Join(a.containers, ","), a.keyPrefix)
+}
+
+// NewAzureCollection instantiate all needed Azure objects configurated by the user and return a slice
+func NewAzureCollection(c *config.Config) ([]*Azure, error) {
+	var azureInstances []*Azure
+	for _, az := range c.Azure {
+		azureInstance, err := NewAzure(az, c.Provider.NoLocks, c.Provider.NoVersioning)
+		if err != nil {
+			return nil, err
+		}
+		if azureInstance != nil {
+			azureInstances = append(azureInstances, azureInstance)
+		}
+	}
+
+	return azureInstances, nil
+}
+
+// isStateBlob checks whether a blob name matches one of the state file extensions.
+// The azurerm backend stores workspaces as "<key>env:<workspace>" blobs.
+func (a *Azure) isStateBlob(name string) bool {
+	for _, ext := range a.fileExtension {
+		if strings.HasSuffix(name, ext) || strings.Contains(name, ext+"env:") {
+			return true
+		}
+	}
+	return false
+}
+
+// listBlobs returns all blob items of a container matching the given prefix
+func (a *Azure) listBlobs(ctx context.Context, containerName, prefix string, include azblob.ListBlobsInclude) (items []*container.BlobItem, err error) {
+	pager := a.svc.NewListBlobsFlatPager(containerName, &azblob.ListBlobsFlatOptions{
+		Prefix:  &prefix,
+		Include: include,
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return items, err
+		}
+		for _, item := range resp.Segment.BlobItems {
+			if item.Name == nil || (item.Deleted != nil && *item.Deleted) {
+				continue
+			}
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// splitAzurePath splits a state path into its container and blob names
+func splitAzurePath(st string) (containerName, blobName string, err error) {
+	i := strings.Index(st, "/")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid state path: %s", st)
+	}
+	return st[:i], st[i+1:], nil
+}
+
+// GetLocks returns a map of locks by State path
+func (a *Azure) GetLocks() (locks map[string]LockInfo, err error) {
+	locks = make(map[string]LockInfo)
+	if a.noLocks {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	for _, containerName := range a.containers {
+		items, err := a.listBlobs(ctx, containerName, a.keyPrefix, azblob.ListBlobsInclude{Metadata: true})
+		if err != nil {
+			return locks, err
+		}
+
+		for _, item := range items {
+			if !a.isStateBlob(*item.Name) || item.Properties == nil || item.Properties.LeaseState == nil ||
+				*item.Properties.LeaseState != lease.StateTypeLeased {
+				continue
+			}
+
+			path := strings.Join([]string{containerName, *item.Name}, "/")
+			info := LockInfo{
+				ID:        "N/A",
+				Operation: "N/A",
+				Info:      "N/A",
+				Who:       "N/A",
+				Version:   "N/A",
+				Created:   item.Properties.LastModified,
+				Path:      path,
+			}
+			for k, v := range item.Metadata {
+				if !strings.EqualFold(k, azureLockInfoMetaKey) || v == nil {
+					continue
+				}
+				data, err := base64.StdEncoding.DecodeString(*v)
+				if err != nil {
+					return locks, err
+				}
+				if err := json.Unmarshal(data, &info); err != nil {
+					return locks, err
+				}
+			}
+
+			locks[path] = info
+		}
+	}
+
+	return
+}
+
+// GetStates returns a slice of State files in the Azure containers
+func (a *Azure) GetStates() (states []string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	for _, containerName := range a.containers {
+		items, err := a.listBlobs(ctx, containerName, a.keyPrefix, azblob.ListBlobsInclude{})
+		if err != nil {
+			return states, err
+		}
+
+		for _, item := range items {
+			if a.isStateBlob(*item.Name) {
+				states = append(states, strings.Join([]string{containerName, *item.Name}, "/"))
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"containers": a.containers,
+		"prefix":     a.keyPrefix,
+		"states":     len(states),
+	}).Debug("Found states from Azure")
+
+	return states, nil
+}
+
+// GetVersions returns a slice of Version objects, made of the blob versions
+// and snapshots of a state
+func (a *Azure) GetVersions(state string) (versions []Version, err error) {
+	versions = []Version{}
+	if a.noVersioning {
+		versions = append(versions, Version{
+			ID:           state,
+			LastModified: time.Now(),
+		})
+		return
+	}
+
+	containerName, blobName, err := splitAzurePath(state)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	items, err := a.listBlobs(ctx, containerName, blobName, azblob.ListBlobsInclude{
+		Snapshots: true,
+		Versions:  true,
+	})
+	if err != nil {
+		return
+	}
+
+	for _, item := range items {
+		if *item.Name != blobName || item.Properties == nil {
+			continue
+		}
+
+		var id string
+		switch {
+		case item.VersionID != nil && *item.VersionID != "":
+			id = azureBlobVersion + *item.VersionID
+		case item.Snapshot != nil && *item.Snapshot != "":
+			id = azureBlobSnapshot + *item.Snapshot
+		case item.Properties.ETag != nil:
+			id = azureBlobETag + string(*item.Properties.ETag)
+		default:
+			continue
+		}
+
+		var lastModified time.Time
+		if item.Properties.LastModified != nil {
+			lastModified = *item.Properties.LastModified
+		}
+		versions = append(versions, Version{
+			ID:           id,
+			LastModified: lastModified,
+		})
+	}
+
+	return
+}
+
+// GetState retrieves a single State from Azure Blob Storage
+func (a *Azure) GetState(st, versionID string) (sf *statefile.File, err error) {
+	containerName, blobName, err := splitAzurePath(st)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	blobClient := a.svc.ServiceClient().NewContainerClient(containerName).NewBlobClient(blobName)
+	if versionID != "" && !a.noVersioning {
+		var c *blob.Client
+		switch {
+		case strings.HasPrefix(versionID, azureBlobVersion):
+			c, err = blobClient.WithVersionID(strings.TrimPrefix(versionID, azureBlobVersion))
+		case strings.HasPrefix(versionID, azureBlobSnapshot):
+			c, err = blobClient.WithSnapshot(strings.TrimPrefix(versionID, azureBlobSnapshot))
+		default:
+			c = blobClient
+		}
+		if err != nil {
+			return nil, err
+		}
+		blobClient = c
+	}
+
+	resp, err := blobClient.DownloadStream(ctx, nil)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":       st,
+			"version_id": versionID,
+			"error":      err,
+		}).Error("Error retrieving state from Azure")
+		errObj := make(map[string]string)
+		errObj["error"] = fmt.Sprintf("State file not found: %v", st)
+		errObj["details"] = fmt.Sprintf("%v", err)
+		j, _ := json.Marshal(errObj)
+		return sf, fmt.Errorf("%s", string(j))
+	}
+	defer resp.Body.Close()
+
+	sf, err = statefile.Read(resp.Body)
+	if sf == nil || err != nil {
+		return sf, fmt.Errorf("Failed to find state: %v", err)
+	}
+
+	log.WithFields(log.Fields{
+		"path":       st,
+		"version_id": versionID,
+	}).Info("State read from Azure")
+
+	return
+}
@@ -0,0 +1,156 @@
+package state
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/camptocamp/terraboard/config"
+)
+
+const azureTestLockInfo = `{"ID":"lock-id","Operation":"OperationTypeApply","Who":"user@host","Version":"1.0.0","Path":"tfstate/prod.terraform.tfstate"}`
+
+// newAzuriteServer starts a minimal Azurite-like stand-in serving
+// the "tfstate" container of the "devstoreaccount1" account
+func newAzuriteServer(t *testing.T) *httptest.Server {
+	lockInfo := base64.StdEncoding.EncodeToString([]byte(azureTestLockInfo))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/devstoreaccount1/tfstate") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("comp") == "list" {
+			w.Header().Set("Content-Type", "application/xml")
+			include := r.URL.Query().Get("include")
+			var blobs string
+			if strings.Contains(include, "versions") {
+				blobs = `
+<Blob><Name>prod.terraform.tfstate</Name><VersionId>2021-01-01T00:00:00.0000000Z</VersionId><Properties><Last-Modified>Fri, 01 Jan 2021 00:00:00 GMT</Last-Modified><Etag>0x1</Etag><BlobType>BlockBlob</BlobType></Properties></Blob>
+<Blob><Name>prod.terraform.tfstate</Name><VersionId>2021-01-02T00:00:00.0000000Z</VersionId><IsCurrentVersion>true</IsCurrentVersion><Properties><Last-Modified>Sat, 02 Jan 2021 00:00:00 GMT</Last-Modified><Etag>0x2</Etag><BlobType>BlockBlob</BlobType></Properties></Blob>
+<Blob><Name>prod.terraform.tfstate</Name><Snapshot>2021-01-01T12:00:00.0000000Z</Snapshot><Properties><Last-Modified>Fri, 01 Jan 2021 00:00:00 GMT</Last-Modified><Etag>0x1</Etag><BlobType>BlockBlob</BlobType></Properties></Blob>`
+			} else {
+				blobs = fmt.Sprintf(`
+<Blob><Name>prod.terraform.tfstate</Name><Properties><Last-Modified>Sat, 02 Jan 2021 00:00:00 GMT</Last-Modified><Etag>0x2</Etag><BlobType>BlockBlob</BlobType><LeaseStatus>locked</LeaseStatus><LeaseState>leased</LeaseState></Properties><Metadata><terraformlockid>%s</terraformlockid></Metadata></Blob>
+<Blob><Name>prod.terraform.tfstateenv:staging</Name><Properties><Last-Modified>Sat, 02 Jan 2021 00:00:00 GMT</Last-Modified><Etag>0x3</Etag><BlobType>BlockBlob</BlobType><LeaseStatus>unlocked</LeaseStatus><LeaseState>available</LeaseState></Properties></Blob>
+<Blob><Name>README.md</Name><Properties><Last-Modified>Sat, 02 Jan 2021 00:00:00 GMT</Last-Modified><Etag>0x4</Etag><BlobType>BlockBlob</BlobType></Properties></Blob>`, lockInfo)
+			}
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ServiceEndpoint="http://%s/devstoreaccount1" ContainerName="tfstate"><Blobs>%s</Blobs><NextMarker /></EnumerationResults>`, r.Host, blobs)
+			return
+		}
+
+		serial := 3
+		if r.URL.Query().Get("versionid") == "2021-01-01T00:00:00.0000000Z" || r.URL.Query().Get("snapshot") != "" {
+			serial = 2
+		}
+		body := fmt.Sprintf(`{"version": 4, "serial": %d, "lineage": "azure-lineage", "terraform_version": "1.0.0"}`, serial)
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		fmt.Fprint(w, body)
+	}))
+}
+
+func newAzureTestInstance(t *testing.T, ts *httptest.Server, noVersioning bool) *Azure {
+	azureInstance, err := NewAzure(config.AzureConfig{
+		StorageAccount: "devstoreaccount1",
+		AccessKey:      base64.StdEncoding.EncodeToString([]byte("azurite-key")),
+		Endpoint:       ts.URL + "/devstoreaccount1",
+		Containers:     []string{"tfstate"},
+		FileExtension:  []string{".tfstate"},
+	}, false, noVersioning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return azureInstance
+}
+
+func TestNewAzureNoContainer(t *testing.T) {
+	azureInstance, err := NewAzure(config.AzureConfig{StorageAccount: "test"}, false, false)
+	if err != nil || azureInstance != nil {
+		t.Error("Azure instance should be nil")
+	}
+}
+
+func TestAzureGetStates(t *testing.T) {
+	ts := newAzuriteServer(t)
+	defer ts.Close()
+
+	states, err := newAzureTestInstance(t, ts, false).GetStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("Expected 2 states, got %v", states)
+	}
+	if states[1] != "tfstate/prod.terraform.tfstateenv:staging" {
+		t.Errorf("Expected workspace state, got %s", states[1])
+	}
+}
+
+func TestAzureGetVersions(t *testing.T) {
+	ts := newAzuriteServer(t)
+	defer ts.Close()
+	azureInstance := newAzureTestInstance(t, ts, false)
+
+	versions, err := azureInstance.GetVersions("tfstate/prod.terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("Expected 3 versions, got %v", versions)
+	}
+	if versions[2].ID != "snapshot:2021-01-01T12:00:00.0000000Z" {
+		t.Errorf("Unexpected snapshot version ID %s", versions[2].ID)
+	}
+
+	for _, v := range []struct {
+		id     string
+		serial uint64
+	}{
+		{versions[0].ID, 2},
+		{versions[1].ID, 3},
+		{versions[2].ID, 2},
+	} {
+		sf, err := azureInstance.GetState("tfstate/prod.terraform.tfstate", v.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sf.Serial != v.serial {
+			t.Errorf("Expected serial %d for version %s, got %d", v.serial, v.id, sf.Serial)
+		}
+	}
+}
+
+func TestAzureGetVersionsNoVersioning(t *testing.T) {
+	ts := newAzuriteServer(t)
+	defer ts.Close()
+
+	versions, err := newAzureTestInstance(t, ts, true).GetVersions("tfstate/prod.terraform.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].ID != "tfstate/prod.terraform.tfstate" {
+		t.Errorf("Expected a single version identified by the state path, got %v", versions)
+	}
+}
+
+func TestAzureGetLocks(t *testing.T) {
+	ts := newAzuriteServer(t)
+	defer ts.Close()
+
+	locks, err := newAzureTestInstance(t, ts, false).GetLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 {
+		t.Fatalf("Expected one lock, got %v", locks)
+	}
+	lock := locks["tfstate/prod.terraform.tfstate"]
+	if lock.ID != "lock-id" || lock.Who != "user@host" {
+		t.Errorf("Unexpected lock info %v", lock)
+	}
+}
@@ -0,0 +1,284 @@
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5" // #nosec G501 -- Terraform's consul backend uses MD5 to check chunked payloads
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	consul "github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Keys written next to a state by Terraform's consul backend
+const (
+	consulLockSuffix     = "/.lock"
+	consulLockInfoSuffix = "/.lockinfo"
+)
+
+// consulChunkKey matches the keys of a chunked state payload
+var consulChunkKey = regexp.MustCompile(`/tfstate\.[0-9a-f]+/[0-9]+$`)
+
+// Consul is a state provider type, leveraging Consul KV
+type Consul struct {
+	kv           *consul.KV
+	address      string
+	datacenter   string
+	prefix       string
+	noLocks      bool
+	noVersioning bool
+}
+
+// NewConsul creates a Consul object
+func NewConsul(c config.ConsulConfig, noLocks, noVersioning bool) (*Consul, error) {
+	if c.Address == "" {
+		return nil, nil
+	}
+
+	consulConfig := consul.DefaultConfig()
+	consulConfig.Address = c.Address
+	if c.Token != "" {
+		consulConfig.Token = c.Token
+	}
+	if c.Datacenter != "" {
+		consulConfig.Datacenter = c.Datacenter
+	}
+
+	client, err := consul.NewClient(consulConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"address": c.Address,
+		"prefix":  c.Prefix,
+	}).Info("Client successfully created")
+
+	return &Consul{
+		kv:           client.KV(),
+		address:      c.Address,
+		datacenter:   c.Datacenter,
+		prefix:       c.Prefix,
+		noLocks:      noLocks,
+		noVersioning: noVersioning,
+	}, nil
+}
+
+// Identity returns the address, datacenter and prefix of the States
+func (c *Consul) Identity() string {
+	return fmt.Sprintf("%s/%s/%s", c.address, c.datacenter, c.prefix)
+}
+
+// NewConsulCollection instantiate all needed Consul objects configurated by the user and return a slice
+func NewConsulCollection(c *config.Config) ([]*Consul, error) {
+	var consulInstances []*Consul
+	for _, cc := range c.Consul {
+		consulInstance, err := NewConsul(cc, c.Provider.NoLocks, c.Provider.NoVersioning)
+		if err != nil {
+			return nil, err
+		}
+		if consulInstance != nil {
+			consulInstances = append(consulInstances, consulInstance)
+		}
+	}
+
+	return consulInstances, nil
+}
+
+// GetLocks returns a map of locks by State path
+func (c *Consul) GetLocks() (locks map[string]LockInfo, err error) {
+	locks = make(map[string]LockInfo)
+	if c.noLocks {
+		return
+	}
+
+	pairs, _, err := c.kv.List(c.prefix, nil)
+	if err != nil {
+		return locks, err
+	}
+
+	held := make(map[string]bool)
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, consulLockSuffix) && pair.Session != "" {
+			held[strings.TrimSuffix(pair.Key, consulLockSuffix)] = true
+		}
+	}
+
+	for _, pair := range pairs {
+		if !strings.HasSuffix(pair.Key, consulLockInfoSuffix) {
+			continue
+		}
+
+		path := strings.TrimSuffix(pair.Key, consulLockInfoSuffix)
+		var info LockInfo
+		if err := json.Unmarshal(pair.Value, &info); err != nil {
+			return locks, err
+		}
+		locks[path] = info
+		delete(held, path)
+	}
+
+	// Locks held through a session without any lock information
+	for path := range held {
+		locks[path] = LockInfo{
+			ID:        "N/A",
+			Operation: "N/A",
+			Info:      "N/A",
+			Who:       "N/A",
+			Version:   "N/A",
+			Path:      path,
+		}
+	}
+
+	return
+}
+
+// isStateKey checks that a KV key is neither a lock nor a chunk of a state payload
+func isStateKey(key string) bool {
+	return !strings.HasSuffix(key, "/") &&
+		!strings.HasSuffix(key, consulLockSuffix) &&
+		!strings.HasSuffix(key, consulLockInfoSuffix) &&
+		!consulChunkKey.MatchString(key)
+}
+
+// GetStates returns a slice of State keys found under the Consul KV prefix
+func (c *Consul) GetStates() (states []string, err error) {
+	keys, _, err := c.kv.Keys(c.prefix, "", nil)
+	if err != nil {
+		return states, err
+	}
+
+	for _, key := range keys {
+		if isStateKey(key) {
+			states = append(states, key)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"prefix": c.prefix,
+		"states": len(states),
+	}).Debug("Found states from Consul")
+
+	return states, nil
+}
+
+// consulVersionID builds a Version ID from a KV ModifyIndex.
+// Consul keeps no history, so each new index is recorded as a new version.
+func consulVersionID(path string, modifyIndex uint64) string {
+	return fmt.Sprintf("%s@%d", path, modifyIndex)
+}
+
+// GetVersions returns a slice of Version objects, containing only
+// the current state since Consul doesn't keep any history
+func (c *Consul) GetVersions(state string) (versions []Version, err error) {
+	versions = []Version{}
+	if c.noVersioning {
+		versions = append(versions, Version{
+			ID:           state,
+			LastModified: time.Now(),
+		})
+		return
+	}
+
+	pair, _, err := c.kv.Get(state, nil)
+	if err != nil || pair == nil {
+		return
+	}
+
+	versions = append(versions, Version{
+		ID:           consulVersionID(state, pair.ModifyIndex),
+		LastModified: time.Now(),
+	})
+
+	return
+}
+
+// chunkedPayload is the payload written by the consul backend
+// when a state is split in multiple chunks
+type chunkedPayload struct {
+	CurrentHash string   `json:"current-hash"`
+	Chunks      []string `json:"chunks"`
+}
+
+// readPayload returns the raw state payload of a KV pair,
+// reassembling chunks and uncompressing it if needed
+func (c *Consul) readPayload(pair *consul.KVPair) (payload []byte, err error) {
+	var chunked chunkedPayload
+	if err := json.Unmarshal(pair.Value, &chunked); err == nil && chunked.CurrentHash != "" {
+		for _, key := range chunked.Chunks {
+			chunk, _, err := c.kv.Get(key, nil)
+			if err != nil {
+				return nil, err
+			}
+			if chunk == nil {
+				return nil, fmt.Errorf("missing chunk %s for state %s", key, pair.Key)
+			}
+			payload = append(payload, chunk.Value...)
+		}
+	} else {
+		payload = pair.Value
+	}
+
+	// Gzip compressed payloads start with 0x1f
+	if len(payload) > 0 && payload[0] == '\x1f' {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		if payload, err = io.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	if chunked.CurrentHash != "" {
+		if sum := fmt.Sprintf("%x", md5.Sum(payload)); sum != chunked.CurrentHash { // #nosec G401
+			return nil, fmt.Errorf("state %s checksum mismatch: expected %s, got %s", pair.Key, chunked.CurrentHash, sum)
+		}
+	}
+
+	return payload, nil
+}
+
+// GetState retrieves a single State from Consul KV
+func (c *Consul) GetState(st, versionID string) (sf *statefile.File, err error) {
+	pair, _, err := c.kv.Get(st, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("State file not found: %v", st)
+	}
+
+	if versionID != "" && versionID != st && !c.noVersioning {
+		if current := consulVersionID(st, pair.ModifyIndex); current != versionID {
+			return nil, fmt.Errorf("version %s of state %s is no longer available (current version is %s)",
+				versionID, st, current)
+		}
+	}
+
+	payload, err := c.readPayload(pair)
+	if err != nil {
+		return nil, err
+	}
+
+	sf, err = statefile.Read(bytes.NewReader(payload))
+	if sf == nil || err != nil {
+		return sf, fmt.Errorf("Failed to find state: %v", err)
+	}
+
+	log.WithFields(log.Fields{
+		"path":         st,
+		"modify_index": strconv.FormatUint(pair.ModifyIndex, 10),
+	}).Info("State read from Consul")
+
+	return
+}
@@ -0,0 +1,205 @@
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5" // #nosec G501
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/camptocamp/terraboard/config"
+)
+
+const consulTestState = `{"version": 4, "serial": 3, "lineage": "consul-lineage", "terraform_version": "1.0.0"}`
+
+// consulTestPair is a KV pair as returned by the Consul HTTP API
+type consulTestPair struct {
+	Key         string
+	Value       []byte
+	ModifyIndex uint64
+	Session     string `json:",omitempty"`
+}
+
+// newConsulServer starts a minimal Consul KV stand-in serving the given pairs
+func newConsulServer(t *testing.T, pairs []consulTestPair) *httptest.Server {
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		query := r.URL.Query()
+
+		var matches []consulTestPair
+		for _, p := range pairs {
+			if (query.Has("keys") || query.Has("recurse")) && strings.HasPrefix(p.Key, key) || p.Key == key {
+				matches = append(matches, p)
+			}
+		}
+		if len(matches) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Consul-Index", "1")
+		if query.Has("keys") {
+			var keys []string
+			for _, p := range matches {
+				keys = append(keys, p.Key)
+			}
+			_ = json.NewEncoder(w).Encode(keys)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(matches)
+	}))
+}
+
+func newConsulTestInstance(t *testing.T, ts *httptest.Server, noVersioning bool) *Consul {
+	consulInstance, err := NewConsul(config.ConsulConfig{
+		Address: strings.TrimPrefix(ts.URL, "http://"),
+		Prefix:  "terraform/",
+	}, false, noVersioning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return consulInstance
+}
+
+func consulChunkedTestPairs(t *testing.T) []consulTestPair {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(consulTestState)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed := buf.Bytes()
+	half := len(compressed) / 2
+
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(consulTestState))) // #nosec G401
+	chunks := []string{
+		"terraform/chunked/tfstate." + hash + "/0",
+		"terraform/chunked/tfstate." + hash + "/1",
+	}
+	payload, _ := json.Marshal(chunkedPayload{CurrentHash: hash, Chunks: chunks})
+
+	return []consulTestPair{
+		{Key: "terraform/chunked", Value: payload, ModifyIndex: 12},
+		{Key: chunks[0], Value: compressed[:half], ModifyIndex: 11},
+		{Key: chunks[1], Value: compressed[half:], ModifyIndex: 11},
+	}
+}
+
+func TestNewConsulNoAddress(t *testing.T) {
+	consulInstance, err := NewConsul(config.ConsulConfig{Prefix: "terraform/"}, false, false)
+	if err != nil || consulInstance != nil {
+		t.Error("Consul instance should be nil")
+	}
+}
+
+func TestConsulGetStates(t *testing.T) {
+	pairs := append(consulChunkedTestPairs(t),
+		consulTestPair{Key: "terraform/prod", Value: []byte(consulTestState), ModifyIndex: 42},
+		consulTestPair{Key: "terraform/prod/.lock", ModifyIndex: 43},
+		consulTestPair{Key: "terraform/prod/.lockinfo", Value: []byte(`{}`), ModifyIndex: 43},
+		consulTestPair{Key: "terraform/folder/", ModifyIndex: 1},
+	)
+	ts := newConsulServer(t, pairs)
+	defer ts.Close()
+
+	states, err := newConsulTestInstance(t, ts, false).GetStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0] != "terraform/chunked" || states[1] != "terraform/prod" {
+		t.Errorf("Expected chunked and prod states, got %v", states)
+	}
+}
+
+func TestConsulGetVersions(t *testing.T) {
+	ts := newConsulServer(t, append(consulChunkedTestPairs(t),
+		consulTestPair{Key: "terraform/prod", Value: []byte(consulTestState), ModifyIndex: 42},
+	))
+	defer ts.Close()
+	consulInstance := newConsulTestInstance(t, ts, false)
+
+	versions, err := consulInstance.GetVersions("terraform/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].ID != "terraform/prod@42" {
+		t.Fatalf("Expected a single version bound to the modify index, got %v", versions)
+	}
+
+	sf, err := consulInstance.GetState("terraform/prod", versions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.Serial != 3 {
+		t.Errorf("Expected serial 3, got %d", sf.Serial)
+	}
+
+	if _, err := consulInstance.GetState("terraform/prod", "terraform/prod@41"); err == nil {
+		t.Error("Expected an error for an outdated version")
+	}
+
+	sf, err = consulInstance.GetState("terraform/chunked", "terraform/chunked@12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.Lineage != "consul-lineage" {
+		t.Errorf("Expected chunked state to be reassembled, got lineage %s", sf.Lineage)
+	}
+}
+
+func TestConsulGetVersionsNoVersioning(t *testing.T) {
+	ts := newConsulServer(t, []consulTestPair{
+		{Key: "terraform/prod", Value: []byte(consulTestState), ModifyIndex: 42},
+	})
+	defer ts.Close()
+
+	versions, err := newConsulTestInstance(t, ts, true).GetVersions("terraform/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].ID != "terraform/prod" {
+		t.Errorf("Expected a single version identified by the state path, got %v", versions)
+	}
+}
+
+func TestConsulGetLocks(t *testing.T) {
+	ts := newConsulServer(t, []consulTestPair{
+		{Key: "terraform/prod", Value: []byte(consulTestState), ModifyIndex: 42},
+		{Key: "terraform/prod/.lock", ModifyIndex: 43, Session: "session-id"},
+		{Key: "terraform/prod/.lockinfo", ModifyIndex: 43,
+			Value: []byte(`{"ID":"lock-id","Operation":"OperationTypeApply","Who":"user@host","Version":"1.0.0","Path":"terraform/prod"}`)},
+		{Key: "terraform/staging", Value: []byte(consulTestState), ModifyIndex: 44},
+		{Key: "terraform/staging/.lock", ModifyIndex: 45, Session: "other-session"},
+	})
+	defer ts.Close()
+	consulInstance := newConsulTestInstance(t, ts, false)
+
+	locks, err := consulInstance.GetLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 2 {
+		t.Fatalf("Expected two locks, got %v", locks)
+	}
+	if locks["terraform/prod"].Who != "user@host" {
+		t.Errorf("Unexpected lock info %v", locks["terraform/prod"])
+	}
+	if locks["terraform/staging"].ID != "N/A" {
+		t.Errorf("Expected a placeholder lock for a session without lock info, got %v", locks["terraform/staging"])
+	}
+
+	consulInstance.noLocks = true
+	locks, _ = consulInstance.GetLocks()
+	if len(locks) != 0 {
+		t.Error("Locks should be empty due to noLocks option")
+	}
+}
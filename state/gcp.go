@@ -67,6 +67,11 @@ func NewGCP(gcp config.GCPConfig, noLocks, noVersioning bool) (*GCP, error) {
 	return gcpInstance, nil
 }
 
+// Identity returns the buckets of the States
+func (a *GCP) Identity() string {
+	return strings.Join(a.buckets, ",")
+}
+
 // NewGCPCollection instantiate all needed GCP objects configurated by the user and return a slice
 func NewGCPCollection(c *config.Config) ([]*GCP, error) {
 	var gcpInstances []*GCP
@@ -35,6 +35,11 @@ func NewGitlab(gl config.GitlabConfig, noLocks, noVersioning bool) *Gitlab {
 	return instance
 }
 
+// Identity returns the address of the Gitlab instance
+func (g *Gitlab) Identity() string {
+	return g.Client.Endpoint
+}
+
 // NewGitlabCollection instantiate all needed Gitlab objects configurated by the user and return a slice
 func NewGitlabCollection(c *config.Config) []*Gitlab {
 	var gitlabInstances []*Gitlab
@@ -0,0 +1,441 @@
+package state
+
+import (
+	"bytes"
+	"crypto/md5" // #nosec G501 -- only used to identify state contents
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-cleanhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// httpStatePlaceholder is replaced by the state names in the http backend addresses
+const httpStatePlaceholder = "{state}"
+
+// HTTP is a state provider type, leveraging Terraform's generic http backend
+type HTTP struct {
+	client          *http.Client
+	address         string
+	states          []string
+	lockInfoAddress string
+	lockAddress     string
+	probeLocks      bool
+	probeInterval   time.Duration
+	lockMethod      string
+	unlockAddress   string
+	unlockMethod    string
+	username        string
+	password        string
+	headers         map[string]string
+	noLocks         bool
+	noVersioning    bool
+
+	probeMu sync.Mutex
+	probed  time.Time
+	probes  map[string]LockInfo // locks found by the latest probe
+
+	bodiesMu sync.Mutex
+	bodies   map[string]httpBody // latest body fetched for each state, until read
+}
+
+// httpBody is the content of a state fetched to identify its version
+type httpBody struct {
+	versionID string
+	data      []byte
+}
+
+// NewHTTP creates an HTTP object
+func NewHTTP(h config.HTTPConfig, noLocks, noVersioning bool) *HTTP {
+	if h.Address == "" {
+		return nil
+	}
+
+	client := cleanhttp.DefaultPooledClient()
+	client.Timeout = time.Second * 60
+	if h.SkipCertVerification {
+		client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true, // #nosec G402 -- explicitly requested by the user
+		}
+	}
+
+	if strings.Contains(h.Address, httpStatePlaceholder) && len(h.States) == 0 {
+		log.WithFields(log.Fields{
+			"address": h.Address,
+		}).Warn("HTTP backend address contains a {state} placeholder but no states are configured")
+	}
+	if !noLocks && h.LockInfoAddress == "" && !h.ProbeLocks {
+		log.WithFields(log.Fields{
+			"address": h.Address,
+		}).Warn("HTTP backend has no lock info address and lock probing is disabled, its locks are unknown")
+	}
+
+	return &HTTP{
+		client:          client,
+		address:         h.Address,
+		states:          h.States,
+		lockInfoAddress: h.LockInfoAddress,
+		lockAddress:     h.LockAddress,
+		probeLocks:      h.ProbeLocks,
+		probeInterval:   time.Duration(h.ProbeInterval) * time.Second,
+		lockMethod:      h.LockMethod,
+		unlockAddress:   h.UnlockAddress,
+		unlockMethod:    h.UnlockMethod,
+		username:        h.Username,
+		password:        h.Password,
+		headers:         h.Headers,
+		noLocks:         noLocks,
+		noVersioning:    noVersioning,
+		bodies:          make(map[string]httpBody),
+	}
+}
+
+// Identity returns the address and names of the States
+func (h *HTTP) Identity() string {
+	return fmt.Sprintf("%s/%s", h.address, strings.Join(h.states, ","))
+}
+
+// NewHTTPCollection instantiate all needed HTTP objects configurated by the user and return a slice
+func NewHTTPCollection(c *config.Config) []*HTTP {
+	var httpInstances []*HTTP
+	for _, h := range c.HTTP {
+		if httpInstance := NewHTTP(h, c.Provider.NoLocks, c.Provider.NoVersioning); httpInstance != nil {
+			httpInstances = append(httpInstances, httpInstance)
+		}
+	}
+
+	return httpInstances
+}
+
+// expandHTTPAddress replaces the {state} placeholder of an address template
+func expandHTTPAddress(template, name string) string {
+	return strings.ReplaceAll(template, httpStatePlaceholder, name)
+}
+
+// statePath returns the path of a state: its name, or the path of the
+// address when it has no {state} placeholder. The host, credentials and
+// query of the address are left out, as they may hold secrets.
+func (h *HTTP) statePath(name string) string {
+	if name != "" {
+		return name
+	}
+	u, err := url.Parse(h.address)
+	if err != nil || u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// stateAddress returns the address of a state given its path
+func (h *HTTP) stateAddress(path string) string {
+	if !strings.Contains(h.address, httpStatePlaceholder) {
+		return h.address
+	}
+	return expandHTTPAddress(h.address, path)
+}
+
+// names returns the state names to expand the address templates with
+func (h *HTTP) names() []string {
+	if !strings.Contains(h.address, httpStatePlaceholder) {
+		return []string{""}
+	}
+	return h.states
+}
+
+// do sends a request to the http backend with the configured credentials and headers
+func (h *HTTP) do(method, address string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, address, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	if h.username != "" || h.password != "" {
+		req.SetBasicAuth(h.username, h.password)
+	}
+
+	return h.client.Do(req)
+}
+
+// GetLocks returns a map of locks by State path.
+// Locks are read from the lock info address when configured. Otherwise,
+// when probing is enabled, they are probed by acquiring and releasing the
+// lock of each state, a 423 (Locked) or 409 (Conflict) response meaning the
+// state is locked, at most once per probe interval. The locks are unknown,
+// and none is returned, when neither is set.
+func (h *HTTP) GetLocks() (locks map[string]LockInfo, err error) {
+	locks = make(map[string]LockInfo)
+	if h.noLocks {
+		return
+	}
+	if h.lockInfoAddress == "" {
+		if !h.probeLocks || h.lockAddress == "" || h.unlockAddress == "" {
+			return
+		}
+		return h.getProbedLocks()
+	}
+
+	for _, name := range h.names() {
+		info, err := h.getLockInfo(expandHTTPAddress(h.lockInfoAddress, name))
+		if err != nil {
+			return locks, err
+		}
+		h.addLock(locks, name, info)
+	}
+
+	return
+}
+
+// addLock adds the lock of a state, if any, to the locks by State path
+func (h *HTTP) addLock(locks map[string]LockInfo, name string, info *LockInfo) {
+	if info == nil {
+		return
+	}
+	path := h.statePath(name)
+	if info.Path == "" {
+		info.Path = path
+	}
+	locks[path] = *info
+}
+
+// getProbedLocks returns the locks found by the latest probe, probing
+// them again once the probe interval has elapsed
+func (h *HTTP) getProbedLocks() (map[string]LockInfo, error) {
+	h.probeMu.Lock()
+	defer h.probeMu.Unlock()
+
+	if h.probes == nil || time.Since(h.probed) >= h.probeInterval {
+		probes := make(map[string]LockInfo)
+		for _, name := range h.names() {
+			info, err := h.probeLock(expandHTTPAddress(h.lockAddress, name), expandHTTPAddress(h.unlockAddress, name))
+			if err != nil {
+				return make(map[string]LockInfo), err
+			}
+			h.addLock(probes, name, info)
+		}
+		h.probes = probes
+		h.probed = time.Now()
+	}
+
+	locks := make(map[string]LockInfo, len(h.probes))
+	for path, info := range h.probes {
+		locks[path] = info
+	}
+	return locks, nil
+}
+
+// decodeLockInfo decodes the lock information returned by the http backend,
+// falling back to placeholder values when the body isn't a lock info object
+func decodeLockInfo(body []byte) *LockInfo {
+	info := LockInfo{
+		ID:        "N/A",
+		Operation: "N/A",
+		Info:      "N/A",
+		Who:       "N/A",
+		Version:   "N/A",
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &info); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Debug("Failed to decode lock info from http backend")
+		}
+	}
+	return &info
+}
+
+// getLockInfo reads the lock information of a state.
+// An empty body or a 204/404 response means the state isn't locked.
+func (h *HTTP) getLockInfo(address string) (*LockInfo, error) {
+	resp, err := h.do(http.MethodGet, address, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if len(bytes.TrimSpace(body)) == 0 {
+			return nil, nil
+		}
+		return decodeLockInfo(body), nil
+	case http.StatusLocked, http.StatusConflict:
+		return decodeLockInfo(body), nil
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected HTTP response code %d from %s", resp.StatusCode, address)
+	}
+}
+
+// probeLock tries to lock a state, releasing the lock right away on success
+func (h *HTTP) probeLock(lockAddress, unlockAddress string) (*LockInfo, error) {
+	probe, err := json.Marshal(LockInfo{
+		ID:        uuid.New().String(),
+		Operation: "OperationTypeInvalid",
+		Info:      "Terraboard lock probe",
+		Who:       "terraboard",
+		Version:   "N/A",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.do(h.lockMethod, lockAddress, probe)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		unlock, err := h.do(h.unlockMethod, unlockAddress, probe)
+		if err != nil {
+			return nil, err
+		}
+		unlock.Body.Close()
+		if unlock.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to release lock probe on %s: HTTP response code %d", unlockAddress, unlock.StatusCode)
+		}
+		return nil, nil
+	case http.StatusLocked, http.StatusConflict:
+		return decodeLockInfo(body), nil
+	default:
+		return nil, fmt.Errorf("unexpected HTTP response code %d from %s", resp.StatusCode, lockAddress)
+	}
+}
+
+// GetStates returns a slice of State addresses
+func (h *HTTP) GetStates() (states []string, err error) {
+	for _, name := range h.names() {
+		states = append(states, h.statePath(name))
+	}
+
+	log.WithFields(log.Fields{
+		"address": h.statePath(""),
+		"states":  len(states),
+	}).Debug("Found states from HTTP backend")
+
+	return states, nil
+}
+
+// fetch retrieves the raw content of a state
+func (h *HTTP) fetch(st string) ([]byte, error) {
+	resp, err := h.do(http.MethodGet, h.stateAddress(st), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP response code %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// GetVersions returns a slice of Version objects, containing only
+// the current state since the http backend doesn't expose any history.
+// The Version ID is made of the path and the MD5 checksum of the state content.
+func (h *HTTP) GetVersions(state string) (versions []Version, err error) {
+	versions = []Version{}
+	if h.noVersioning {
+		versions = append(versions, Version{
+			ID:           state,
+			LastModified: time.Now(),
+		})
+		return
+	}
+
+	data, err := h.fetch(state)
+	if err != nil {
+		return
+	}
+
+	id := checksumVersionID(state, fmt.Sprintf("%x", md5.Sum(data))) // #nosec G401
+	versions = append(versions, Version{
+		ID:           id,
+		LastModified: time.Now(),
+	})
+
+	// Keep the body, so that GetState doesn't fetch it again
+	h.bodiesMu.Lock()
+	defer h.bodiesMu.Unlock()
+	h.bodies[state] = httpBody{versionID: id, data: data}
+
+	return
+}
+
+// takeBody returns the body fetched by GetVersions for a version of a state,
+// if it is still the latest one fetched, forgetting it
+func (h *HTTP) takeBody(st, versionID string) ([]byte, bool) {
+	h.bodiesMu.Lock()
+	defer h.bodiesMu.Unlock()
+	body, ok := h.bodies[st]
+	if !ok || body.versionID != versionID {
+		return nil, false
+	}
+	delete(h.bodies, st)
+	return body.data, true
+}
+
+// GetState retrieves a single State from the http backend
+func (h *HTTP) GetState(st, versionID string) (sf *statefile.File, err error) {
+	data, ok := h.takeBody(st, versionID)
+	if !ok {
+		data, err = h.fetch(st)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  st,
+			"error": err,
+		}).Error("Error retrieving state from HTTP backend")
+		errObj := make(map[string]string)
+		errObj["error"] = fmt.Sprintf("State file not found: %v", st)
+		errObj["details"] = fmt.Sprintf("%v", err)
+		j, _ := json.Marshal(errObj)
+		return sf, fmt.Errorf("%s", string(j))
+	}
+
+	current := checksumVersionID(st, fmt.Sprintf("%x", md5.Sum(data))) // #nosec G401
+	if versionID != "" && versionID != st && !h.noVersioning && versionID != current {
+		return nil, fmt.Errorf("version %s of state %s is no longer available (current version is %s)",
+			versionID, st, current)
+	}
+
+	sf, err = statefile.Read(bytes.NewReader(data))
+	if sf == nil || err != nil {
+		return sf, fmt.Errorf("Failed to find state: %v", err)
+	}
+
+	log.WithFields(log.Fields{
+		"path":       st,
+		"version_id": current,
+	}).Info("State read from HTTP backend")
+
+	return
+}
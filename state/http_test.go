@@ -0,0 +1,250 @@
+package state
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+)
+
+const httpTestLockInfo = `{"ID":"lock-id","Operation":"OperationTypeApply","Who":"user@host","Version":"1.0.0"}`
+
+// newHTTPBackendServer starts a minimal http backend stand-in serving
+// the "network" and "dns" states, "network" being locked, and
+// "dns-copy", a copy of "dns"
+func newHTTPBackendServer(t *testing.T) *httptest.Server {
+	ts, _ := newCountingHTTPBackendServer(t)
+	return ts
+}
+
+// newCountingHTTPBackendServer starts the http backend stand-in of
+// newHTTPBackendServer, counting the lock requests it receives
+func newCountingHTTPBackendServer(t *testing.T) (*httptest.Server, func() int) {
+	var mu sync.Mutex
+	probes := map[string]bool{}
+	lockRequests := 0
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return lockRequests
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "terraboard" || pass != "secret" || r.Header.Get("X-Team") != "platform" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		name := strings.Split(strings.TrimPrefix(r.URL.Path, "/states/"), "/")[0]
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/lock") && r.Method == http.MethodGet:
+			if name == "network" {
+				fmt.Fprint(w, httpTestLockInfo)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/lock") && r.Method == "LOCK":
+			lockRequests++
+			if name == "network" {
+				w.WriteHeader(http.StatusLocked)
+				fmt.Fprint(w, httpTestLockInfo)
+				return
+			}
+			probes[name] = true
+		case strings.HasSuffix(r.URL.Path, "/lock") && r.Method == "UNLOCK":
+			if !probes[name] {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			delete(probes, name)
+		case r.Method == http.MethodGet && (name == "network" || name == "dns" || name == "dns-copy"):
+			fmt.Fprintf(w, `{"version": 4, "serial": 3, "lineage": "%s-lineage", "terraform_version": "1.0.0"}`, strings.TrimSuffix(name, "-copy"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})), count
+}
+
+func newHTTPTestConfig(ts *httptest.Server) config.HTTPConfig {
+	return config.HTTPConfig{
+		Address:      ts.URL + "/states/{state}",
+		States:       []string{"network", "dns"},
+		LockMethod:   "LOCK",
+		UnlockMethod: "UNLOCK",
+		Username:     "terraboard",
+		Password:     "secret",
+		Headers:      map[string]string{"X-Team": "platform"},
+	}
+}
+
+func TestNewHTTPNoAddress(t *testing.T) {
+	if httpInstance := NewHTTP(config.HTTPConfig{}, false, false); httpInstance != nil {
+		t.Error("HTTP instance should be nil")
+	}
+}
+
+func TestHTTPGetStates(t *testing.T) {
+	ts := newHTTPBackendServer(t)
+	defer ts.Close()
+
+	states, err := NewHTTP(newHTTPTestConfig(ts), false, false).GetStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0] != "network" || states[1] != "dns" {
+		t.Errorf("Expected one state per name, got %v", states)
+	}
+
+	// The credentials and query of the address are left out
+	address := strings.Replace(ts.URL, "http://", "http://user:pass@", 1) + "/single?token=secret"
+	states, _ = NewHTTP(config.HTTPConfig{Address: address}, false, false).GetStates()
+	if len(states) != 1 || states[0] != "/single" {
+		t.Errorf("Expected a single state without placeholder, got %v", states)
+	}
+}
+
+func TestHTTPGetVersions(t *testing.T) {
+	ts := newHTTPBackendServer(t)
+	defer ts.Close()
+	httpInstance := NewHTTP(newHTTPTestConfig(ts), false, false)
+	st := "dns"
+
+	versions, err := httpInstance.GetVersions(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || !strings.HasPrefix(versions[0].ID, st+"@") || len(versions[0].ID) != len(st)+33 {
+		t.Fatalf("Expected a single version identified by the path and content checksum, got %v", versions)
+	}
+
+	// States with the same content at other paths are other versions
+	other, err := httpInstance.GetVersions("dns-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other[0].ID == versions[0].ID {
+		t.Errorf("Expected states at different paths to have different versions, got %v", other[0].ID)
+	}
+
+	sf, err := httpInstance.GetState(st, versions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.Lineage != "dns-lineage" {
+		t.Errorf("Unexpected lineage %s", sf.Lineage)
+	}
+
+	if _, err := httpInstance.GetState(st, "outdated"); err == nil {
+		t.Error("Expected an error for an outdated version")
+	}
+	if _, err := httpInstance.GetState("unknown", ""); err == nil {
+		t.Error("Expected an error for an unknown state")
+	}
+}
+
+func TestHTTPGetStateReusesBody(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		fmt.Fprint(w, `{"version": 4, "serial": 3, "lineage": "lineage", "terraform_version": "1.0.0"}`)
+	}))
+	defer ts.Close()
+	httpInstance := NewHTTP(config.HTTPConfig{Address: ts.URL + "/states/{state}", States: []string{"dns"}}, false, false)
+
+	versions, err := httpInstance.GetVersions("dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := httpInstance.GetState("dns", versions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected the state to be fetched once, got %d requests", requests)
+	}
+
+	// The body is only kept until it is read
+	if _, err := httpInstance.GetState("dns", versions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected the state to be fetched again, got %d requests", requests)
+	}
+}
+
+func TestHTTPGetLocksFromLockInfo(t *testing.T) {
+	ts := newHTTPBackendServer(t)
+	defer ts.Close()
+	c := newHTTPTestConfig(ts)
+	c.LockInfoAddress = ts.URL + "/states/{state}/lock"
+
+	locks, err := NewHTTP(c, false, false).GetLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 {
+		t.Fatalf("Expected one lock, got %v", locks)
+	}
+	lock := locks["network"]
+	if lock.ID != "lock-id" || lock.Who != "user@host" || lock.Path != "network" {
+		t.Errorf("Unexpected lock info %v", lock)
+	}
+}
+
+func TestHTTPGetLocksFromProbe(t *testing.T) {
+	ts, lockRequests := newCountingHTTPBackendServer(t)
+	defer ts.Close()
+	c := newHTTPTestConfig(ts)
+	c.LockAddress = ts.URL + "/states/{state}/lock"
+	c.UnlockAddress = ts.URL + "/states/{state}/lock"
+	c.ProbeInterval = 300
+
+	// Probing is opt-in: the locks are unknown without it
+	locks, err := NewHTTP(c, false, false).GetLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 0 || lockRequests() != 0 {
+		t.Fatalf("Expected no lock to be probed, got %v after %d lock requests", locks, lockRequests())
+	}
+
+	c.ProbeLocks = true
+	httpInstance := NewHTTP(c, false, false)
+	locks, err = httpInstance.GetLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks["network"].ID != "lock-id" {
+		t.Fatalf("Expected the network state to be locked, got %v", locks)
+	}
+	if lockRequests() != 2 {
+		t.Errorf("Expected a lock probe per state, got %d lock requests", lockRequests())
+	}
+
+	// The probes are cached until the probe interval has elapsed
+	locks, _ = httpInstance.GetLocks()
+	if len(locks) != 1 || lockRequests() != 2 {
+		t.Errorf("Expected the cached locks, got %v after %d lock requests", locks, lockRequests())
+	}
+	httpInstance.probed = httpInstance.probed.Add(-5 * time.Minute)
+	if _, err := httpInstance.GetLocks(); err != nil {
+		t.Fatal(err)
+	}
+	if lockRequests() != 4 {
+		t.Errorf("Expected the locks to be probed again, got %d lock requests", lockRequests())
+	}
+
+	httpInstance.noLocks = true
+	locks, _ = httpInstance.GetLocks()
+	if len(locks) != 0 {
+		t.Error("Locks should be empty due to noLocks option")
+	}
+}
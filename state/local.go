@@ -0,0 +1,232 @@
+package state
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	localStateExtension  = ".tfstate"
+	localBackupExtension = ".tfstate.backup"
+)
+
+// Local is a state provider type, leveraging local (or network mounted) directories
+type Local struct {
+	paths        []string
+	noLocks      bool
+	noVersioning bool
+}
+
+// NewLocal creates a Local object
+func NewLocal(local config.LocalConfig, noLocks, noVersioning bool) *Local {
+	if len(local.Paths) == 0 {
+		return nil
+	}
+
+	var paths []string
+	for _, p := range local.Paths {
+		if p != "" {
+			paths = append(paths, filepath.Clean(p))
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"paths": paths,
+	}).Info("Local filesystem provider successfully created")
+
+	return &Local{
+		paths:        paths,
+		noLocks:      noLocks,
+		noVersioning: noVersioning,
+	}
+}
+
+// Identity returns the paths of the States
+func (l *Local) Identity() string {
+	return strings.Join(l.paths, ",")
+}
+
+// NewLocalCollection instantiate all needed Local objects configurated by the user and return a slice
+func NewLocalCollection(c *config.Config) []*Local {
+	var localInstances []*Local
+	for _, local := range c.Local {
+		if localInstance := NewLocal(local, c.Provider.NoLocks, c.Provider.NoVersioning); localInstance != nil {
+			localInstances = append(localInstances, localInstance)
+		}
+	}
+
+	return localInstances
+}
+
+// lockInfoPath returns the path of the lock information file
+// written by Terraform's local backend next to a state file
+func lockInfoPath(st string) string {
+	dir, name := filepath.Split(st)
+	return filepath.Join(dir, "."+name+".lock.info")
+}
+
+// GetLocks returns a map of locks by State path
+func (l *Local) GetLocks() (locks map[string]LockInfo, err error) {
+	locks = make(map[string]LockInfo)
+	if l.noLocks {
+		return
+	}
+
+	states, err := l.GetStates()
+	if err != nil {
+		return locks, err
+	}
+
+	for _, st := range states {
+		data, err := os.ReadFile(lockInfoPath(st))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return locks, err
+		}
+
+		var info LockInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return locks, err
+		}
+		locks[st] = info
+	}
+
+	return
+}
+
+// GetStates returns a slice of State files found in the configured directories.
+// Workspaces using the terraform.tfstate.d/<workspace>/ layout are returned
+// as distinct states.
+func (l *Local) GetStates() (states []string, err error) {
+	for _, root := range l.paths {
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Skip Terraform working directories, they only hold backend metadata
+			if d.IsDir() && d.Name() == ".terraform" {
+				return filepath.SkipDir
+			}
+
+			if !d.IsDir() && strings.HasSuffix(path, localStateExtension) {
+				states = append(states, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return states, err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"paths":  l.paths,
+		"states": len(states),
+	}).Debug("Found states from local filesystem")
+
+	return states, nil
+}
+
+// localVersionID computes a content-based identifier for a state file,
+// so that a backup file matching a previously seen state is not duplicated
+func localVersionID(st string, data []byte) string {
+	h := sha256.New()
+	h.Write([]byte(st))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GetVersions returns a slice of Version objects, made of the current state file
+// and its *.tfstate.backup counterpart if it exists
+func (l *Local) GetVersions(state string) (versions []Version, err error) {
+	versions = []Version{}
+	info, err := os.Stat(state)
+	if err != nil {
+		return
+	}
+
+	if l.noVersioning {
+		versions = append(versions, Version{
+			ID:           state,
+			LastModified: info.ModTime(),
+		})
+		return
+	}
+
+	for _, file := range []string{state, localBackupPath(state)} {
+		info, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return versions, err
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return versions, err
+		}
+
+		versions = append(versions, Version{
+			ID:           localVersionID(state, data),
+			LastModified: info.ModTime(),
+		})
+	}
+
+	return
+}
+
+// localBackupPath returns the path of the backup file of a state
+func localBackupPath(st string) string {
+	return strings.TrimSuffix(st, localStateExtension) + localBackupExtension
+}
+
+// GetState retrieves a single State from the local filesystem
+func (l *Local) GetState(st, versionID string) (sf *statefile.File, err error) {
+	files := []string{st}
+	if versionID != "" && versionID != st && !l.noVersioning {
+		files = append(files, localBackupPath(st))
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(files) > 1 && localVersionID(st, data) != versionID {
+			continue
+		}
+
+		sf, err = statefile.Read(bytes.NewReader(data))
+		if sf == nil || err != nil {
+			return sf, fmt.Errorf("Failed to find state: %v", err)
+		}
+
+		log.WithFields(log.Fields{
+			"path":       file,
+			"version_id": versionID,
+		}).Info("State read from local filesystem")
+
+		return sf, nil
+	}
+
+	return nil, fmt.Errorf("State file not found: %s (version %s)", st, versionID)
+}
@@ -0,0 +1,142 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+)
+
+const localTestState = `{"version": 4, "serial": 3, "lineage": "local-lineage", "terraform_version": "1.0.0"}`
+const localTestBackup = `{"version": 4, "serial": 2, "lineage": "local-lineage", "terraform_version": "1.0.0"}`
+
+func writeLocalTestFile(t *testing.T, path, content string, mtime time.Time) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newLocalTestTree(t *testing.T) string {
+	root := t.TempDir()
+	mtime := time.Unix(1600000000, 0)
+	writeLocalTestFile(t, filepath.Join(root, "stack", "terraform.tfstate"), localTestState, mtime)
+	writeLocalTestFile(t, filepath.Join(root, "stack", "terraform.tfstate.backup"), localTestBackup, mtime.Add(-time.Hour))
+	writeLocalTestFile(t, filepath.Join(root, "stack", "terraform.tfstate.d", "prod", "terraform.tfstate"), localTestState, mtime)
+	writeLocalTestFile(t, filepath.Join(root, "stack", ".terraform", "terraform.tfstate"), `{}`, mtime)
+	return root
+}
+
+func TestNewLocalNoPaths(t *testing.T) {
+	if localInstance := NewLocal(config.LocalConfig{}, false, false); localInstance != nil {
+		t.Error("Local instance should be nil")
+	}
+}
+
+func TestLocalGetStates(t *testing.T) {
+	root := newLocalTestTree(t)
+	localInstance := NewLocal(config.LocalConfig{Paths: []string{root}}, false, false)
+
+	states, err := localInstance.GetStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("Expected 2 states, got %d: %v", len(states), states)
+	}
+	for _, st := range states {
+		if filepath.Base(filepath.Dir(st)) == ".terraform" {
+			t.Errorf("Backend metadata should not be listed as a state: %s", st)
+		}
+	}
+}
+
+func TestLocalGetVersions(t *testing.T) {
+	root := newLocalTestTree(t)
+	localInstance := NewLocal(config.LocalConfig{Paths: []string{root}}, false, false)
+	st := filepath.Join(root, "stack", "terraform.tfstate")
+
+	versions, err := localInstance.GetVersions(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(versions))
+	}
+	if !versions[0].LastModified.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("Expected file mtime as LastModified, got %v", versions[0].LastModified)
+	}
+
+	backup, err := localInstance.GetState(st, versions[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.Serial != 2 {
+		t.Errorf("Expected backup serial 2, got %d", backup.Serial)
+	}
+
+	current, err := localInstance.GetState(st, versions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Serial != 3 {
+		t.Errorf("Expected current serial 3, got %d", current.Serial)
+	}
+
+	if _, err := localInstance.GetState(st, "unknown"); err == nil {
+		t.Error("Expected an error for an unknown version")
+	}
+}
+
+func TestLocalGetVersionsNoVersioning(t *testing.T) {
+	root := newLocalTestTree(t)
+	localInstance := NewLocal(config.LocalConfig{Paths: []string{root}}, false, true)
+	st := filepath.Join(root, "stack", "terraform.tfstate")
+
+	versions, err := localInstance.GetVersions(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].ID != st {
+		t.Fatalf("Expected a single version identified by the state path, got %v", versions)
+	}
+
+	sf, err := localInstance.GetState(st, versions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.Serial != 3 {
+		t.Errorf("Expected serial 3, got %d", sf.Serial)
+	}
+}
+
+func TestLocalGetLocks(t *testing.T) {
+	root := newLocalTestTree(t)
+	st := filepath.Join(root, "stack", "terraform.tfstate")
+	writeLocalTestFile(t, lockInfoPath(st), `{"ID":"abc","Operation":"OperationTypeApply","Who":"user@host","Version":"1.0.0","Path":"terraform.tfstate"}`, time.Now())
+	localInstance := NewLocal(config.LocalConfig{Paths: []string{root}}, false, false)
+
+	locks, err := localInstance.GetLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 {
+		t.Fatalf("Expected one lock, got %d", len(locks))
+	}
+	if locks[st].Who != "user@host" {
+		t.Errorf("Unexpected lock owner %s", locks[st].Who)
+	}
+
+	localInstance.noLocks = true
+	locks, _ = localInstance.GetLocks()
+	if len(locks) != 0 {
+		t.Error("Locks should be empty due to noLocks option")
+	}
+}
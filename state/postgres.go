@@ -0,0 +1,257 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	"github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" database/sql driver
+	log "github.com/sirupsen/logrus"
+)
+
+// postgresStatesTable is the table used by Terraform's pg backend
+// in its schema to store the states, one row per workspace
+const postgresStatesTable = "states"
+
+// Postgres is a state provider type, leveraging the database
+// of a Terraform pg backend
+type Postgres struct {
+	db           *sql.DB
+	location     string
+	schema       string
+	noLocks      bool
+	noVersioning bool
+}
+
+// NewPostgres creates a Postgres object
+func NewPostgres(pg config.PostgresConfig, noLocks, noVersioning bool) (*Postgres, error) {
+	if pg.ConnStr == "" {
+		return nil, nil
+	}
+
+	db, err := sql.Open("pgx", pg.ConnStr)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"schema": pg.SchemaName,
+	}).Info("Client successfully created")
+
+	return &Postgres{
+		db:           db,
+		location:     postgresLocation(pg.ConnStr),
+		schema:       pg.SchemaName,
+		noLocks:      noLocks,
+		noVersioning: noVersioning,
+	}, nil
+}
+
+// Return the host, port and database of a connection string,
+// leaving out its credentials
+func postgresLocation(connStr string) string {
+	c, err := pgx.ParseConfig(connStr)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d/%s", c.Host, c.Port, c.Database)
+}
+
+// Identity returns the database and schema of the States
+func (p *Postgres) Identity() string {
+	return fmt.Sprintf("%s/%s", p.location, p.schema)
+}
+
+// NewPostgresCollection instantiate all needed Postgres objects configurated by the user and return a slice
+func NewPostgresCollection(c *config.Config) ([]*Postgres, error) {
+	var postgresInstances []*Postgres
+	for _, pg := range c.Postgres {
+		postgresInstance, err := NewPostgres(pg, c.Provider.NoLocks, c.Provider.NoVersioning)
+		if err != nil {
+			return nil, err
+		}
+		if postgresInstance != nil {
+			postgresInstances = append(postgresInstances, postgresInstance)
+		}
+	}
+
+	return postgresInstances, nil
+}
+
+// table returns the quoted name of the states table
+func (p *Postgres) table() string {
+	return pgx.Identifier{p.schema, postgresStatesTable}.Sanitize()
+}
+
+// statePath builds a State path from a workspace name
+func (p *Postgres) statePath(workspace string) string {
+	return strings.Join([]string{p.schema, workspace}, "/")
+}
+
+// workspace returns the workspace name of a State path
+func (p *Postgres) workspace(st string) (string, error) {
+	prefix := p.schema + "/"
+	if !strings.HasPrefix(st, prefix) {
+		return "", fmt.Errorf("invalid state path: %s", st)
+	}
+	return strings.TrimPrefix(st, prefix), nil
+}
+
+// GetLocks returns a map of locks by State path.
+// The pg backend locks a workspace by holding a session advisory lock
+// on its row ID, without storing any lock information: the holder is
+// retrieved from pg_stat_activity instead.
+func (p *Postgres) GetLocks() (locks map[string]LockInfo, err error) {
+	locks = make(map[string]LockInfo)
+	if p.noLocks {
+		return
+	}
+
+	// A bigint advisory lock key is split in classid (high bits)
+	// and objid (low bits), with objsubid set to 1
+	query := fmt.Sprintf(`SELECT s.name, l.pid, COALESCE(a.usename, ''), COALESCE(host(a.client_addr), ''),
+	COALESCE(a.application_name, ''), a.backend_start
+FROM %s s
+JOIN pg_locks l ON l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+	AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+	AND ((l.classid::bigint << 32) | l.objid::bigint) = s.id
+LEFT JOIN pg_stat_activity a ON a.pid = l.pid`, p.table())
+
+	rows, err := p.db.Query(query)
+	if err != nil {
+		return locks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, user, host, application string
+		var pid int64
+		var created sql.NullTime
+		if err := rows.Scan(&name, &pid, &user, &host, &application, &created); err != nil {
+			return locks, err
+		}
+
+		who := user
+		if host != "" {
+			who = fmt.Sprintf("%s@%s", user, host)
+		}
+		info := LockInfo{
+			ID:        fmt.Sprintf("%d", pid),
+			Operation: "N/A",
+			Info:      application,
+			Who:       who,
+			Version:   "N/A",
+			Path:      p.statePath(name),
+		}
+		if created.Valid {
+			info.Created = &created.Time
+		}
+		locks[info.Path] = info
+	}
+
+	return locks, rows.Err()
+}
+
+// GetStates returns a slice of State paths, one per workspace
+func (p *Postgres) GetStates() (states []string, err error) {
+	rows, err := p.db.Query(fmt.Sprintf("SELECT name FROM %s ORDER BY name", p.table()))
+	if err != nil {
+		return states, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return states, err
+		}
+		states = append(states, p.statePath(name))
+	}
+	if err := rows.Err(); err != nil {
+		return states, err
+	}
+
+	log.WithFields(log.Fields{
+		"schema": p.schema,
+		"states": len(states),
+	}).Debug("Found states from PostgreSQL")
+
+	return states, nil
+}
+
+// GetVersions returns a slice of Version objects, containing only
+// the current state since the pg backend doesn't keep any history.
+// The Version ID is made of the path and the MD5 checksum of the state data.
+func (p *Postgres) GetVersions(state string) (versions []Version, err error) {
+	versions = []Version{}
+	if p.noVersioning {
+		versions = append(versions, Version{
+			ID:           state,
+			LastModified: time.Now(),
+		})
+		return
+	}
+
+	workspace, err := p.workspace(state)
+	if err != nil {
+		return
+	}
+
+	var sum string
+	err = p.db.QueryRow(fmt.Sprintf("SELECT md5(data) FROM %s WHERE name = $1", p.table()), workspace).Scan(&sum)
+	if err != nil {
+		return
+	}
+
+	versions = append(versions, Version{
+		ID:           checksumVersionID(state, sum),
+		LastModified: time.Now(),
+	})
+
+	return
+}
+
+// GetState retrieves a single State from the pg backend database
+func (p *Postgres) GetState(st, versionID string) (sf *statefile.File, err error) {
+	workspace, err := p.workspace(st)
+	if err != nil {
+		return
+	}
+
+	var data, sum string
+	err = p.db.QueryRow(fmt.Sprintf("SELECT data, md5(data) FROM %s WHERE name = $1", p.table()), workspace).Scan(&data, &sum)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  st,
+			"error": err,
+		}).Error("Error retrieving state from PostgreSQL")
+		errObj := make(map[string]string)
+		errObj["error"] = fmt.Sprintf("State file not found: %v", st)
+		errObj["details"] = fmt.Sprintf("%v", err)
+		j, _ := json.Marshal(errObj)
+		return sf, fmt.Errorf("%s", string(j))
+	}
+
+	current := checksumVersionID(st, sum)
+	if versionID != "" && versionID != st && !p.noVersioning && versionID != current {
+		return nil, fmt.Errorf("version %s of state %s is no longer available (current version is %s)",
+			versionID, st, current)
+	}
+
+	sf, err = statefile.Read(strings.NewReader(data))
+	if sf == nil || err != nil {
+		return sf, fmt.Errorf("Failed to find state: %v", err)
+	}
+
+	log.WithFields(log.Fields{
+		"path":       st,
+		"version_id": current,
+	}).Info("State read from PostgreSQL")
+
+	return
+}
@@ -0,0 +1,128 @@
+package state
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/camptocamp/terraboard/config"
+)
+
+const postgresTestState = `{"version": 4, "serial": 3, "lineage": "pg-lineage", "terraform_version": "1.0.0"}`
+
+func newPostgresTestInstance(t *testing.T, noVersioning bool) (*Postgres, sqlmock.Sqlmock) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fakeDB.Close() })
+
+	return &Postgres{
+		db:           fakeDB,
+		schema:       "terraform_remote_state",
+		noVersioning: noVersioning,
+	}, mock
+}
+
+func TestNewPostgresNoConnStr(t *testing.T) {
+	postgresInstance, err := NewPostgres(config.PostgresConfig{SchemaName: "terraform_remote_state"}, false, false)
+	if err != nil || postgresInstance != nil {
+		t.Error("Postgres instance should be nil")
+	}
+}
+
+func TestPostgresGetStates(t *testing.T) {
+	postgresInstance, mock := newPostgresTestInstance(t, false)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT name FROM "terraform_remote_state"."states" ORDER BY name`)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("default").AddRow("prod"))
+
+	states, err := postgresInstance.GetStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0] != "terraform_remote_state/default" || states[1] != "terraform_remote_state/prod" {
+		t.Errorf("Expected one state per workspace, got %v", states)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresGetVersions(t *testing.T) {
+	postgresInstance, mock := newPostgresTestInstance(t, false)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT md5(data) FROM "terraform_remote_state"."states" WHERE name = $1`)).
+		WithArgs("prod").
+		WillReturnRows(sqlmock.NewRows([]string{"md5"}).AddRow("abc123"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT data, md5(data) FROM "terraform_remote_state"."states" WHERE name = $1`)).
+		WithArgs("prod").
+		WillReturnRows(sqlmock.NewRows([]string{"data", "md5"}).AddRow(postgresTestState, "abc123"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT data, md5(data) FROM "terraform_remote_state"."states" WHERE name = $1`)).
+		WithArgs("prod").
+		WillReturnRows(sqlmock.NewRows([]string{"data", "md5"}).AddRow(postgresTestState, "def456"))
+
+	versions, err := postgresInstance.GetVersions("terraform_remote_state/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].ID != "terraform_remote_state/prod@abc123" {
+		t.Fatalf("Expected a single version identified by the path and data checksum, got %v", versions)
+	}
+
+	sf, err := postgresInstance.GetState("terraform_remote_state/prod", versions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.Serial != 3 {
+		t.Errorf("Expected serial 3, got %d", sf.Serial)
+	}
+
+	if _, err := postgresInstance.GetState("terraform_remote_state/prod", versions[0].ID); err == nil {
+		t.Error("Expected an error for an outdated version")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresGetVersionsNoVersioning(t *testing.T) {
+	postgresInstance, _ := newPostgresTestInstance(t, true)
+
+	versions, err := postgresInstance.GetVersions("terraform_remote_state/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].ID != "terraform_remote_state/prod" {
+		t.Errorf("Expected a single version identified by the state path, got %v", versions)
+	}
+}
+
+func TestPostgresGetLocks(t *testing.T) {
+	postgresInstance, mock := newPostgresTestInstance(t, false)
+	started := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "terraform_remote_state"."states" s
+JOIN pg_locks l`)).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "pid", "usename", "client_addr", "application_name", "backend_start"}).
+			AddRow("prod", 4242, "terraform", "10.0.0.1", "", started))
+
+	locks, err := postgresInstance.GetLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 {
+		t.Fatalf("Expected one lock, got %v", locks)
+	}
+	lock := locks["terraform_remote_state/prod"]
+	if lock.ID != "4242" || lock.Who != "terraform@10.0.0.1" || lock.Created == nil || !lock.Created.Equal(started) {
+		t.Errorf("Unexpected lock info %v", lock)
+	}
+
+	postgresInstance.noLocks = true
+	locks, _ = postgresInstance.GetLocks()
+	if len(locks) != 0 {
+		t.Error("Locks should be empty due to noLocks option")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
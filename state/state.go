@@ -1,6 +1,7 @@
 package state
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/camptocamp/terraboard/config"
@@ -39,6 +40,28 @@ type Provider interface {
 	GetState(string, string) (*statefile.File, error)
 }
 
+// DeletionProvider is implemented by the providers which keep track of
+// the deletion of their states, e.g. with the delete markers of versioned
+// S3 buckets. GetDeletion returns when a state was deleted, if known.
+type DeletionProvider interface {
+	GetDeletion(string) (time.Time, bool, error)
+}
+
+// IdentifiedProvider is implemented by the providers which can tell where
+// their states are stored, e.g. their bucket, endpoint or table. The
+// identity does not depend on the position of the provider in the
+// configuration.
+type IdentifiedProvider interface {
+	Identity() string
+}
+
+// checksumVersionID builds a Version ID from the checksum of the content
+// of a state, for the providers keeping no history. The path is part of
+// the ID, since states at different paths may have the same content.
+func checksumVersionID(path, sum string) string {
+	return fmt.Sprintf("%s@%s", path, sum)
+}
+
 // Configure the state provider
 func Configure(c *config.Config) ([]Provider, error) {
 	var providers []Provider
@@ -88,5 +111,64 @@ func Configure(c *config.Config) ([]Provider, error) {
 		}
 	}
 
+	if len(c.Azure) > 0 {
+		objs, err := NewAzureCollection(c)
+		if err != nil {
+			return []Provider{}, err
+		}
+		if len(objs) > 0 {
+			log.Info("Using Azure Blob Storage as state/locks provider")
+			for _, azureObj := range objs {
+				providers = append(providers, azureObj)
+			}
+		}
+	}
+
+	if len(c.Consul) > 0 {
+		objs, err := NewConsulCollection(c)
+		if err != nil {
+			return []Provider{}, err
+		}
+		if len(objs) > 0 {
+			log.Info("Using Consul KV as state/locks provider")
+			for _, consulObj := range objs {
+				providers = append(providers, consulObj)
+			}
+		}
+	}
+
+	if len(c.Postgres) > 0 {
+		objs, err := NewPostgresCollection(c)
+		if err != nil {
+			return []Provider{}, err
+		}
+		if len(objs) > 0 {
+			log.Info("Using PostgreSQL (pg backend) as state/locks provider")
+			for _, pgObj := range objs {
+				providers = append(providers, pgObj)
+			}
+		}
+	}
+
+	if len(c.HTTP) > 0 {
+		objs := NewHTTPCollection(c)
+		if len(objs) > 0 {
+			log.Info("Using HTTP backend as state/locks provider")
+			for _, httpObj := range objs {
+				providers = append(providers, httpObj)
+			}
+		}
+	}
+
+	if len(c.Local) > 0 {
+		objs := NewLocalCollection(c)
+		if len(objs) > 0 {
+			log.Info("Using local filesystem as state/locks provider")
+			for _, localObj := range objs {
+				providers = append(providers, localObj)
+			}
+		}
+	}
+
 	return providers, nil
 }
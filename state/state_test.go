@@ -80,13 +80,44 @@ func TestConfigure(t *testing.T) {
 				Token:   "test-token",
 			},
 		},
+		Azure: []config.AzureConfig{
+			{
+				StorageAccount: "devstoreaccount1",
+				AccessKey:      "a2V5",
+				Endpoint:       "http://127.0.0.1:10000/devstoreaccount1",
+				Containers:     []string{"tfstate"},
+			},
+		},
+		Consul: []config.ConsulConfig{
+			{
+				Address: "127.0.0.1:8500",
+				Prefix:  "terraform/",
+			},
+		},
+		Postgres: []config.PostgresConfig{
+			{
+				ConnStr:    "postgres://terraform@127.0.0.1/terraform_backend",
+				SchemaName: "terraform_remote_state",
+			},
+		},
+		HTTP: []config.HTTPConfig{
+			{
+				Address: "http://127.0.0.1:8082/states/{state}",
+				States:  []string{"network"},
+			},
+		},
+		Local: []config.LocalConfig{
+			{
+				Paths: []string{t.TempDir()},
+			},
+		},
 	}
 
 	providers, err := Configure(&config)
 	if err != nil {
 		t.Error(err)
-	} else if len(providers) != 4 {
-		t.Errorf("Expected 4 providers, got %d", len(providers))
+	} else if len(providers) != 9 {
+		t.Errorf("Expected 9 providers, got %d", len(providers))
 	}
 }
 
@@ -14,6 +14,7 @@ import (
 // TFE is a state provider type, leveraging Terraform Enterprise
 type TFE struct {
 	*tfe.Client
+	address      string
 	org          string
 	ctx          *context.Context
 	noLocks      bool
@@ -40,6 +41,7 @@ func NewTFE(tfeObj config.TFEConfig, noLocks, noVersioning bool) (*TFE, error) {
 	ctx := context.Background()
 	tfeInstance = &TFE{
 		Client:       client,
+		address:      tfeObj.Address,
 		org:          tfeObj.Organization,
 		ctx:          &ctx,
 		noLocks:      noLocks,
@@ -49,6 +51,11 @@ func NewTFE(tfeObj config.TFEConfig, noLocks, noVersioning bool) (*TFE, error) {
 	return tfeInstance, nil
 }
 
+// Identity returns the address and organization of the workspaces
+func (t *TFE) Identity() string {
+	return fmt.Sprintf("%s/%s", t.address, t.org)
+}
+
 // NewTFECollection instantiate all needed GCP objects configurated by the user and return a slice
 func NewTFECollection(c *config.Config) ([]*TFE, error) {
 	var tfeInstances []*TFE
@@ -0,0 +1,37 @@
+package sync
+
+import (
+	"time"
+)
+
+// Default bounds of the per-state exponential backoff
+const (
+	DefaultMinBackoff = 30 * time.Second
+	DefaultMaxBackoff = 1 * time.Hour
+)
+
+// backoff tracks the consecutive sync failures of a single state
+type backoff struct {
+	failures int
+	next     time.Time
+}
+
+// fail records a failure and schedules the next attempt,
+// doubling the delay at each consecutive failure
+func (b *backoff) fail(now time.Time, min, max time.Duration) time.Duration {
+	b.failures++
+	delay := min
+	for i := 1; i < b.failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	b.next = now.Add(delay)
+	return delay
+}
+
+// ready checks whether a new attempt can be made
+func (b *backoff) ready(now time.Time) bool {
+	return !now.Before(b.next)
+}
@@ -0,0 +1,19 @@
+package sync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	now := time.Now()
+	b := &backoff{}
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if delay := b.fail(now, time.Second, 5*time.Second); delay != expected {
+			t.Errorf("Expected delay %v, got %v", expected, delay)
+		}
+	}
+	if b.ready(now) || !b.ready(now.Add(5*time.Second)) {
+		t.Error("Unexpected backoff readiness")
+	}
+}
@@ -0,0 +1,93 @@
+package sync
+
+import (
+	"context"
+	gosync "sync"
+	"time"
+
+	"github.com/camptocamp/terraboard/notify"
+	"github.com/camptocamp/terraboard/state"
+	log "github.com/sirupsen/logrus"
+)
+
+// LockWatcher polls the locks of the providers at each interval.
+// It keeps the latest locks of each provider for the metrics,
+// and notifies the locks acquired or released since the previous poll.
+type LockWatcher struct {
+	names     []string
+	providers []state.Provider
+	interval  time.Duration
+	lineages  notify.LineageFinder
+	notifier  *notify.Notifier
+
+	mu    gosync.RWMutex
+	locks map[string]map[string]state.LockInfo // by provider name
+}
+
+// NewLockWatcher creates a LockWatcher polling the locks of the given
+// providers every interval, finding the lineages of the locked states
+// for the notifications
+func NewLockWatcher(sps []state.Provider, interval time.Duration, lf notify.LineageFinder) *LockWatcher {
+	return &LockWatcher{
+		names:     providerNames(sps),
+		providers: sps,
+		interval:  interval,
+		lineages:  lf,
+		locks:     make(map[string]map[string]state.LockInfo),
+	}
+}
+
+// SetNotifier sets the Notifier used to report the lock changes
+func (w *LockWatcher) SetNotifier(n *notify.Notifier) {
+	w.notifier = n
+}
+
+// Run polls the locks every interval until the context is cancelled
+func (w *LockWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.poll()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll refreshes the locks of all providers. The locks of a provider
+// failing to list them are kept until its next successful poll,
+// and the first poll of a provider only records its current locks.
+func (w *LockWatcher) poll() {
+	for i, sp := range w.providers {
+		locks, err := sp.GetLocks()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"provider": w.names[i],
+				"error":    err,
+			}).Error("Failed to get locks")
+			continue
+		}
+
+		w.mu.Lock()
+		previous, ok := w.locks[w.names[i]]
+		w.locks[w.names[i]] = locks
+		w.mu.Unlock()
+		if ok {
+			w.notifier.LocksChanged(previous, locks, w.lineages)
+		}
+	}
+}
+
+// Locks returns the latest locks of each provider, by provider name
+func (w *LockWatcher) Locks() map[string]map[string]state.LockInfo {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	locks := make(map[string]map[string]state.LockInfo, len(w.locks))
+	for name, l := range w.locks {
+		locks[name] = l
+	}
+	return locks
+}
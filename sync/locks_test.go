@@ -0,0 +1,47 @@
+package sync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/state"
+)
+
+// lockingProvider is a fakeProvider holding locks
+type lockingProvider struct {
+	*fakeProvider
+	locks map[string]state.LockInfo
+	err   error
+}
+
+func (p *lockingProvider) GetLocks() (map[string]state.LockInfo, error) {
+	return p.locks, p.err
+}
+
+func TestLockWatcherPoll(t *testing.T) {
+	sp := &lockingProvider{
+		fakeProvider: newFakeProvider(),
+		locks:        map[string]state.LockInfo{"a.tfstate": {ID: "1", Who: "alice"}},
+	}
+	w := NewLockWatcher([]state.Provider{sp, newFakeProvider()}, time.Minute, nil)
+
+	w.poll()
+	locks := w.Locks()
+	if len(locks) != 2 || locks["lockingprovider-0"]["a.tfstate"].Who != "alice" || len(locks["fakeprovider-1"]) != 0 {
+		t.Errorf("Unexpected locks %v", locks)
+	}
+
+	// The locks of a failing provider are kept
+	sp.locks, sp.err = nil, fmt.Errorf("access denied")
+	w.poll()
+	if _, ok := w.Locks()["lockingprovider-0"]["a.tfstate"]; !ok {
+		t.Errorf("Expected the locks to be kept on failure, got %v", w.Locks())
+	}
+
+	sp.locks, sp.err = map[string]state.LockInfo{}, nil
+	w.poll()
+	if locks := w.Locks()["lockingprovider-0"]; len(locks) != 0 {
+		t.Errorf("Expected the lock to be released, got %v", locks)
+	}
+}
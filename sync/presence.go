@@ -0,0 +1,70 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/camptocamp/terraboard/state"
+	log "github.com/sirupsen/logrus"
+)
+
+// trackPaths records the states listed by a provider, and the deletion
+// of the states it listed during its previous syncs but no longer lists.
+// It tells whether states were deleted or restored. The cursors of the
+// states are restored from the known paths after a restart.
+func (e *Engine) trackPaths(p *providerSync, states []string) bool {
+	known, err := e.db.ListStatePaths(p.name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"provider": p.name,
+			"error":    err,
+		}).Error("Failed to retrieve the known state paths")
+		return false
+	}
+	p.restoreCursors(known)
+
+	listed := make(map[string]bool, len(states))
+	for _, st := range states {
+		listed[st] = true
+	}
+	now := time.Now()
+	removed := make(map[string]time.Time)
+	restored := false
+	for _, sp := range known {
+		if sp.RemovedAt == nil && !listed[sp.Path] {
+			removed[sp.Path] = deletionTime(p, sp.Path, now)
+		}
+		if sp.RemovedAt != nil && listed[sp.Path] {
+			restored = true
+		}
+	}
+
+	if err := e.db.UpdateStatePaths(p.name, states, removed, now); err != nil {
+		log.WithFields(log.Fields{
+			"provider": p.name,
+			"error":    err,
+		}).Error("Failed to record the state paths")
+		return false
+	}
+	return restored || len(removed) > 0
+}
+
+// deletionTime returns when a state was deleted, as reported by
+// the provider when it knows it, or now
+func deletionTime(p *providerSync, st string, now time.Time) time.Time {
+	dp, ok := p.provider.(state.DeletionProvider)
+	if !ok {
+		return now
+	}
+	deletedAt, ok, err := dp.GetDeletion(st)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"provider": p.name,
+			"path":     st,
+			"error":    err,
+		}).Warn("Failed to retrieve the deletion time of a state")
+	}
+	if err != nil || !ok {
+		return now
+	}
+	return deletedAt
+}
@@ -0,0 +1,28 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/camptocamp/terraboard/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// rollupStats snapshots the inventory statistics of the day, on the first
+// sync of the day or when the states changed since the previous snapshot
+func (e *Engine) rollupStats(changed bool) {
+	e.rollupMu.Lock()
+	defer e.rollupMu.Unlock()
+
+	now := time.Now().UTC()
+	day := now.Format(types.DayFormat)
+	if !changed && e.rolledUp == day {
+		return
+	}
+	if err := e.db.UpdateStatRollups(now); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to roll up the inventory statistics")
+		return
+	}
+	e.rolledUp = day
+}
@@ -0,0 +1,117 @@
+package sync
+
+import (
+	"errors"
+	"time"
+
+	"github.com/camptocamp/terraboard/types"
+)
+
+// triggerQueueSize is the number of pending triggers per provider
+const triggerQueueSize = 8
+
+// Errors returned when triggering a sync
+var (
+	ErrUnknownProvider = errors.New("unknown provider")
+	ErrUnknownState    = errors.New("unknown state path")
+	ErrTriggerQueued   = errors.New("too many pending sync triggers")
+)
+
+// triggerRequest asks a provider loop to sync right away,
+// either all its states or a single one
+type triggerRequest struct {
+	path string
+}
+
+// ProviderStatus is the sync status of a provider.
+// A provider is stale when it hasn't been successfully synced
+// for more than twice the sync interval.
+type ProviderStatus struct {
+	Provider    string         `json:"provider"`
+	Running     bool           `json:"running"`
+	NextRun     time.Time      `json:"next_run"`
+	BackingOff  int            `json:"backing_off"`
+	Stale       bool           `json:"stale"`
+	LastSuccess *time.Time     `json:"last_success"`
+	LastRun     *types.SyncRun `json:"last_run"`
+}
+
+// Status returns the sync status of each provider
+func (e *Engine) Status() (statuses []ProviderStatus) {
+	now := time.Now()
+	statuses = []ProviderStatus{}
+	for _, p := range e.providers {
+		p.mu.Lock()
+		status := ProviderStatus{
+			Provider:    p.name,
+			Running:     p.running,
+			NextRun:     p.nextRun,
+			LastSuccess: p.lastSuccess,
+			LastRun:     p.lastRun,
+			Stale:       p.lastSuccess == nil || now.Sub(*p.lastSuccess) > 2*e.interval,
+		}
+		for _, b := range p.backoff {
+			if !b.ready(now) {
+				status.BackingOff++
+			}
+		}
+		p.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return
+}
+
+// Trigger requests an immediate sync of a provider, of a single state path,
+// or of all providers when both are empty. A path is always looked up in the
+// states listed by the providers during their last sync, so that no state
+// is fetched from an arbitrary location.
+func (e *Engine) Trigger(provider, path string) error {
+	var targets []*providerSync
+	found := false
+	for _, p := range e.providers {
+		if provider != "" && p.name != provider {
+			continue
+		}
+		found = true
+		if path != "" && !p.hasPath(path) {
+			continue
+		}
+		targets = append(targets, p)
+	}
+
+	if len(targets) == 0 {
+		if provider != "" && !found {
+			return ErrUnknownProvider
+		}
+		if path != "" {
+			return ErrUnknownState
+		}
+	}
+
+	for _, p := range targets {
+		select {
+		case p.trigger <- triggerRequest{path: path}:
+		default:
+			return ErrTriggerQueued
+		}
+	}
+	return nil
+}
+
+// Paths returns the states listed by all the providers during their last
+// sync, and whether all the providers have listed their states yet
+func (e *Engine) Paths() (paths map[string]bool, complete bool) {
+	paths = make(map[string]bool)
+	complete = true
+	for _, p := range e.providers {
+		p.mu.Lock()
+		if p.paths == nil {
+			complete = false
+		}
+		for st := range p.paths {
+			paths[st] = true
+		}
+		p.mu.Unlock()
+	}
+	return
+}
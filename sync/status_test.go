@@ -0,0 +1,115 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/state"
+)
+
+// waitForRuns waits until the database holds the given number of sync runs
+func waitForRuns(t *testing.T, d *fakeDatabase, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.syncRuns()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d sync runs, got %d", n, len(d.syncRuns()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	d := &fakeDatabase{}
+	sp := newFakeProvider()
+	sp.failing["b.tfstate"] = true
+	e := NewEngine(d, []state.Provider{sp}, time.Hour, 1)
+
+	statuses := e.Status()
+	if len(statuses) != 1 || !statuses[0].Stale || statuses[0].LastRun != nil {
+		t.Fatalf("Expected a stale provider before any sync, got %+v", statuses)
+	}
+	if _, complete := e.Paths(); complete {
+		t.Fatalf("Expected no complete paths before any sync")
+	}
+
+	e.record(e.providers[0], e.syncProvider(context.Background(), e.providers[0], TriggerSchedule))
+
+	if paths, complete := e.Paths(); !complete || !paths["a.tfstate"] || !paths["b.tfstate"] {
+		t.Errorf("Expected the listed paths, got %v (complete: %v)", paths, complete)
+	}
+
+	status := e.Status()[0]
+	if status.Provider != "fakeprovider-0" || status.Stale || status.BackingOff != 1 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if status.LastRun == nil || status.LastRun.Failed != 1 || status.LastRun.Trigger != TriggerSchedule {
+		t.Errorf("Unexpected last run %+v", status.LastRun)
+	}
+	if runs := d.syncRuns(); len(runs) != 1 || runs[0].EndedAt.Before(runs[0].StartedAt) {
+		t.Errorf("Expected the sync run to be persisted, got %v", runs)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	d := &fakeDatabase{}
+	sp := newFakeProvider()
+	e := NewEngine(d, []state.Provider{sp}, time.Hour, 1)
+
+	if err := e.Trigger("unknown", ""); err != ErrUnknownProvider {
+		t.Errorf("Expected ErrUnknownProvider, got %v", err)
+	}
+	if err := e.Trigger("", "a.tfstate"); err != ErrUnknownState {
+		t.Errorf("Expected ErrUnknownState before the first sync, got %v", err)
+	}
+	if err := e.Trigger("fakeprovider-0", "a.tfstate"); err != ErrUnknownState {
+		t.Errorf("Expected ErrUnknownState for a provider before the first sync, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Scheduled run at startup
+	waitForRuns(t, d, 1)
+
+	if err := e.Trigger("", "a.tfstate"); err != nil {
+		t.Fatal(err)
+	}
+	waitForRuns(t, d, 2)
+	run := d.syncRuns()[1]
+	if run.Trigger != TriggerManual || run.States != 1 {
+		t.Errorf("Expected a manual run on a single state, got %+v", run)
+	}
+
+	// Paths not listed by the provider are never fetched
+	if err := e.Trigger("fakeprovider-0", "http://169.254.169.254/latest"); err != ErrUnknownState {
+		t.Errorf("Expected ErrUnknownState for an unlisted path, got %v", err)
+	}
+	if err := e.Trigger("fakeprovider-0", "../../etc/terraform.tfstate"); err != ErrUnknownState {
+		t.Errorf("Expected ErrUnknownState for an unlisted path, got %v", err)
+	}
+
+	if err := e.Trigger("fakeprovider-0", "b.tfstate"); err != nil {
+		t.Fatal(err)
+	}
+	waitForRuns(t, d, 3)
+	if run := d.syncRuns()[2]; run.Trigger != TriggerManual || run.States != 1 {
+		t.Errorf("Expected a manual run on a single state of the provider, got %+v", run)
+	}
+
+	if err := e.Trigger("fakeprovider-0", ""); err != nil {
+		t.Fatal(err)
+	}
+	waitForRuns(t, d, 4)
+	if run := d.syncRuns()[3]; run.Trigger != TriggerManual || run.States != 2 {
+		t.Errorf("Expected a manual run on all states, got %+v", run)
+	}
+}
@@ -0,0 +1,606 @@
+// Package sync imports the states of the providers into the database.
+//
+// Each provider is synced by its own loop, spreading its states over a
+// bounded pool of workers. Only unknown versions are fetched and inserted,
+// failing states are retried with an exponential backoff, and all loops
+// stop cleanly when their context is cancelled. Each sync pass is recorded
+// in the database as a SyncRun.
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	"github.com/camptocamp/terraboard/metrics"
+	"github.com/camptocamp/terraboard/notify"
+	"github.com/camptocamp/terraboard/state"
+	"github.com/camptocamp/terraboard/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Triggers of a sync run
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Stages of a state sync at which an error can occur
+const (
+	StageVersions = "versions"
+	StageFetch    = "fetch"
+	StageInsert   = "insert"
+)
+
+// Database is the subset of the Terraboard database used to sync states
+type Database interface {
+	ListStatesVersions() map[string][]string
+	InsertVersion(version *state.Version) error
+	InsertState(path string, versionID string, sf *statefile.File) error
+	InsertSyncRun(run *types.SyncRun) error
+	ListStatePaths(provider string) ([]types.StatePath, error)
+	UpdateStatePaths(provider string, paths []string, removed map[string]time.Time, now time.Time) error
+	UpdateStateCursor(provider, path string, cursor time.Time) error
+	PruneStatePaths(providers []string) error
+	UpdateStatRollups(now time.Time) error
+}
+
+// Engine syncs the states of several providers into the database
+type Engine struct {
+	db         Database
+	notifier   *notify.Notifier
+	providers  []*providerSync
+	interval   time.Duration
+	workers    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	knownOnce gosync.Once
+	knownMu   gosync.RWMutex
+	known     map[string][]string
+
+	rollupMu gosync.Mutex
+	rolledUp string // day of the latest stats rollup
+}
+
+// providerSync holds the sync state of a single provider
+type providerSync struct {
+	name     string
+	provider state.Provider
+	trigger  chan triggerRequest
+
+	mu          gosync.Mutex
+	paths       map[string]bool // nil until the states are listed
+	cursors     map[string]time.Time
+	backoff     map[string]*backoff
+	running     bool
+	nextRun     time.Time
+	lastRun     *types.SyncRun
+	lastSuccess *time.Time
+	synced      bool // whether the initial sync pass is done
+}
+
+// NewEngine creates an Engine syncing the given providers
+// every interval, with the given number of workers per provider
+func NewEngine(d Database, sps []state.Provider, interval time.Duration, workers int) *Engine {
+	if workers < 1 {
+		workers = 1
+	}
+
+	e := &Engine{
+		db:         d,
+		interval:   interval,
+		workers:    workers,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+	for i, name := range providerNames(sps) {
+		e.providers = append(e.providers, &providerSync{
+			name:     name,
+			provider: sps[i],
+			trigger:  make(chan triggerRequest, triggerQueueSize),
+			cursors:  make(map[string]time.Time),
+			backoff:  make(map[string]*backoff),
+		})
+	}
+	return e
+}
+
+// providerNames returns the names of the providers,
+// keeping distinct the names of the providers configured twice
+func providerNames(sps []state.Provider) []string {
+	names := make([]string, len(sps))
+	seen := make(map[string]bool)
+	for i, sp := range sps {
+		name := providerName(sp, i)
+		if seen[name] {
+			name = fmt.Sprintf("%s-%d", name, i)
+		}
+		seen[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// providerName builds a name identifying a provider, made of its type
+// and a hash of its identity, so that it is kept when the configuration
+// is reordered. The position of the provider in the configuration is
+// used when it has no identity.
+func providerName(sp state.Provider, index int) string {
+	t := reflect.TypeOf(sp)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if ip, ok := sp.(state.IdentifiedProvider); ok {
+		sum := sha256.Sum256([]byte(ip.Identity()))
+		return fmt.Sprintf("%s-%s", strings.ToLower(t.Name()), hex.EncodeToString(sum[:4]))
+	}
+	return fmt.Sprintf("%s-%d", strings.ToLower(t.Name()), index)
+}
+
+// SetNotifier sets the Notifier used to report the new state versions
+func (e *Engine) SetNotifier(n *notify.Notifier) {
+	e.notifier = n
+}
+
+// Run syncs all providers every interval until the context is cancelled
+func (e *Engine) Run(ctx context.Context) {
+	// The paths of renamed or removed providers are forgotten rather than
+	// archived, the providers now listing them record them again
+	var names []string
+	for _, p := range e.providers {
+		names = append(names, p.name)
+	}
+	if err := e.db.PruneStatePaths(names); err != nil {
+		log.WithError(err).Error("Failed to prune the state paths of the former providers")
+	}
+
+	var wg gosync.WaitGroup
+	for _, p := range e.providers {
+		wg.Add(1)
+		go func(p *providerSync) {
+			defer wg.Done()
+			e.runProvider(ctx, p)
+		}(p)
+	}
+	wg.Wait()
+	log.Info("Database sync stopped")
+}
+
+// runProvider syncs a provider every interval, or when triggered,
+// until the context is cancelled
+func (e *Engine) runProvider(ctx context.Context, p *providerSync) {
+	p.setNextRun(time.Now())
+	for {
+		timer := time.NewTimer(time.Until(p.getNextRun()))
+		var req triggerRequest
+		trigger := TriggerSchedule
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case req = <-p.trigger:
+			timer.Stop()
+			trigger = TriggerManual
+		}
+
+		var run *types.SyncRun
+		if req.path != "" {
+			run = e.syncPath(ctx, p, req.path)
+		} else {
+			run = e.syncProvider(ctx, p, trigger)
+			p.setNextRun(time.Now().Add(e.interval))
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		e.record(p, run)
+
+		log.WithFields(log.Fields{
+			"provider": p.name,
+		}).Debugf("Waiting until %v for next DB sync", p.getNextRun())
+	}
+}
+
+// record persists a sync run, reports it to the metrics
+// and keeps it as the last run of its provider
+func (e *Engine) record(p *providerSync, run *types.SyncRun) {
+	metrics.ObserveSyncRun(run)
+	if err := e.db.InsertSyncRun(run); err != nil {
+		log.WithFields(log.Fields{
+			"provider": p.name,
+			"error":    err,
+		}).Error("Failed to insert sync run in the database")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastRun = run
+	if run.Error == "" {
+		endedAt := run.EndedAt
+		p.lastSuccess = &endedAt
+	}
+}
+
+// syncProvider runs a single sync pass over the states of a provider
+func (e *Engine) syncProvider(ctx context.Context, p *providerSync, trigger string) *types.SyncRun {
+	log.WithFields(log.Fields{
+		"provider": p.name,
+	}).Info("Refreshing DB")
+
+	run := p.start(trigger)
+	defer p.end(run)
+
+	states, err := p.provider.GetStates()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"provider": p.name,
+			"error":    err,
+		}).Errorf("Failed to retrieve states. Retrying in %v.", e.interval)
+		run.Error = err.Error()
+		return run
+	}
+	p.setPaths(states)
+	moved := e.trackPaths(p, states)
+	e.loadKnown()
+
+	var mu gosync.Mutex
+	var wg gosync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < e.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for st := range queue {
+				inserted, serr := e.syncState(ctx, p, st, false)
+				mu.Lock()
+				run.NewVersions += inserted
+				if serr != nil {
+					run.Failed++
+					run.Errors = append(run.Errors, *serr)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	now := time.Now()
+	run.States = len(states)
+feed:
+	for _, st := range states {
+		if !p.ready(st, now) {
+			run.Skipped++
+			continue
+		}
+		select {
+		case queue <- st:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	log.WithFields(log.Fields{
+		"provider":     p.name,
+		"states":       run.States,
+		"skipped":      run.Skipped,
+		"failed":       run.Failed,
+		"new_versions": run.NewVersions,
+	}).Info("DB refreshed")
+
+	e.rollupStats(moved || run.NewVersions > 0)
+
+	if ctx.Err() == nil {
+		p.setSynced()
+	}
+	return run
+}
+
+// syncPath syncs a single state of a provider right away,
+// regardless of its cursor and backoff
+func (e *Engine) syncPath(ctx context.Context, p *providerSync, st string) *types.SyncRun {
+	log.WithFields(log.Fields{
+		"provider": p.name,
+		"path":     st,
+	}).Info("Refreshing state")
+
+	run := p.start(TriggerManual)
+	defer p.end(run)
+
+	e.loadKnown()
+	run.States = 1
+	inserted, serr := e.syncState(ctx, p, st, true)
+	run.NewVersions = inserted
+	if serr != nil {
+		run.Failed = 1
+		run.Errors = append(run.Errors, *serr)
+	}
+	e.rollupStats(inserted > 0)
+	return run
+}
+
+// syncState inserts the unknown versions of a state, returning the number
+// of inserted versions. Versions older than the state cursor were already
+// synced during a previous pass and are skipped, unless forced.
+func (e *Engine) syncState(ctx context.Context, p *providerSync, st string, force bool) (inserted int, serr *types.SyncError) {
+	versions, err := p.provider.GetVersions(st)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  st,
+			"error": err,
+		}).Error("Failed to list state versions")
+		p.fail(st, e.minBackoff, e.maxBackoff, err)
+		return inserted, newSyncError(st, "", StageVersions, err)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.Before(versions[j].LastModified)
+	})
+
+	var cursor time.Time
+	if !force {
+		cursor = p.cursor(st)
+	}
+	defer e.saveCursor(p, st, p.cursor(st))
+	for k, v := range versions {
+		if ctx.Err() != nil {
+			return
+		}
+		if !v.LastModified.After(cursor) {
+			continue
+		}
+
+		if e.isKnownVersion(v.ID) {
+			log.WithFields(log.Fields{
+				"version_id": v.ID,
+			}).Debug("Version is already in the database, skipping")
+		} else {
+			if err := e.db.InsertVersion(&versions[k]); err != nil {
+				log.Error(err.Error())
+			}
+		}
+
+		if e.isKnownStateVersion(v.ID, st) {
+			log.WithFields(log.Fields{
+				"path":       st,
+				"version_id": v.ID,
+			}).Debug("State is already in the database, skipping")
+			p.advance(st, v.LastModified)
+			continue
+		}
+
+		sf, err := p.provider.GetState(st, v.ID)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":       st,
+				"version_id": v.ID,
+				"error":      err,
+			}).Error("Failed to fetch state from bucket")
+			p.fail(st, e.minBackoff, e.maxBackoff, err)
+			return inserted, newSyncError(st, v.ID, StageFetch, err)
+		}
+		if err = e.db.InsertState(st, v.ID, sf); err != nil {
+			log.WithFields(log.Fields{
+				"path":       st,
+				"version_id": v.ID,
+				"error":      err,
+			}).Error("Failed to insert state in the database")
+			p.fail(st, e.minBackoff, e.maxBackoff, err)
+			return inserted, newSyncError(st, v.ID, StageInsert, err)
+		}
+
+		e.addKnownStateVersion(v.ID, st)
+		p.advance(st, v.LastModified)
+		inserted++
+
+		// The versions imported by the initial sync pass are not notified
+		if p.isSynced() {
+			e.notifier.StateVersion(st, v.ID, sf)
+		}
+	}
+
+	p.succeed(st)
+	return
+}
+
+// saveCursor persists the cursor of a state when it moved forward,
+// so that a restart doesn't sync its versions again
+func (e *Engine) saveCursor(p *providerSync, st string, previous time.Time) {
+	cursor := p.cursor(st)
+	if !cursor.After(previous) {
+		return
+	}
+	if err := e.db.UpdateStateCursor(p.name, st, cursor); err != nil {
+		log.WithFields(log.Fields{
+			"provider": p.name,
+			"path":     st,
+			"error":    err,
+		}).Error("Failed to record the sync cursor of a state")
+	}
+}
+
+// newSyncError builds the record of a state sync failure
+func newSyncError(path, versionID, stage string, err error) *types.SyncError {
+	return &types.SyncError{
+		Path:      path,
+		VersionID: versionID,
+		Stage:     stage,
+		Message:   err.Error(),
+		CreatedAt: time.Now(),
+	}
+}
+
+// loadKnown loads the state versions already in the database, once
+func (e *Engine) loadKnown() {
+	e.knownOnce.Do(func() {
+		known := e.db.ListStatesVersions()
+		e.knownMu.Lock()
+		e.known = known
+		e.knownMu.Unlock()
+	})
+}
+
+// isKnownVersion checks whether a version is already in the database
+func (e *Engine) isKnownVersion(versionID string) bool {
+	e.knownMu.RLock()
+	defer e.knownMu.RUnlock()
+	_, ok := e.known[versionID]
+	return ok
+}
+
+// isKnownStateVersion checks whether a state version is already in the database
+func (e *Engine) isKnownStateVersion(versionID, path string) bool {
+	e.knownMu.RLock()
+	defer e.knownMu.RUnlock()
+	return isKnownStateVersion(e.known, versionID, path)
+}
+
+// addKnownStateVersion records a state version inserted in the database
+func (e *Engine) addKnownStateVersion(versionID, path string) {
+	e.knownMu.Lock()
+	defer e.knownMu.Unlock()
+	e.known[versionID] = append(e.known[versionID], path)
+}
+
+func isKnownStateVersion(statesVersions map[string][]string, versionID, path string) bool {
+	if v, ok := statesVersions[versionID]; ok {
+		for _, s := range v {
+			if s == path {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// start marks a provider as running and creates its sync run
+func (p *providerSync) start(trigger string) *types.SyncRun {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running = true
+	return &types.SyncRun{
+		Provider:  p.name,
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+	}
+}
+
+// end marks a provider as idle and closes its sync run
+func (p *providerSync) end(run *types.SyncRun) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running = false
+	run.EndedAt = time.Now()
+}
+
+// setPaths records the states listed by the provider
+func (p *providerSync) setPaths(states []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paths = make(map[string]bool, len(states))
+	for _, st := range states {
+		p.paths[st] = true
+	}
+}
+
+// hasPath checks whether a state was listed by the provider
+func (p *providerSync) hasPath(st string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paths[st]
+}
+
+// setSynced records that the initial sync pass of the provider is done
+func (p *providerSync) setSynced() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.synced = true
+}
+
+// isSynced checks whether the initial sync pass of the provider is done
+func (p *providerSync) isSynced() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.synced
+}
+
+func (p *providerSync) setNextRun(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextRun = t
+}
+
+func (p *providerSync) getNextRun() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.nextRun
+}
+
+// cursor returns the last modification time up to which a state is synced
+func (p *providerSync) cursor(st string) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cursors[st]
+}
+
+// restoreCursors sets the cursors of the states not synced since the
+// start from the cursors recorded in the database
+func (p *providerSync) restoreCursors(paths []types.StatePath) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sp := range paths {
+		if _, ok := p.cursors[sp.Path]; !ok && sp.Cursor != nil {
+			p.cursors[sp.Path] = *sp.Cursor
+		}
+	}
+}
+
+// advance moves the cursor of a state forward
+func (p *providerSync) advance(st string, lastModified time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if lastModified.After(p.cursors[st]) {
+		p.cursors[st] = lastModified
+	}
+}
+
+// ready checks whether a state isn't waiting for its backoff delay
+func (p *providerSync) ready(st string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.backoff[st]
+	return !ok || b.ready(now)
+}
+
+// fail records a sync failure of a state
+func (p *providerSync) fail(st string, min, max time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.backoff[st]
+	if !ok {
+		b = &backoff{}
+		p.backoff[st] = b
+	}
+	delay := b.fail(time.Now(), min, max)
+	log.WithFields(log.Fields{
+		"provider": p.name,
+		"path":     st,
+		"failures": b.failures,
+		"error":    err,
+	}).Warnf("Failed to sync state, retrying in %v", delay)
+}
+
+// succeed resets the backoff of a state
+func (p *providerSync) succeed(st string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.backoff, st)
+}
@@ -0,0 +1,471 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/camptocamp/terraboard/config"
+	"github.com/camptocamp/terraboard/internal/terraform/states/statefile"
+	"github.com/camptocamp/terraboard/notify"
+	"github.com/camptocamp/terraboard/state"
+	"github.com/camptocamp/terraboard/types"
+)
+
+// fakeProvider is an in-memory state provider
+type fakeProvider struct {
+	mu       gosync.Mutex
+	versions map[string][]state.Version
+	failing  map[string]bool
+	fetched  []string
+}
+
+func (p *fakeProvider) GetLocks() (map[string]state.LockInfo, error) {
+	return map[string]state.LockInfo{}, nil
+}
+
+func (p *fakeProvider) GetStates() (states []string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for st := range p.versions {
+		states = append(states, st)
+	}
+	return
+}
+
+func (p *fakeProvider) GetVersions(st string) ([]state.Version, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing[st] {
+		return nil, fmt.Errorf("failed to list versions of %s", st)
+	}
+	return append([]state.Version{}, p.versions[st]...), nil
+}
+
+func (p *fakeProvider) GetState(st, versionID string) (*statefile.File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetched = append(p.fetched, st+"@"+versionID)
+	return statefile.Read(strings.NewReader(`{"version": 4, "serial": 1, "lineage": "lineage", "terraform_version": "1.0.0"}`))
+}
+
+// fakeDatabase is an in-memory Database
+type fakeDatabase struct {
+	mu       gosync.Mutex
+	known    map[string][]string
+	versions []string
+	states   []string
+	runs     []*types.SyncRun
+	paths    map[string]*types.StatePath // by provider:path
+	rollups  int
+}
+
+func (d *fakeDatabase) ListStatesVersions() map[string][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	known := make(map[string][]string)
+	for k, v := range d.known {
+		known[k] = append([]string{}, v...)
+	}
+	return known
+}
+
+func (d *fakeDatabase) InsertVersion(version *state.Version) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.versions = append(d.versions, version.ID)
+	return nil
+}
+
+func (d *fakeDatabase) InsertState(path string, versionID string, sf *statefile.File) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.states = append(d.states, path+"@"+versionID)
+	return nil
+}
+
+func (d *fakeDatabase) InsertSyncRun(run *types.SyncRun) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.runs = append(d.runs, run)
+	return nil
+}
+
+func (d *fakeDatabase) ListStatePaths(provider string) (paths []types.StatePath, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, sp := range d.paths {
+		if sp.Provider == provider {
+			paths = append(paths, *sp)
+		}
+	}
+	return
+}
+
+func (d *fakeDatabase) UpdateStatePaths(provider string, paths []string, removed map[string]time.Time, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.paths == nil {
+		d.paths = make(map[string]*types.StatePath)
+	}
+	for path, deletedAt := range removed {
+		t := deletedAt
+		d.paths[provider+":"+path].RemovedAt = &t
+	}
+	for _, path := range paths {
+		if sp, ok := d.paths[provider+":"+path]; ok {
+			sp.LastSeen, sp.RemovedAt = now, nil
+			continue
+		}
+		d.paths[provider+":"+path] = &types.StatePath{Path: path, Provider: provider, FirstSeen: now, LastSeen: now}
+	}
+	return nil
+}
+
+func (d *fakeDatabase) UpdateStateCursor(provider, path string, cursor time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if sp, ok := d.paths[provider+":"+path]; ok {
+		sp.Cursor = &cursor
+	}
+	return nil
+}
+
+func (d *fakeDatabase) PruneStatePaths(providers []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	configured := make(map[string]bool)
+	for _, p := range providers {
+		configured[p] = true
+	}
+	for k, sp := range d.paths {
+		if !configured[sp.Provider] {
+			delete(d.paths, k)
+		}
+	}
+	return nil
+}
+
+func (d *fakeDatabase) UpdateStatRollups(now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rollups++
+	return nil
+}
+
+func (d *fakeDatabase) syncRuns() []*types.SyncRun {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*types.SyncRun{}, d.runs...)
+}
+
+func newFakeProvider() *fakeProvider {
+	t0 := time.Unix(1600000000, 0)
+	return &fakeProvider{
+		versions: map[string][]state.Version{
+			"a.tfstate": {
+				{ID: "a2", LastModified: t0.Add(time.Hour)},
+				{ID: "a1", LastModified: t0},
+			},
+			"b.tfstate": {
+				{ID: "b1", LastModified: t0},
+			},
+		},
+		failing: map[string]bool{},
+	}
+}
+
+func TestIsKnownStateVersion_Match(t *testing.T) {
+
+	statesVersions := map[string][]string{
+		"fakeVersionID": {"myfakepath/terraform.tfstate"},
+	}
+
+	if !isKnownStateVersion(statesVersions, "fakeVersionID", "myfakepath/terraform.tfstate") {
+		t.Fatalf("Expected %t, got %t", true, false)
+	}
+}
+
+func TestIsKnownStateVersion_NoMatch(t *testing.T) {
+
+	statesVersions := map[string][]string{
+		"fakeVersionID": {"myfakepath/terraform.tfstate"},
+	}
+
+	if isKnownStateVersion(statesVersions, "VersionID", "myfakepath/terraform.tfstate") {
+		t.Fatalf("Expected %t, got %t", false, true)
+	}
+}
+
+// identifiedProvider is a fakeProvider telling where its states are
+type identifiedProvider struct {
+	*fakeProvider
+	identity string
+}
+
+func (p *identifiedProvider) Identity() string {
+	return p.identity
+}
+
+func TestProviderName(t *testing.T) {
+	if name := providerName(&fakeProvider{}, 2); name != "fakeprovider-2" {
+		t.Errorf("Unexpected provider name %s", name)
+	}
+
+	bucket := &identifiedProvider{identity: "/bucket/"}
+	name := providerName(bucket, 0)
+	if !strings.HasPrefix(name, "identifiedprovider-") || len(name) != len("identifiedprovider-")+8 {
+		t.Errorf("Unexpected provider name %s", name)
+	}
+	if other := providerName(bucket, 3); other != name {
+		t.Errorf("Expected the name to be kept when the provider is moved, got %s and %s", name, other)
+	}
+	if other := providerName(&identifiedProvider{identity: "/other-bucket/"}, 0); other == name {
+		t.Errorf("Expected providers with different identities to have different names, got %s", name)
+	}
+
+	// Providers configured twice keep distinct names
+	e := NewEngine(&fakeDatabase{}, []state.Provider{bucket, bucket}, time.Minute, 1)
+	if e.providers[0].name == e.providers[1].name {
+		t.Errorf("Expected distinct names, got %s twice", e.providers[0].name)
+	}
+}
+
+func TestSyncProviderInsertsUnknownVersions(t *testing.T) {
+	sp := newFakeProvider()
+	d := &fakeDatabase{known: map[string][]string{"a1": {"a.tfstate"}}}
+	e := NewEngine(d, []state.Provider{sp}, time.Minute, 2)
+
+	res := e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if res.Error != "" {
+		t.Fatal(res.Error)
+	}
+	if res.States != 2 || res.NewVersions != 2 || res.Failed != 0 {
+		t.Errorf("Unexpected sync result %+v", res)
+	}
+	if len(d.states) != 2 || len(sp.fetched) != 2 {
+		t.Errorf("Expected only a2 and b1 to be inserted, got %v", d.states)
+	}
+	for _, v := range d.versions {
+		if v == "a1" {
+			t.Error("Known version a1 should not be inserted again")
+		}
+	}
+
+	// A second pass has nothing new to fetch
+	res = e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if res.NewVersions != 0 || len(sp.fetched) != 2 {
+		t.Errorf("Expected no new versions on second pass, got %+v (fetched %v)", res, sp.fetched)
+	}
+	if cursor := e.providers[0].cursor("a.tfstate"); !cursor.Equal(time.Unix(1600000000, 0).Add(time.Hour)) {
+		t.Errorf("Expected cursor to point to the latest version, got %v", cursor)
+	}
+}
+
+func TestSyncProviderRestoresCursors(t *testing.T) {
+	sp := newFakeProvider()
+	d := &fakeDatabase{}
+	e := NewEngine(d, []state.Provider{sp}, time.Minute, 1)
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if len(sp.fetched) != 3 {
+		t.Fatalf("Expected all versions to be fetched, got %v", sp.fetched)
+	}
+
+	// After a restart, the synced versions are skipped
+	e = NewEngine(d, []state.Provider{sp}, time.Minute, 1)
+	res := e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if res.NewVersions != 0 || len(sp.fetched) != 3 {
+		t.Errorf("Expected no version to be fetched again, got %+v (fetched %v)", res, sp.fetched)
+	}
+	if cursor := e.providers[0].cursor("a.tfstate"); !cursor.Equal(time.Unix(1600000000, 0).Add(time.Hour)) {
+		t.Errorf("Expected the cursor to be restored, got %v", cursor)
+	}
+}
+
+func TestSyncProviderBackoff(t *testing.T) {
+	sp := newFakeProvider()
+	sp.failing["b.tfstate"] = true
+	e := NewEngine(&fakeDatabase{}, []state.Provider{sp}, time.Minute, 1)
+
+	res := e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if res.Failed != 1 || len(res.Errors) != 1 || res.Errors[0].Stage != StageVersions {
+		t.Errorf("Expected one failed state, got %+v", res)
+	}
+
+	res = e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if res.Skipped != 1 || res.Failed != 0 {
+		t.Errorf("Expected failing state to be skipped while backing off, got %+v", res)
+	}
+
+	e.providers[0].backoff["b.tfstate"].next = time.Now().Add(-time.Second)
+	sp.failing["b.tfstate"] = false
+	res = e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if res.Skipped != 0 || res.NewVersions != 1 {
+		t.Errorf("Expected state to be retried after its backoff, got %+v", res)
+	}
+	if _, ok := e.providers[0].backoff["b.tfstate"]; ok {
+		t.Error("Backoff should be reset after a successful sync")
+	}
+}
+
+// deletingProvider is a fakeProvider knowing when its states were deleted
+type deletingProvider struct {
+	*fakeProvider
+	deleted map[string]time.Time
+}
+
+func (p *deletingProvider) GetDeletion(st string) (time.Time, bool, error) {
+	t, ok := p.deleted[st]
+	return t, ok, nil
+}
+
+func TestSyncProviderTracksDeletedStates(t *testing.T) {
+	sp := &deletingProvider{fakeProvider: newFakeProvider(), deleted: map[string]time.Time{}}
+	d := &fakeDatabase{}
+	e := NewEngine(d, []state.Provider{sp}, time.Minute, 1)
+	a, b := e.providers[0].name+":a.tfstate", e.providers[0].name+":b.tfstate"
+
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if len(d.paths) != 2 || d.paths[a].RemovedAt != nil {
+		t.Fatalf("Expected the listed paths to be recorded, got %v", d.paths)
+	}
+
+	deletedAt := time.Unix(1700000000, 0)
+	sp.mu.Lock()
+	delete(sp.versions, "b.tfstate")
+	sp.mu.Unlock()
+	sp.deleted["b.tfstate"] = deletedAt
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if removed := d.paths[b].RemovedAt; removed == nil || !removed.Equal(deletedAt) {
+		t.Errorf("Expected b.tfstate to be removed at its delete marker, got %v", removed)
+	}
+	if d.paths[a].RemovedAt != nil {
+		t.Errorf("Expected a.tfstate to still be present")
+	}
+
+	// Removed paths are only recorded once
+	sp.deleted["b.tfstate"] = deletedAt.Add(time.Hour)
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if removed := d.paths[b].RemovedAt; !removed.Equal(deletedAt) {
+		t.Errorf("Expected the deletion time to be kept, got %v", removed)
+	}
+}
+
+func TestSyncProviderRollsUpStats(t *testing.T) {
+	sp := newFakeProvider()
+	d := &fakeDatabase{}
+	e := NewEngine(d, []state.Provider{sp}, time.Minute, 1)
+
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if d.rollups != 1 {
+		t.Fatalf("Expected the stats to be rolled up after the first sync, got %d rollups", d.rollups)
+	}
+
+	// Nothing changed since the snapshot of the day
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if d.rollups != 1 {
+		t.Errorf("Expected no rollup without changes, got %d rollups", d.rollups)
+	}
+
+	sp.mu.Lock()
+	delete(sp.versions, "b.tfstate")
+	sp.mu.Unlock()
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if d.rollups != 2 {
+		t.Errorf("Expected the stats to be rolled up after a deletion, got %d rollups", d.rollups)
+	}
+
+	// The first sync of a day takes its snapshot
+	e.rolledUp = "2000-01-01"
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	if d.rollups != 3 {
+		t.Errorf("Expected the stats to be rolled up on a new day, got %d rollups", d.rollups)
+	}
+}
+
+func TestSyncProviderNotifiesAfterInitialSync(t *testing.T) {
+	var mu gosync.Mutex
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}))
+	defer ts.Close()
+
+	sp := newFakeProvider()
+	e := NewEngine(&fakeDatabase{}, []state.Provider{sp}, time.Minute, 1)
+	n := notify.New([]config.WebhookConfig{{URL: ts.URL}})
+	e.SetNotifier(n)
+
+	// The versions imported by the initial sync are not notified
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+	sp.mu.Lock()
+	sp.versions["a.tfstate"] = append(sp.versions["a.tfstate"], state.Version{ID: "a3", LastModified: time.Unix(1600000000, 0).Add(2 * time.Hour)})
+	sp.mu.Unlock()
+	e.syncProvider(context.Background(), e.providers[0], TriggerSchedule)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		count := len(bodies)
+		mu.Unlock()
+		if count > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if len(bodies) != 1 || !strings.Contains(bodies[0], `"version_id":"a3"`) {
+		t.Errorf("Expected only a3 to be notified, got %v", bodies)
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := NewEngine(&fakeDatabase{}, []state.Provider{newFakeProvider()}, time.Hour, 1)
+
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run should return once the context is cancelled")
+	}
+}
+
+func TestRunPrunesFormerProviders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d := &fakeDatabase{paths: map[string]*types.StatePath{
+		"fakeprovider-0:a.tfstate": {Path: "a.tfstate", Provider: "fakeprovider-0"},
+		"aws-0:a.tfstate":          {Path: "a.tfstate", Provider: "aws-0"},
+	}}
+	e := NewEngine(d, []state.Provider{newFakeProvider()}, time.Hour, 1)
+
+	e.Run(ctx)
+	if _, ok := d.paths["aws-0:a.tfstate"]; ok || d.paths["fakeprovider-0:a.tfstate"] == nil {
+		t.Errorf("Expected only the paths of the former providers to be pruned, got %v", d.paths)
+	}
+}
@@ -0,0 +1,139 @@
+package types
+
+import "time"
+
+/*******************************************************
+ * Analysis types
+ *
+ * Used to report the issues found across the States
+ *******************************************************/
+
+// ResourceIdentity is an identity-like attribute, such as the id or the
+// ARN, of a managed resource of the latest version of a lineage
+type ResourceIdentity struct {
+	Path          string `gorm:"column:path"`
+	VersionID     string `gorm:"column:version_id"`
+	LineageValue  string `gorm:"column:lineage_value"`
+	ModulePath    string `gorm:"column:module_path"`
+	ResourceType  string `gorm:"column:type"`
+	ResourceName  string `gorm:"column:name"`
+	ResourceIndex string `gorm:"column:index"`
+	Key           string `gorm:"column:key"`
+	Value         string `gorm:"column:value"`
+}
+
+// DuplicateOwner is a resource instance managing a Duplicate.
+// Link is the page of its lineage in the web UI.
+type DuplicateOwner struct {
+	Path         string `json:"path"`
+	VersionID    string `json:"version_id"`
+	LineageValue string `json:"lineage_value"`
+	Address      string `json:"address"`
+	Link         string `json:"link"`
+}
+
+// Duplicate is a cloud object, identified by the values of its
+// identity-like attributes, managed by more than one State or address
+type Duplicate struct {
+	ResourceType string            `json:"resource_type"`
+	Identities   map[string]string `json:"identities"`
+	Owners       []DuplicateOwner  `json:"owners"`
+	Lineages     int               `json:"lineages"`
+}
+
+// Flags of a StaleLineage
+const (
+	StaleFlagStale    = "stale"
+	StaleFlagEmpty    = "empty"
+	StaleFlagOutdated = "outdated_terraform"
+	StaleFlagMissing  = "missing"
+)
+
+// StaleLineage is a lineage to review for cleanup, with the flags it was
+// reported for and the age and serial of its latest version
+type StaleLineage struct {
+	Path          string    `json:"path"`
+	LineageValue  string    `json:"lineage_value"`
+	TFVersion     string    `json:"terraform_version"`
+	Serial        int64     `json:"serial"`
+	VersionID     string    `json:"version_id"`
+	LastModified  time.Time `json:"last_modified"`
+	AgeDays       int       `json:"age_days"`
+	ResourceCount int       `json:"resource_count"`
+	Flags         []string  `json:"flags"`
+	Link          string    `json:"link"`
+}
+
+// StaleReport lists the StaleLineages for the thresholds it was built with.
+// PathsChecked tells whether the paths were checked against the providers.
+type StaleReport struct {
+	Days         int            `json:"days"`
+	MinTFVersion string         `json:"min_tf_version,omitempty"`
+	PathsChecked bool           `json:"paths_checked"`
+	Lineages     []StaleLineage `json:"lineages"`
+}
+
+// Types of a LineageEvent
+const (
+	LineageEventCreated  = "created"
+	LineageEventMoved    = "moved"
+	LineageEventCopied   = "copied"
+	LineageEventReplaced = "replaced"
+)
+
+// LineageEvent is a change of the paths of a lineage: its first version,
+// its first version at another path, moving or copying it, or a version
+// written at a path holding another lineage, replacing it
+type LineageEvent struct {
+	Type            string    `json:"type"`
+	Path            string    `json:"path"`
+	PreviousPath    string    `json:"previous_path,omitempty"`
+	PreviousLineage string    `json:"previous_lineage,omitempty"`
+	VersionID       string    `json:"version_id"`
+	Serial          int64     `json:"serial"`
+	LastModified    time.Time `json:"last_modified"`
+}
+
+// LineagePath is a path the versions of a lineage were written at.
+// ReplacedBy is the lineage written there since, and Listed tells
+// whether the providers still list it.
+type LineagePath struct {
+	Path          string     `json:"path"`
+	FirstModified time.Time  `json:"first_modified"`
+	LastModified  time.Time  `json:"last_modified"`
+	Versions      int        `json:"versions"`
+	Serial        int64      `json:"serial"`
+	ReplacedBy    string     `json:"replaced_by,omitempty"`
+	Listed        bool       `json:"listed"`
+	RemovedAt     *time.Time `json:"removed_at,omitempty"`
+}
+
+// Flags of a LineageAnomaly
+const (
+	LineageAnomalyCopied           = "copied_state"
+	LineageAnomalySerialRegression = "serial_regression"
+)
+
+// LineageAnomaly is a version of a lineage written at a second live path,
+// or whose serial is lower than the one of the previous version, usually
+// left by a bad `terraform state push`
+type LineageAnomaly struct {
+	Flag           string    `json:"flag"`
+	LineageValue   string    `json:"lineage_value"`
+	Path           string    `json:"path"`
+	OtherPath      string    `json:"other_path,omitempty"`
+	VersionID      string    `json:"version_id"`
+	Serial         int64     `json:"serial"`
+	PreviousSerial int64     `json:"previous_serial,omitempty"`
+	LastModified   time.Time `json:"last_modified"`
+	Link           string    `json:"link"`
+}
+
+// LineageTimeline is the history of the paths of a lineage
+type LineageTimeline struct {
+	LineageValue string           `json:"lineage_value"`
+	Paths        []LineagePath    `json:"paths"`
+	Events       []LineageEvent   `json:"events"`
+	Anomalies    []LineageAnomaly `json:"anomalies"`
+	Link         string           `json:"link"`
+}
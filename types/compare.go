@@ -1,5 +1,10 @@
 package types
 
+import (
+	"encoding/json"
+	"time"
+)
+
 /*******************************************************
  * Compare types
  *
@@ -15,11 +20,23 @@ type StateInfo struct {
 	Serial        int64  `json:"serial"`
 }
 
+// AttributeChange represents the change of a single value of a Resource,
+// identified by its JSON path (e.g. tags.Name or ingress[0].cidr_blocks[1]).
+// Old is omitted when the value was added, New when it was removed.
+// Sensitive values are not walked and only show their length.
+type AttributeChange struct {
+	Path      string          `json:"path"`
+	Old       json.RawMessage `json:"old,omitempty"`
+	New       json.RawMessage `json:"new,omitempty"`
+	Sensitive bool            `json:"sensitive"`
+}
+
 // ResourceDiff represents a diff between two versions of a Resource
 type ResourceDiff struct {
 	OnlyInOld   map[string]string `json:"only_in_old"`
 	OnlyInNew   map[string]string `json:"only_in_new"`
 	UnifiedDiff string            `json:"unified_diff"`
+	Changes     []AttributeChange `json:"changes"`
 }
 
 // StateCompare represents a diff between two versions of a State
@@ -35,3 +52,61 @@ type StateCompare struct {
 		ResourceDiff map[string]ResourceDiff `json:"resource_diff"`
 	} `json:"differences"`
 }
+
+// Actions of a ResourceChange
+const (
+	ResourceCreated = "created"
+	ResourceChanged = "changed"
+	ResourceRemoved = "removed"
+)
+
+// ResourceChange represents a change of a Resource in a version of a State
+type ResourceChange struct {
+	Action       string            `json:"action"`
+	Path         string            `json:"path"`
+	VersionID    string            `json:"version_id"`
+	Serial       int64             `json:"serial"`
+	TFVersion    string            `json:"terraform_version"`
+	LastModified time.Time         `json:"last_modified"`
+	Attributes   []AttributeChange `json:"attributes"`
+}
+
+// ResourceHistory represents the changes of a Resource
+// across the versions of a lineage, from the oldest to the newest
+type ResourceHistory struct {
+	Address string           `json:"address"`
+	Changes []ResourceChange `json:"changes"`
+}
+
+// Statuses of a PlanVerification
+const (
+	PlanNoChanges        = "no_changes"
+	PlanUnapplied        = "unapplied"
+	PlanPartiallyApplied = "partially_applied"
+	PlanDiverged         = "diverged"
+	PlanApplied          = "applied"
+)
+
+// Statuses of a PlannedResource
+const (
+	PlannedResourceNotApplied = "not_applied"
+	PlannedResourceDiverged   = "diverged"
+	PlannedResourceApplied    = "applied"
+)
+
+// PlannedResource is the outcome of a planned change of a Resource.
+// In its differences, Old is the planned value and New the actual one.
+type PlannedResource struct {
+	Address     string            `json:"address"`
+	Actions     []string          `json:"actions"`
+	Status      string            `json:"status"`
+	Differences []AttributeChange `json:"differences"`
+}
+
+// PlanVerification compares a Plan with the first State version
+// of its lineage that followed it
+type PlanVerification struct {
+	Status    string            `json:"status"`
+	State     *StateInfo        `json:"state,omitempty"`
+	Resources []PlannedResource `json:"resources"`
+}
@@ -2,8 +2,10 @@ package types
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
+	"github.com/camptocamp/terraboard/internal/terraform/addrs"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
@@ -23,21 +25,40 @@ type Version struct {
 
 // State is a Terraform State
 type State struct {
-	gorm.Model `json:"-"`
-	Path       string        `gorm:"index" json:"path"`
-	Version    Version       `json:"version"`
-	VersionID  sql.NullInt64 `gorm:"index" json:"-"`
-	TFVersion  string        `gorm:"varchar(10)" json:"terraform_version"`
-	Serial     int64         `json:"serial"`
-	LineageID  sql.NullInt64 `gorm:"index" json:"-"`
-	Modules    []Module      `json:"modules"`
+	gorm.Model   `json:"-"`
+	Path         string        `gorm:"index" json:"path"`
+	Version      Version       `json:"version"`
+	VersionID    sql.NullInt64 `gorm:"index" json:"-"`
+	TFVersion    string        `gorm:"varchar(10)" json:"terraform_version"`
+	Serial       int64         `json:"serial"`
+	LineageID    sql.NullInt64 `gorm:"index" json:"-"`
+	Modules      []Module      `json:"modules"`
+	RemoteStates []RemoteState `json:"remote_states,omitempty"`
 }
 
+// Lineage is the identity of a State across its versions.
+// It is archived once the path of its latest version is deleted.
 type Lineage struct {
 	gorm.Model
-	Value  string  `gorm:"index;unique" json:"lineage"`
-	States []State `json:"states"`
-	Plans  []Plan  `json:"plans"`
+	Value      string     `gorm:"index;unique" json:"lineage"`
+	ArchivedAt *time.Time `gorm:"index" json:"archived_at,omitempty"`
+	States     []State    `json:"states"`
+	Plans      []Plan     `json:"plans"`
+}
+
+// StatePath is the presence of a State path at a provider, as seen by
+// the syncs. RemovedAt is set once the provider no longer lists it. The
+// same path can be listed by several providers, e.g. the same key in two
+// buckets. Cursor is the last modification time up to which its versions
+// are synced, kept across restarts.
+type StatePath struct {
+	ID        uint       `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
+	Path      string     `gorm:"uniqueIndex:idx_state_paths_provider_path,priority:2" json:"path"`
+	Provider  string     `gorm:"uniqueIndex:idx_state_paths_provider_path,priority:1" json:"provider"`
+	FirstSeen time.Time  `json:"first_seen"`
+	LastSeen  time.Time  `json:"last_seen"`
+	RemovedAt *time.Time `gorm:"index" json:"removed_at"`
+	Cursor    *time.Time `json:"-"`
 }
 
 // Module is a Terraform module in a State
@@ -49,14 +70,78 @@ type Module struct {
 	OutputValues []OutputValue `json:"outputs"`
 }
 
-// Resource is a Terraform resource in a Module
+// Modes of a Resource
+const (
+	ResourceModeManaged = "managed"
+	ResourceModeData    = "data"
+)
+
+// Statuses of a Resource or DeposedObject
+const (
+	ResourceStatusReady   = "ready"
+	ResourceStatusTainted = "tainted"
+	ResourceStatusPlanned = "planned"
+)
+
+// Resource is a Terraform resource instance in a Module.
+// Its Status is empty when the instance only has deposed objects.
 type Resource struct {
-	ID         uint          `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
-	ModuleID   sql.NullInt64 `gorm:"index" json:"-"`
-	Type       string        `gorm:"index" json:"type"`
-	Name       string        `gorm:"index" json:"name"`
-	Index      string        `gorm:"index" json:"index"`
-	Attributes []Attribute   `json:"attributes"`
+	ID                  uint            `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
+	ModuleID            sql.NullInt64   `gorm:"index" json:"-"`
+	Mode                string          `gorm:"index" json:"mode"`
+	Type                string          `gorm:"index" json:"type"`
+	Name                string          `gorm:"index" json:"name"`
+	Index               string          `gorm:"index" json:"index"`
+	Provider            string          `gorm:"index" json:"provider"`
+	SchemaVersion       uint64          `json:"schema_version"`
+	Status              string          `gorm:"index" json:"status"`
+	CreateBeforeDestroy bool            `json:"create_before_destroy"`
+	Dependencies        datatypes.JSON  `json:"dependencies" swaggertype:"array,string"`
+	Attributes          []Attribute     `json:"attributes"`
+	DeposedObjects      []DeposedObject `json:"deposed_objects"`
+}
+
+// Address returns the address of a resource instance in a module, e.g.
+// module.vpc["prod"].aws_subnet.private[0], in the canonical form of
+// addrs.AbsResourceInstance. Module paths which aren't valid Terraform
+// module addresses are kept as is.
+func (r Resource) Address(modulePath string) string {
+	addr := fmt.Sprintf("%s.%s%s", r.Type, r.Name, r.Index)
+	if r.Mode == ResourceModeData {
+		addr = "data." + addr
+	}
+	if modulePath != "" {
+		addr = fmt.Sprintf("%s.%s", modulePath, addr)
+	}
+	if abs, diags := addrs.ParseAbsResourceInstanceStr(addr); !diags.HasErrors() {
+		return abs.String()
+	}
+	return addr
+}
+
+// DeposedObject is a deposed object of a Resource instance, left over by
+// a create_before_destroy replacement and pending destruction
+type DeposedObject struct {
+	ID                  uint           `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
+	ResourceID          sql.NullInt64  `gorm:"index" json:"-"`
+	Key                 string         `gorm:"index" json:"key"`
+	SchemaVersion       uint64         `json:"schema_version"`
+	Status              string         `gorm:"index" json:"status"`
+	CreateBeforeDestroy bool           `json:"create_before_destroy"`
+	Dependencies        datatypes.JSON `json:"dependencies" swaggertype:"array,string"`
+	Attributes          []Attribute    `json:"attributes"`
+}
+
+// RemoteState is a terraform_remote_state data source of a State,
+// identifying the upstream State whose outputs it reads.
+// Config only holds the backend settings locating that State.
+type RemoteState struct {
+	ID        uint           `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
+	StateID   sql.NullInt64  `gorm:"index" json:"-"`
+	Address   string         `json:"address"`
+	Backend   string         `gorm:"index" json:"backend"`
+	Workspace string         `json:"workspace"`
+	Config    datatypes.JSON `json:"config" swaggertype:"object"`
 }
 
 // OutputValue is a Terraform output in a Module
@@ -68,13 +153,28 @@ type OutputValue struct {
 	Value     string        `json:"value"`
 }
 
-// Attribute is a Terraform attribute in a Resource
+// Attribute is a Terraform attribute in a Resource or a DeposedObject
 type Attribute struct {
-	ID         uint          `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
-	ResourceID sql.NullInt64 `gorm:"index" json:"-"`
-	Key        string        `gorm:"index" json:"key"`
-	Value      string        `json:"value"`
-	Sensitive  bool          `gorm:"index" json:"sensitive"`
+	ID              uint            `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
+	ResourceID      sql.NullInt64   `gorm:"index" json:"-"`
+	DeposedObjectID sql.NullInt64   `gorm:"index" json:"-"`
+	Key             string          `gorm:"index" json:"key"`
+	Value           string          `json:"value"`
+	Sensitive       bool            `gorm:"index" json:"sensitive"`
+	Paths           []AttributePath `json:"-"`
+}
+
+// AttributePath is a leaf of the value of a non-sensitive Attribute,
+// indexed by its JSON path (e.g. ingress[0].cidr_blocks[1]).
+// Key is the path with the list indices replaced by [*],
+// and Number the value of numeric leaves.
+type AttributePath struct {
+	ID          uint            `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
+	AttributeID sql.NullInt64   `gorm:"index" json:"-"`
+	Path        string          `gorm:"index" json:"path"`
+	Key         string          `gorm:"index" json:"key"`
+	Value       string          `json:"value"`
+	Number      sql.NullFloat64 `gorm:"index" json:"-"`
 }
 
 // Plan is a Terraform plan
@@ -91,6 +191,10 @@ type Plan struct {
 	ParsedPlan   PlanModel      `json:"parsed_plan"`
 	ParsedPlanID sql.NullInt64  `gorm:"index" json:"-"`
 	PlanJSON     datatypes.JSON `json:"plan_json"`
+	PriorSerial  *int64         `json:"prior_serial,omitempty"`
+
+	// Verification is computed against the state when the plan is fetched
+	Verification *PlanVerification `gorm:"-" json:"verification,omitempty"`
 }
 
 // PlanModel represents the entire contents of an output Terraform plan.
@@ -338,3 +442,73 @@ type PlanModelVariable struct {
 	Key         string        `gorm:"index" json:"key"`
 	Value       string        `json:"value,omitempty"`
 }
+
+// Severities of the Findings
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+)
+
+// Finding is a secret leaked in a non-sensitive attribute or output
+// of a State, detected by a scan rule. Match only holds a masked
+// excerpt of the secret.
+type Finding struct {
+	ID            uint          `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"id"`
+	StateID       sql.NullInt64 `gorm:"index" json:"-"`
+	RuleID        string        `gorm:"index" json:"rule_id"`
+	Severity      string        `gorm:"index" json:"severity"`
+	Description   string        `json:"description"`
+	Address       string        `json:"address"`
+	AttributePath string        `json:"attribute_path,omitempty"`
+	Match         string        `json:"match"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Path          string        `gorm:"->;-:migration" json:"path"`
+	VersionID     string        `gorm:"->;-:migration" json:"version_id"`
+	LineageValue  string        `gorm:"->;-:migration" json:"lineage_value"`
+}
+
+// Metrics of a StatRollup
+const (
+	StatMetricResources = "resources"
+	StatMetricStates    = "states"
+)
+
+// StatRollup is the daily count of a metric of the inventory, over the
+// latest version of the live States, for a key of one of its dimensions,
+// e.g. the resources of type aws_instance or the states using Terraform 0.12.31
+type StatRollup struct {
+	ID        uint      `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
+	Day       time.Time `gorm:"type:date;uniqueIndex:idx_stat_rollups_key" json:"day"`
+	Metric    string    `gorm:"uniqueIndex:idx_stat_rollups_key" json:"metric"`
+	Dimension string    `gorm:"uniqueIndex:idx_stat_rollups_key" json:"dimension"`
+	Key       string    `gorm:"uniqueIndex:idx_stat_rollups_key" json:"key"`
+	Count     int64     `json:"count"`
+}
+
+// SyncRun is a sync pass over the states of a provider
+type SyncRun struct {
+	ID          uint        `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"id"`
+	Provider    string      `gorm:"index" json:"provider"`
+	Trigger     string      `json:"trigger"`
+	StartedAt   time.Time   `gorm:"index" json:"started_at"`
+	EndedAt     time.Time   `json:"ended_at"`
+	States      int         `json:"states"`
+	Skipped     int         `json:"skipped"`
+	Failed      int         `json:"failed"`
+	NewVersions int         `json:"new_versions"`
+	Error       string      `json:"error,omitempty"`
+	Errors      []SyncError `json:"errors"`
+}
+
+// SyncError is a failure to sync a state during a SyncRun
+type SyncError struct {
+	ID        uint      `sql:"AUTO_INCREMENT" gorm:"primary_key" json:"-"`
+	SyncRunID uint      `gorm:"index" json:"-"`
+	Path      string    `gorm:"index" json:"path"`
+	VersionID string    `json:"version_id,omitempty"`
+	Stage     string    `json:"stage"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
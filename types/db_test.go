@@ -1,3 +1,21 @@
 package types
 
-// TODO: test db types
+import "testing"
+
+func TestResourceAddress(t *testing.T) {
+	for _, tc := range []struct {
+		modulePath string
+		resource   Resource
+		expected   string
+	}{
+		{"", Resource{Mode: ResourceModeManaged, Type: "aws_instance", Name: "web"}, "aws_instance.web"},
+		{"", Resource{Mode: ResourceModeData, Type: "aws_ami", Name: "ubuntu"}, "data.aws_ami.ubuntu"},
+		{"module.vpc", Resource{Type: "aws_subnet", Name: "private", Index: "[0]"}, "module.vpc.aws_subnet.private[0]"},
+		{`module.vpc["prod"]`, Resource{Type: "aws_subnet", Name: "private", Index: `["a"]`}, `module.vpc["prod"].aws_subnet.private["a"]`},
+		{"not a module", Resource{Type: "aws_subnet", Name: "private"}, "not a module.aws_subnet.private"},
+	} {
+		if addr := tc.resource.Address(tc.modulePath); addr != tc.expected {
+			t.Errorf("Expected %s, got %s", tc.expected, addr)
+		}
+	}
+}
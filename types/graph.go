@@ -0,0 +1,77 @@
+package types
+
+import "gorm.io/datatypes"
+
+/*******************************************************
+ * Graph types
+ *
+ * Used to represent the dependencies between resources
+ *******************************************************/
+
+// GraphNode is a resource instance in a dependency Graph.
+// Depth is the distance to the target of a blast radius query.
+type GraphNode struct {
+	Address  string `json:"address"`
+	Module   string `json:"module"`
+	Mode     string `json:"mode"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Index    string `json:"index"`
+	Provider string `json:"provider"`
+	Status   string `json:"status"`
+	Depth    int    `json:"depth,omitempty"`
+}
+
+// GraphEdge is a dependency of the From resource instance on the To one
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Graph is the dependency graph of the resources of a State
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// StackReference is a terraform_remote_state data source of the latest
+// version of a State, read by the Stack at Path from an upstream State
+type StackReference struct {
+	Path         string         `json:"path"`
+	LineageValue string         `json:"lineage_value"`
+	Address      string         `json:"address"`
+	Backend      string         `json:"backend"`
+	Workspace    string         `json:"workspace"`
+	Config       datatypes.JSON `json:"config" swaggertype:"object"`
+}
+
+// StackNode is a State in a StackGraph
+type StackNode struct {
+	Path         string `json:"path"`
+	LineageValue string `json:"lineage_value"`
+}
+
+// StackEdge is a dependency of the From State on the outputs of the To
+// State, through the terraform_remote_state data sources at Addresses
+type StackEdge struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Addresses []string `json:"addresses"`
+}
+
+// StackGraph is the graph of the dependencies between States.
+// Unresolved holds the data sources whose upstream State is unknown.
+type StackGraph struct {
+	Nodes      []StackNode      `json:"nodes"`
+	Edges      []StackEdge      `json:"edges"`
+	Unresolved []StackReference `json:"unresolved"`
+}
+
+// StackConsumer is a State reading the outputs of another State,
+// directly or through Depth-1 intermediate States
+type StackConsumer struct {
+	Path         string   `json:"path"`
+	LineageValue string   `json:"lineage_value"`
+	Addresses    []string `json:"addresses"`
+	Depth        int      `json:"depth"`
+}
@@ -16,22 +16,54 @@ type SearchResult struct {
 	Serial         int64  `gorm:"column:serial" json:"serial"`
 	LineageValue   string `json:"lineage_value"`
 	ModulePath     string `gorm:"column:module_path" json:"module_path"`
+	ResourceMode   string `gorm:"column:mode" json:"resource_mode"`
 	ResourceType   string `gorm:"column:type" json:"resource_type"`
 	ResourceName   string `gorm:"column:name" json:"resource_name"`
 	ResourceIndex  string `gorm:"column:index" json:"resource_index"`
+	Provider       string `gorm:"column:provider" json:"provider"`
+	ResourceStatus string `gorm:"column:status" json:"resource_status"`
+	DeposedCount   int    `gorm:"column:deposed_count" json:"deposed_count"`
 	AttributeKey   string `gorm:"column:key" json:"attribute_key"`
 	AttributeValue string `gorm:"column:value" json:"attribute_value"`
 	Sensitive      bool   `gorm:"column:sensitive" json:"sensitive"`
+	AttributePath  string `gorm:"column:attribute_path" json:"attribute_path,omitempty"`
+	PathValue      string `gorm:"column:path_value" json:"path_value,omitempty"`
 }
 
 // StateStat stores State stats
 // NOTE: do we want to merge this with StateInfo?
 type StateStat struct {
-	Path          string    `json:"path"`
-	LineageValue  string    `json:"lineage_value"`
-	TFVersion     string    `json:"terraform_version"`
-	Serial        int64     `json:"serial"`
-	VersionID     string    `json:"version_id"`
-	LastModified  time.Time `json:"last_modified"`
-	ResourceCount int       `json:"resource_count"`
+	Path          string     `json:"path"`
+	LineageValue  string     `json:"lineage_value"`
+	TFVersion     string     `json:"terraform_version"`
+	Serial        int64      `json:"serial"`
+	VersionID     string     `json:"version_id"`
+	LastModified  time.Time  `json:"last_modified"`
+	ResourceCount int        `json:"resource_count"`
+	ArchivedAt    *time.Time `json:"archived_at,omitempty"`
+}
+
+// DayFormat is the format of the days of a TimeSeries
+const DayFormat = "2006-01-02"
+
+// TimeSeriesPoint is the count of a TimeSeries on a day
+type TimeSeriesPoint struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// TimeSeries is the daily count of a metric for a key of the dimension
+// it is grouped by
+type TimeSeries struct {
+	Key    string            `json:"key"`
+	Points []TimeSeriesPoint `json:"points"`
+}
+
+// TimeSeriesResult stores the series of a metric between two days
+type TimeSeriesResult struct {
+	Metric  string       `json:"metric"`
+	GroupBy string       `json:"group_by,omitempty"`
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Series  []TimeSeries `json:"series"`
 }